@@ -1,6 +1,10 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	jmsg "github.com/amagimedia/judo/v3/message"
 )
 
@@ -8,5 +12,46 @@ type JudoClient interface {
 	Configure([]interface{}) error
 	OnMessage(func(msg jmsg.Message)) JudoClient
 	Start() (<-chan error, error)
-	Close()
+	// Close stops delivering messages and blocks until the callbacks in
+	// flight have returned before closing the connection. A callback
+	// closing its own client would wait for itself, so it calls Close on
+	// another goroutine.
+	Close() error
+}
+
+// ErrNoCallback is returned by Start when OnMessage was not called first.
+var ErrNoCallback = errors.New("No callback set, call OnMessage before Start.")
+
+// PanicError is reported on the error channel when a callback panics. The
+// message is nacked and the client keeps running.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Recovered from panic in callback: %v", e.Value)
+}
+
+// Run starts c and blocks until ctx is done or c reports an error other than
+// a *PanicError. The client is closed before Run returns. Cancelling ctx is a
+// clean shutdown, in which case the error from Close is returned.
+func Run(ctx context.Context, c JudoClient) error {
+	errs, err := c.Start()
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return c.Close()
+		case err = <-errs:
+			if _, ok := err.(*PanicError); ok {
+				continue
+			}
+			c.Close()
+			return err
+		}
+	}
 }
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+)
+
+// Handler handles a received message, as passed to OnMessage.
+type Handler func(jmsg.Message)
+
+// Middleware wraps a Handler with behaviour shared by every handler, such as
+// logging, metrics or validation.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in mw. The first middleware is the outermost, so it sees the
+// message first and returns last.
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// ErrorHandler handles a message and reports failure by returning an error.
+// It must not ack or nack the message itself.
+type ErrorHandler func(context.Context, jmsg.Message) error
+
+// HandleErrors adapts h for OnMessage. A nil error acks the message and any
+// other error nacks it with the error text, leaving redelivery to the
+// transport or to the retry policy of the subscriber. Each call of h gets a
+// context derived from ctx, which is also cancelled when the subscriber
+// shuts down, see jmsg.ContextOf.
+func HandleErrors(ctx context.Context, h ErrorHandler) Handler {
+	return func(msg jmsg.Message) {
+		call, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-jmsg.ContextOf(msg).Done():
+				cancel()
+			case <-call.Done():
+			}
+		}()
+		if err := h(call, msg); err != nil {
+			msg.SendNack([]byte(err.Error()))
+			return
+		}
+		msg.SendAck()
+	}
+}
+
+// Use returns c with mw applied to every callback later passed to OnMessage.
+// A client that applies middleware itself, as judo clients do, is returned
+// as is, so that its optional interfaces, such as StatusNotifier, stay
+// visible. Other clients are wrapped, and Unwrap gives them back for type
+// assertions.
+func Use(c JudoClient, mw ...Middleware) JudoClient {
+	if u, ok := c.(interface{ Use(...Middleware) }); ok {
+		u.Use(mw...)
+		return c
+	}
+	return &middlewareClient{c, mw}
+}
+
+type middlewareClient struct {
+	JudoClient
+	mw []Middleware
+}
+
+func (c *middlewareClient) OnMessage(callback func(jmsg.Message)) JudoClient {
+	c.JudoClient.OnMessage(Chain(callback, c.mw...))
+	return c
+}
+
+// Unwrap returns the client middleware was applied to.
+func (c *middlewareClient) Unwrap() JudoClient {
+	return c.JudoClient
+}
+
+// Validate nacks messages for which validate returns an error instead of
+// passing them on.
+func Validate(validate func(jmsg.Message) error) Middleware {
+	return func(next Handler) Handler {
+		return func(msg jmsg.Message) {
+			if err := validate(msg); err != nil {
+				msg.SendNack([]byte(err.Error()))
+				return
+			}
+			next(msg)
+		}
+	}
+}
+
+// ContentEncodingHeader names the header that marks a compressed payload.
+const ContentEncodingHeader = "content-encoding"
+
+// Decompress inflates payloads whose content-encoding header is gzip, as
+// published through publisher.Compress. Payloads that fail to inflate are
+// nacked.
+func Decompress() Middleware {
+	return func(next Handler) Handler {
+		return func(msg jmsg.Message) {
+			if enc, _ := msg.GetHeader(ContentEncodingHeader); enc != "gzip" {
+				next(msg)
+				return
+			}
+			reader, err := gzip.NewReader(bytes.NewReader(msg.GetMessage()))
+			if err != nil {
+				msg.SendNack([]byte(err.Error()))
+				return
+			}
+			body, err := ioutil.ReadAll(reader)
+			if err != nil {
+				msg.SendNack([]byte(err.Error()))
+				return
+			}
+			next(msg.SetMessage(body))
+		}
+	}
+}
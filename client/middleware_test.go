@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	gredis "github.com/go-redis/redis"
+)
+
+type fakeClient struct {
+	JudoClient
+	callback func(jmsg.Message)
+}
+
+func (c *fakeClient) OnMessage(callback func(jmsg.Message)) JudoClient {
+	c.callback = callback
+	return c
+}
+
+// usingClient applies middleware itself, as judo clients do.
+type usingClient struct {
+	fakeClient
+	mw []Middleware
+}
+
+func (c *usingClient) Use(mw ...Middleware) {
+	c.mw = append(c.mw, mw...)
+}
+
+func (c *usingClient) OnMessage(callback func(jmsg.Message)) JudoClient {
+	c.callback = Chain(callback, c.mw...)
+	return c
+}
+
+func newMessage(body []byte, header map[string]string) *jmsg.RedisMessage {
+	return &jmsg.RedisMessage{
+		RawMessage: jmsg.RedisRawMessage{Message: &gredis.Message{Payload: string(body)}},
+		Properties: map[string]string{},
+		Header:     header,
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	cases := []string{"order", "use", "use-native", "validate", "decompress", "decompress-corrupt", "handle-errors"}
+	for _, c := range cases {
+		switch c {
+		case "order":
+			var trace []string
+			tag := func(name string) Middleware {
+				return func(next Handler) Handler {
+					return func(msg jmsg.Message) {
+						trace = append(trace, name+"-in")
+						next(msg)
+						trace = append(trace, name+"-out")
+					}
+				}
+			}
+			h := Chain(func(jmsg.Message) { trace = append(trace, "handler") }, tag("a"), tag("b"))
+			h(newMessage(nil, nil))
+			if got := len(trace); got != 5 || trace[0] != "a-in" || trace[1] != "b-in" || trace[2] != "handler" || trace[4] != "a-out" {
+				t.Errorf("%s: unexpected order %v", c, trace)
+			}
+		case "use":
+			inner := &fakeClient{}
+			seen := false
+			wrapped := Use(inner, func(next Handler) Handler {
+				return func(msg jmsg.Message) {
+					seen = true
+					next(msg)
+				}
+			})
+			called := false
+			if wrapped.OnMessage(func(jmsg.Message) { called = true }) != wrapped {
+				t.Errorf("%s: OnMessage does not return the wrapper", c)
+			}
+			inner.callback(newMessage(nil, nil))
+			if !seen || !called {
+				t.Errorf("%s: middleware not applied", c)
+			}
+			if wrapped.(interface{ Unwrap() JudoClient }).Unwrap() != inner {
+				t.Errorf("%s: Unwrap does not return the client", c)
+			}
+		case "use-native":
+			inner := &usingClient{}
+			seen := false
+			wrapped := Use(inner, func(next Handler) Handler {
+				return func(msg jmsg.Message) {
+					seen = true
+					next(msg)
+				}
+			})
+			if wrapped != inner {
+				t.Errorf("%s: client wrapped although it applies middleware itself", c)
+			}
+			wrapped.OnMessage(func(jmsg.Message) {})
+			inner.callback(newMessage(nil, nil))
+			if !seen {
+				t.Errorf("%s: middleware not applied", c)
+			}
+		case "handle-errors":
+			type key struct{}
+			ctx := context.WithValue(context.Background(), key{}, "v")
+			h := HandleErrors(ctx, func(ctx context.Context, msg jmsg.Message) error {
+				if ctx.Value(key{}) != "v" {
+					t.Errorf("%s: context not passed on", c)
+				}
+				if string(msg.GetMessage()) == "bad" {
+					return errors.New("bad payload")
+				}
+				return nil
+			})
+			good, bad := newMessage([]byte("good"), nil), newMessage([]byte("bad"), nil)
+			h(good)
+			h(bad)
+			if val, _ := good.GetProperty("ack"); val != "OK" {
+				t.Errorf("%s: nil error did not ack", c)
+			}
+			if val, _ := bad.GetProperty("ack"); val != "NOK" {
+				t.Errorf("%s: error did not nack", c)
+			}
+
+			// The call ends early once the subscriber shuts down.
+			shutdown, stop := context.WithCancel(context.Background())
+			msg := newMessage([]byte("slow"), nil)
+			msg.Settlement = &jmsg.Settlement{}
+			msg.Settlement.SetContext(shutdown)
+			HandleErrors(ctx, func(ctx context.Context, msg jmsg.Message) error {
+				stop()
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+					t.Errorf("%s: context not cancelled on shutdown", c)
+					return nil
+				}
+			})(msg)
+		case "validate":
+			called := false
+			h := Chain(func(jmsg.Message) { called = true }, Validate(func(msg jmsg.Message) error {
+				if len(msg.GetMessage()) == 0 {
+					return errors.New("empty payload")
+				}
+				return nil
+			}))
+			msg := newMessage(nil, nil)
+			h(msg)
+			if val, _ := msg.GetProperty("ack"); called || val != "NOK" {
+				t.Errorf("%s: invalid message not nacked", c)
+			}
+			h(newMessage([]byte("{}"), nil))
+			if !called {
+				t.Errorf("%s: valid message not passed on", c)
+			}
+		case "decompress", "decompress-corrupt":
+			var buf bytes.Buffer
+			writer := gzip.NewWriter(&buf)
+			writer.Write([]byte("hello"))
+			writer.Close()
+			body := buf.Bytes()
+			if c == "decompress-corrupt" {
+				body = []byte("hello")
+			}
+			var got []byte
+			h := Chain(func(msg jmsg.Message) { got = msg.GetMessage() }, Decompress())
+			msg := newMessage(body, map[string]string{ContentEncodingHeader: "gzip"})
+			h(msg)
+			switch c {
+			case "decompress":
+				if string(got) != "hello" {
+					t.Errorf("%s: expected hello, got %q", c, got)
+				}
+			case "decompress-corrupt":
+				if val, _ := msg.GetProperty("ack"); got != nil || val != "NOK" {
+					t.Errorf("%s: corrupt payload not nacked", c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+// State is the connection state of a client that reconnects on its own.
+type State int
+
+const (
+	Connected State = iota
+	Disconnected
+	Reconnecting
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// Status describes a connection state change. Attempt counts reconnect
+// attempts since the connection was lost and Err holds the reason for the
+// change, if any.
+type Status struct {
+	State   State
+	Attempt int
+	Err     error
+}
+
+// StatusNotifier is implemented by clients that recover from connection loss
+// instead of reporting it on the error channel. Events are dropped when the
+// channel is not drained.
+type StatusNotifier interface {
+	Status() <-chan Status
+}
@@ -2,12 +2,18 @@ package config
 
 import (
 	"errors"
-	"github.com/streadway/amqp"
+	"math"
 	"reflect"
+	"time"
 )
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 type ConfigHelper struct {
 	Config
+	// Strict makes ValidateAndSet, and so Load, behave like
+	// ValidateAndSetStrict.
+	Strict bool
 }
 
 type Config interface {
@@ -16,9 +22,7 @@ type Config interface {
 	GetField(string) string
 }
 
-func (c ConfigHelper) set(key string, val interface{}) error {
-	var err error
-	err = nil
+func (c ConfigHelper) set(key string, val interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = errors.New("Invalid Type found for config " + key)
@@ -37,27 +41,59 @@ func (c ConfigHelper) set(key string, val interface{}) error {
 	if !field.IsValid() {
 		return errors.New("Invalid field key " + fieldName)
 	}
+	if field.Kind() == reflect.Ptr && val != nil && field.CanSet() {
+		// A pointer field tells a key set to its zero value from a missing
+		// key, which leaves it nil.
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
 
 	switch val.(type) {
 	case string:
-		if field.CanSet() {
+		if field.CanSet() && field.Type() == durationType {
+			d, err := time.ParseDuration(val.(string))
+			if err != nil {
+				return errors.New("Invalid Type found for config " + key)
+			}
+			field.SetInt(int64(d))
+		} else if field.CanSet() {
 			field.SetString(val.(string))
 		}
+	case time.Duration:
+		if field.CanSet() {
+			field.Set(reflect.ValueOf(val))
+		}
 	case []string:
 		if field.CanSet() {
 			field.Set(reflect.ValueOf(val.([]string)))
 		}
+	case []interface{}:
+		if field.CanSet() {
+			strs := make([]string, len(val.([]interface{})))
+			for i, item := range val.([]interface{}) {
+				str, ok := item.(string)
+				if !ok {
+					return errors.New("Invalid Type found for config " + key)
+				}
+				strs[i] = str
+			}
+			field.Set(reflect.ValueOf(strs))
+		}
 	case bool:
 		if field.CanSet() {
 			field.SetBool(val.(bool))
 		}
-	case float64:
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		if field.CanSet() {
-			field.SetFloat(val.(float64))
+			return setNumber(field, key, val)
+		}
+	case map[string]interface{}:
+		if field.CanSet() {
+			field.Set(reflect.ValueOf(val).Convert(field.Type()))
 		}
 	case nil:
 		if field.CanSet() {
-			field.Set(reflect.ValueOf(val.(amqp.Table)))
+			field.Set(reflect.Zero(field.Type()))
 		}
 	default:
 		return errors.New("Unknown Type for " + fieldName)
@@ -67,7 +103,86 @@ func (c ConfigHelper) set(key string, val interface{}) error {
 
 }
 
+// setNumber stores a number of any type in a numeric field. Whole numbers,
+// such as the float64 values decoded from JSON, can be stored in int fields.
+// A number stored in a time.Duration field counts milliseconds.
+func setNumber(field reflect.Value, key string, val interface{}) error {
+	num := reflect.ValueOf(val).Convert(reflect.TypeOf(float64(0))).Float()
+	if field.Type() == durationType {
+		num = math.Round(num * float64(time.Millisecond))
+	}
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(num)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if num != math.Trunc(num) || field.OverflowInt(int64(num)) {
+			return errors.New("Invalid Type found for config " + key)
+		}
+		field.SetInt(int64(num))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if num < 0 || num != math.Trunc(num) || field.OverflowUint(uint64(num)) {
+			return errors.New("Invalid Type found for config " + key)
+		}
+		field.SetUint(uint64(num))
+	default:
+		return errors.New("Invalid Type found for config " + key)
+	}
+	return nil
+}
+
+// Load sets the config from cfg, which is either the map form read by
+// ValidateAndSet or a typed config struct, such as the Config of a publisher,
+// given by value or by pointer. Zero fields of a struct count as missing.
+func (c ConfigHelper) Load(cfg interface{}) error {
+	m, err := ToMap(cfg)
+	if err != nil {
+		return err
+	}
+	return c.ValidateAndSet(m)
+}
+
+// ToMap converts a typed config struct into the map form, leaving out zero
+// fields. A map is returned as is.
+func ToMap(cfg interface{}) (map[string]interface{}, error) {
+	if m, ok := cfg.(map[string]interface{}); ok {
+		return m, nil
+	}
+	typed, ok := cfg.(Config)
+	if !ok && cfg != nil {
+		ptr := reflect.New(reflect.TypeOf(cfg))
+		ptr.Elem().Set(reflect.ValueOf(cfg))
+		typed, ok = ptr.Interface().(Config)
+	}
+	if !ok {
+		return nil, errors.New("Invalid config, expected a map or a config struct")
+	}
+	e := reflect.Indirect(reflect.ValueOf(typed))
+	if e.Kind() != reflect.Struct {
+		return nil, errors.New("Not a struct type")
+	}
+
+	m := make(map[string]interface{})
+	for _, key := range typed.GetKeys() {
+		field := e.FieldByName(typed.GetField(key))
+		if !field.IsValid() || field.IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Map {
+			m[key] = field.Convert(reflect.TypeOf(map[string]interface{}{})).Interface()
+			continue
+		}
+		m[key] = field.Interface()
+	}
+	return m, nil
+}
+
 func (c ConfigHelper) ValidateAndSet(cfg map[string]interface{}) error {
+	if c.Strict {
+		return c.ValidateAndSetStrict(cfg)
+	}
 
 	var err error
 	allKeys := c.GetKeys()
@@ -91,6 +206,30 @@ func (c ConfigHelper) ValidateAndSet(cfg map[string]interface{}) error {
 
 }
 
+// ValidateAndSetStrict sets every valid key of cfg and returns all problems
+// found as Errors: missing mandatory keys, values of the wrong type and keys
+// the config does not know, as *UnknownKeyError.
+func (c ConfigHelper) ValidateAndSetStrict(cfg map[string]interface{}) error {
+	var errs Errors
+	for _, key := range c.GetKeys() {
+		val, ok := cfg[key]
+		if !ok {
+			if stringInSlice(key, c.GetMandatoryKeys()) {
+				errs = append(errs, errors.New("Key Missing : "+key))
+			}
+			continue
+		}
+		if err := c.set(key, val); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, UnknownKeys(c.Config, cfg)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 func stringInSlice(value string, slice []string) bool {
 	for _, val := range slice {
 		if val == value {
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type testConfig struct {
+	Name    string
+	DB      int
+	Port    uint16
+	Timeout float64
+	Keys    []string
+	Args    amqp.Table
+	Retries *int
+	Wait    time.Duration
+}
+
+var testFields = map[string]string{
+	"name":    "Name",
+	"db":      "DB",
+	"port":    "Port",
+	"timeout": "Timeout",
+	"keys":    "Keys",
+	"args":    "Args",
+	"retries": "Retries",
+	"wait":    "Wait",
+}
+
+func (c testConfig) GetKeys() []string {
+	return []string{"name", "db", "port", "timeout", "keys", "args", "retries", "wait"}
+}
+
+func (c testConfig) GetMandatoryKeys() []string {
+	return []string{"name"}
+}
+
+func (c testConfig) GetField(key string) string {
+	return testFields[key]
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  interface{}
+		err  string
+	}{
+		{"map", map[string]interface{}{"name": "a", "db": float64(2), "port": float64(6379), "timeout": 1.5}, ""},
+		{"int-timeout", map[string]interface{}{"name": "a", "db": 2, "port": 6379, "timeout": 1}, ""},
+		{"typed", testConfig{Name: "a", DB: 2, Port: 6379, Timeout: 1.5, Keys: []string{"x"}, Args: amqp.Table{"x-max-priority": 10}, Wait: time.Second}, ""},
+		{"typed-pointer", &testConfig{Name: "a", DB: 2}, ""},
+		{"zero-pointer", map[string]interface{}{"name": "a", "retries": 0}, ""},
+		{"typed-zero-pointer", testConfig{Name: "a", Retries: new(int)}, ""},
+		{"duration", map[string]interface{}{"name": "a", "wait": float64(1500)}, ""},
+		{"duration-string", map[string]interface{}{"name": "a", "wait": "1.5s"}, ""},
+		{"duration-invalid", map[string]interface{}{"name": "a", "wait": "soon"}, "Invalid Type found for config wait"},
+		{"fraction", map[string]interface{}{"name": "a", "db": 2.5}, "Invalid Type found for config db"},
+		{"overflow", map[string]interface{}{"name": "a", "port": float64(70000)}, "Invalid Type found for config port"},
+		{"negative", map[string]interface{}{"name": "a", "port": float64(-1)}, "Invalid Type found for config port"},
+		{"typed-missing", testConfig{DB: 2}, "Key Missing : name"},
+		{"invalid", "name=a", "Invalid config, expected a map or a config struct"},
+		{"nil", nil, "Invalid config, expected a map or a config struct"},
+	}
+	for _, c := range cases {
+		loaded := &testConfig{}
+		err := ConfigHelper{Config: loaded}.Load(c.cfg)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		switch c.name {
+		case "map", "typed":
+			if loaded.Name != "a" || loaded.DB != 2 || loaded.Port != 6379 || loaded.Timeout != 1.5 {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+			if c.name == "typed" && (len(loaded.Keys) != 1 || loaded.Args["x-max-priority"] != 10 || loaded.Wait != time.Second) {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "int-timeout":
+			if loaded.DB != 2 || loaded.Port != 6379 || loaded.Timeout != 1 {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "duration", "duration-string":
+			if loaded.Wait != 1500*time.Millisecond {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "typed-pointer":
+			if loaded.Name != "a" || loaded.DB != 2 || loaded.Retries != nil {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "zero-pointer", "typed-zero-pointer":
+			if loaded.Retries == nil || *loaded.Retries != 0 {
+				t.Errorf("%s: zero value not kept %+v", c.name, loaded)
+			}
+		}
+	}
+}
+
+func TestToMap(t *testing.T) {
+	m, err := ToMap(testConfig{Name: "a", Args: amqp.Table{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["name"] != "a" {
+		t.Errorf("Unexpected map %v", m)
+	}
+	if _, ok := m["args"].(map[string]interface{}); !ok {
+		t.Errorf("Table not converted to a map: %T", m["args"])
+	}
+}
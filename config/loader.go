@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecretPrefix marks a config value that names a secret instead of holding
+// the value itself, as in "secret://amqp-password".
+const SecretPrefix = "secret://"
+
+// Errors collects every problem found in a config.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// SecretResolver returns the value of a named secret.
+type SecretResolver interface {
+	Resolve(name string) (string, error)
+}
+
+// SecretFunc adapts a function to SecretResolver.
+type SecretFunc func(name string) (string, error)
+
+func (f SecretFunc) Resolve(name string) (string, error) {
+	return f(name)
+}
+
+// FileSecrets reads secrets from the files of a directory, such as the
+// /run/secrets mounted by Docker and Kubernetes. Trailing newlines are
+// dropped.
+type FileSecrets string
+
+func (dir FileSecrets) Resolve(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == ".." {
+		return "", errors.New("Invalid secret name " + name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(string(dir), name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Loader builds the map form of a protocol config from a file section and
+// the environment. An environment variable named
+// <Prefix>_<PROTOCOL>_<KEY>, such as JUDO_AMQP_HOST or
+// JUDO_AMQP_ROUTING_KEYS, overrides the file.
+type Loader struct {
+	// Prefix defaults to JUDO.
+	Prefix string
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+	// Secrets resolves secret:// values. It defaults to FileSecrets of
+	// /run/secrets.
+	Secrets SecretResolver
+	// Strict reports keys of the section that the config does not know.
+	Strict bool
+}
+
+// ReadFile reads a YAML or JSON config file, depending on its extension.
+func ReadFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	default:
+		err = errors.New("Unsupported config file " + path)
+	}
+	return doc, err
+}
+
+// Load returns the config of protocol described by cfg, for example
+// &sub.NatsConfig{}, taking values from section and the environment. Secret
+// references are resolved and every value is checked against the type of
+// its field. All problems, including missing mandatory keys, are returned
+// together as Errors, as are unknown keys when Strict is set. section may be
+// nil.
+func (l Loader) Load(protocol string, cfg Config, section map[string]interface{}) (map[string]interface{}, error) {
+	e := reflect.Indirect(reflect.ValueOf(cfg))
+	if e.Kind() != reflect.Struct {
+		return nil, errors.New("Not a struct type")
+	}
+
+	var errs Errors
+	m := make(map[string]interface{})
+	for _, key := range cfg.GetKeys() {
+		field := e.FieldByName(cfg.GetField(key))
+		if !field.IsValid() {
+			continue
+		}
+		val, ok := section[key]
+		if env, found := l.lookupEnv(l.envName(protocol, key)); found {
+			val, ok = env, true
+		}
+		if !ok {
+			if stringInSlice(key, cfg.GetMandatoryKeys()) {
+				errs = append(errs, errors.New("Key Missing : "+key))
+			}
+			continue
+		}
+		val, err := l.convert(key, val, field.Type())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m[key] = val
+	}
+	if l.Strict {
+		errs = append(errs, UnknownKeys(cfg, section)...)
+	}
+	if len(errs) > 0 {
+		return m, errs
+	}
+	return m, nil
+}
+
+func (l Loader) lookupEnv(name string) (string, bool) {
+	if l.LookupEnv != nil {
+		return l.LookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// envName turns protocol "nats-streaming" and key "ackTime" into
+// JUDO_NATS_STREAMING_ACK_TIME.
+func (l Loader) envName(protocol, key string) string {
+	prefix := l.Prefix
+	if prefix == "" {
+		prefix = "JUDO"
+	}
+	return prefix + "_" + envWord(protocol) + "_" + envWord(key)
+}
+
+func envWord(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '-' || r == '.' || r == '_':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0 && !unicode.IsUpper(rune(s[i-1])) && s[i-1] != '_':
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// convert resolves secrets in val and converts it into the map form of a
+// field of type t. Strings, as read from the environment, are parsed.
+func (l Loader) convert(key string, val interface{}, t reflect.Type) (interface{}, error) {
+	invalid := errors.New("Invalid Type found for config " + key)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if s, ok := val.(string); ok && strings.HasPrefix(s, SecretPrefix) {
+		secrets := l.Secrets
+		if secrets == nil {
+			secrets = FileSecrets("/run/secrets")
+		}
+		resolved, err := secrets.Resolve(strings.TrimPrefix(s, SecretPrefix))
+		if err != nil {
+			return nil, errors.New("Unable to resolve secret for config " + key + " : " + err.Error())
+		}
+		val = resolved
+	}
+
+	s, isString := val.(string)
+	switch t.Kind() {
+	case reflect.String:
+		if !isString {
+			return nil, invalid
+		}
+		return s, nil
+	case reflect.Bool:
+		if isString {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, invalid
+			}
+			return b, nil
+		}
+		if _, ok := val.(bool); !ok {
+			return nil, invalid
+		}
+		return val, nil
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if d, err := time.ParseDuration(s); isString && t == durationType && err == nil {
+			return d, nil
+		}
+		if isString {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, invalid
+			}
+			return f, nil
+		}
+		switch val.(type) {
+		case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return val, nil
+		}
+		return nil, invalid
+	case reflect.Slice:
+		if isString {
+			return strings.Split(s, ","), nil
+		}
+		switch list := val.(type) {
+		case []string:
+			return list, nil
+		case []interface{}:
+			strs := make([]string, len(list))
+			for i, item := range list {
+				if strs[i], isString = item.(string); !isString {
+					return nil, invalid
+				}
+			}
+			return strs, nil
+		}
+		return nil, invalid
+	case reflect.Map:
+		if isString {
+			table := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(s), &table); err != nil {
+				return nil, invalid
+			}
+			return table, nil
+		}
+		if table, ok := val.(map[string]interface{}); ok {
+			return table, nil
+		}
+		return nil, invalid
+	}
+	return nil, invalid
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	env := map[string]string{
+		"JUDO_TEST_DB":      "3",
+		"JUDO_TEST_KEYS":    "a,b",
+		"JUDO_TEST_ARGS":    `{"x-max-priority": 10}`,
+		"JUDO_TEST_TIMEOUT": "secret://timeout",
+		"JUDO_TEST_WAIT":    "250ms",
+	}
+	secrets := SecretFunc(func(name string) (string, error) {
+		if name == "timeout" {
+			return "2.5", nil
+		}
+		return "", errors.New("not found")
+	})
+	l := Loader{
+		LookupEnv: func(name string) (string, bool) { val, ok := env[name]; return val, ok },
+		Secrets:   secrets,
+	}
+
+	m, err := l.Load("test", &testConfig{}, map[string]interface{}{"name": "file", "db": 1, "port": 6379})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &testConfig{}
+	if err := (ConfigHelper{Config: loaded}).Load(m); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Name != "file" || loaded.DB != 3 || loaded.Port != 6379 || loaded.Timeout != 2.5 ||
+		strings.Join(loaded.Keys, "|") != "a|b" || loaded.Args["x-max-priority"] != float64(10) ||
+		loaded.Wait != 250*time.Millisecond {
+		t.Errorf("Unexpected config %+v", loaded)
+	}
+
+	env = map[string]string{"JUDO_TEST_PORT": "http", "JUDO_TEST_TIMEOUT": "secret://missing"}
+	_, err = l.Load("test", &testConfig{}, map[string]interface{}{"db": "two", "keys": []interface{}{"a", 1}})
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("Expected Errors, got %v", err)
+	}
+	expected := []string{
+		"Key Missing : name",
+		"Invalid Type found for config db",
+		"Invalid Type found for config port",
+		"Unable to resolve secret for config timeout : not found",
+		"Invalid Type found for config keys",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for i, want := range expected {
+		if errs[i].Error() != want {
+			t.Errorf("Expected %q, got %q", want, errs[i])
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	l := Loader{Prefix: "APP"}
+	cases := map[string]string{
+		"routingKeys":     "APP_NATS_STREAMING_ROUTING_KEYS",
+		"ack_time":        "APP_NATS_STREAMING_ACK_TIME",
+		"ttl":             "APP_NATS_STREAMING_TTL",
+		"pingMaxOut":      "APP_NATS_STREAMING_PING_MAX_OUT",
+		"exchangeDurable": "APP_NATS_STREAMING_EXCHANGE_DURABLE",
+	}
+	for key, want := range cases {
+		if got := l.envName("nats-streaming", key); got != want {
+			t.Errorf("%s: expected %s, got %s", key, want, got)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "judo-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"judo.yaml": "amqp:\n  host: localhost\n  port: \"5672\"\n  routingKeys: [a, b]\n",
+		"judo.json": `{"amqp": {"host": "localhost", "port": "5672", "routingKeys": ["a", "b"]}}`,
+		"password":  "s3cret\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+	}
+
+	for _, name := range []string{"judo.yaml", "judo.json"} {
+		doc, err := ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		amqp, _ := doc["amqp"].(map[string]interface{})
+		if amqp["host"] != "localhost" || len(amqp["routingKeys"].([]interface{})) != 2 {
+			t.Errorf("%s: unexpected document %v", name, doc)
+		}
+	}
+	if _, err := ReadFile(filepath.Join(dir, "password")); err == nil {
+		t.Error("Expected an error for a file without a known extension")
+	}
+
+	if secret, err := FileSecrets(dir).Resolve("password"); err != nil || secret != "s3cret" {
+		t.Errorf("Unexpected secret %q, %v", secret, err)
+	}
+	if _, err := FileSecrets(dir).Resolve("../password"); err == nil {
+		t.Error("Secret outside the directory resolved")
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnknownKeyError reports a config key that the config does not know.
+// Suggestion is the closest known key, if any is close enough to be a typo.
+type UnknownKeyError struct {
+	Key        string
+	Suggestion string
+}
+
+func (e *UnknownKeyError) Error() string {
+	if e.Suggestion == "" {
+		return "Unknown key : " + e.Key
+	}
+	return "Unknown key : " + e.Key + ", did you mean " + e.Suggestion + "?"
+}
+
+// UnknownKeys returns an *UnknownKeyError for every key of m that c does not
+// know, sorted by key.
+func UnknownKeys(c Config, m map[string]interface{}) Errors {
+	known := c.GetKeys()
+	var unknown []string
+	for key := range m {
+		if !stringInSlice(key, known) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	var errs Errors
+	for _, key := range unknown {
+		errs = append(errs, &UnknownKeyError{key, suggest(key, known)})
+	}
+	return errs
+}
+
+// suggest returns the known key closest to key, ignoring case, when it is
+// at most a third of its length away.
+func suggest(key string, known []string) string {
+	best, bestDist := "", len(key)/3+1
+	for _, k := range known {
+		if d := distance(strings.ToLower(key), strings.ToLower(k)); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b, counting a swap of
+// adjacent characters as a single edit.
+func distance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minOf(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minOf(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minOf(vals ...int) int {
+	m := vals[0]
+	for _, v := range vals[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Schema describes a config in JSON Schema, for editors and deployment
+// tooling.
+type Schema struct {
+	Schema               string              `json:"$schema"`
+	Title                string              `json:"title,omitempty"`
+	Type                 string              `json:"type"`
+	Properties           map[string]Property `json:"properties"`
+	Required             []string            `json:"required,omitempty"`
+	AdditionalProperties bool                `json:"additionalProperties"`
+}
+
+// Property describes a single config key.
+type Property struct {
+	Type  string    `json:"type"`
+	Items *Property `json:"items,omitempty"`
+}
+
+// NewSchema returns the schema of c, such as &sub.AmqpConfig{}, titled with
+// the protocol it configures.
+func NewSchema(protocol string, c Config) Schema {
+	s := Schema{
+		Schema:     "http://json-schema.org/draft-07/schema#",
+		Title:      protocol,
+		Type:       "object",
+		Properties: make(map[string]Property),
+		Required:   append([]string{}, c.GetMandatoryKeys()...),
+	}
+	e := reflect.Indirect(reflect.ValueOf(c))
+	for _, key := range c.GetKeys() {
+		field := e.FieldByName(c.GetField(key))
+		if !field.IsValid() {
+			continue
+		}
+		s.Properties[key] = property(field.Type())
+	}
+	return s
+}
+
+func property(t reflect.Type) Property {
+	switch t.Kind() {
+	case reflect.String:
+		return Property{Type: "string"}
+	case reflect.Bool:
+		return Property{Type: "boolean"}
+	case reflect.Float32, reflect.Float64:
+		return Property{Type: "number"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Property{Type: "integer"}
+	case reflect.Slice:
+		items := property(t.Elem())
+		return Property{Type: "array", Items: &items}
+	case reflect.Ptr:
+		return property(t.Elem())
+	default:
+		return Property{Type: "object"}
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateAndSetStrict(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  map[string]interface{}
+		err  string
+	}{
+		{"valid", map[string]interface{}{"name": "a", "db": 2}, ""},
+		{"all-errors", map[string]interface{}{"db": "x", "port": float64(70000), "Timeout": 1}, "Key Missing : name; Invalid Type found for config db; Invalid Type found for config port; Unknown key : Timeout, did you mean timeout?"},
+		{"typo", map[string]interface{}{"name": "a", "kyes": []interface{}{"x"}}, "Unknown key : kyes, did you mean keys?"},
+		{"unknown", map[string]interface{}{"name": "a", "exchange": "x"}, "Unknown key : exchange"},
+		{"list", map[string]interface{}{"name": "a", "keys": []interface{}{"x", "y"}}, ""},
+		{"list-invalid", map[string]interface{}{"name": "a", "keys": []interface{}{"x", 1}}, "Invalid Type found for config keys"},
+		{"nil", map[string]interface{}{"name": "a", "args": nil}, ""},
+		{"helper", map[string]interface{}{"name": "a", "nmae": "b"}, "Unknown key : nmae, did you mean name?"},
+	}
+	for _, c := range cases {
+		loaded := &testConfig{}
+		var err error
+		switch c.name {
+		case "helper":
+			err = ConfigHelper{Config: loaded, Strict: true}.ValidateAndSet(c.cfg)
+		default:
+			err = ConfigHelper{Config: loaded}.ValidateAndSetStrict(c.cfg)
+		}
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		switch c.name {
+		case "valid":
+			if loaded.Name != "a" || loaded.DB != 2 {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "list":
+			if len(loaded.Keys) != 2 || loaded.Keys[1] != "y" {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		case "nil":
+			if loaded.Args != nil {
+				t.Errorf("%s: unexpected config %+v", c.name, loaded)
+			}
+		}
+	}
+}
+
+func TestUnknownKeyError(t *testing.T) {
+	errs := UnknownKeys(testConfig{}, map[string]interface{}{"name": "a", "prot": 1, "zzz": 1})
+	if len(errs) != 2 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	unknown, ok := errs[0].(*UnknownKeyError)
+	if !ok || unknown.Key != "prot" || unknown.Suggestion != "port" {
+		t.Errorf("Unexpected error %#v", errs[0])
+	}
+	if unknown, ok = errs[1].(*UnknownKeyError); !ok || unknown.Suggestion != "" {
+		t.Errorf("Unexpected error %#v", errs[1])
+	}
+}
+
+func TestNewSchema(t *testing.T) {
+	data, err := json.Marshal(NewSchema("test", testConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s map[string]interface{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s["title"] != "test" || s["type"] != "object" || s["additionalProperties"] != false {
+		t.Errorf("Unexpected schema %s", data)
+	}
+	props := s["properties"].(map[string]interface{})
+	expected := map[string]string{
+		"name":    "string",
+		"db":      "integer",
+		"port":    "integer",
+		"timeout": "number",
+		"keys":    "array",
+		"args":    "object",
+		"retries": "integer",
+		"wait":    "integer",
+	}
+	for key, typ := range expected {
+		prop, ok := props[key].(map[string]interface{})
+		if !ok || prop["type"] != typ {
+			t.Errorf("Unexpected property %s : %v", key, props[key])
+		}
+	}
+	if items := props["keys"].(map[string]interface{})["items"]; items.(map[string]interface{})["type"] != "string" {
+		t.Errorf("Unexpected items %v", items)
+	}
+	if req := s["required"].([]interface{}); len(req) != 1 || req[0] != "name" {
+		t.Errorf("Unexpected required %v", req)
+	}
+}
+
+func TestLoaderStrict(t *testing.T) {
+	l := Loader{LookupEnv: func(string) (string, bool) { return "", false }, Strict: true}
+	_, err := l.Load("test", &testConfig{}, map[string]interface{}{"name": "a", "tiemout": 1})
+	if err == nil || err.Error() != "Unknown key : tiemout, did you mean timeout?" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// TLSConfig holds the TLS keys shared by the network transports. TLS is on
+// when Tls is set or any other key is given. TlsCA names a PEM bundle of the
+// authorities to trust instead of the system ones, TlsCert and TlsKey the PEM
+// certificate and key presented to the other side, which gives mutual TLS.
+// TlsMinVersion is one of "1.0", "1.1", "1.2" and "1.3".
+type TLSConfig struct {
+	Tls           bool
+	TlsCA         string
+	TlsCert       string
+	TlsKey        string
+	TlsServerName string
+	TlsMinVersion string
+}
+
+// TLSKeys lists the config keys of TLSConfig, to be appended to the keys of
+// the embedding config.
+var TLSKeys = []string{
+	"tls",
+	"tlsCA",
+	"tlsCert",
+	"tlsKey",
+	"tlsServerName",
+	"tlsMinVersion",
+}
+
+var tlsmap = map[string]string{
+	"tls":           "Tls",
+	"tlsCA":         "TlsCA",
+	"tlsCert":       "TlsCert",
+	"tlsKey":        "TlsKey",
+	"tlsServerName": "TlsServerName",
+	"tlsMinVersion": "TlsMinVersion",
+}
+
+// TLSField maps a TLS key to its field name.
+func TLSField(key string) string {
+	return tlsmap[key]
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Enabled reports whether c asks for TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.Tls || c.TlsCA != "" || c.TlsCert != "" || c.TlsKey != "" || c.TlsServerName != "" || c.TlsMinVersion != ""
+}
+
+// ClientTLS returns the tls.Config for dialing the server at addr, or nil
+// when TLS is off. TlsCA verifies the server and TlsCert is sent when the
+// server asks for a client certificate. The certificate of the server must
+// name TlsServerName, by default the host of addr.
+func (c TLSConfig) ClientTLS(addr string) (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	cfg, err := c.base()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ServerName = c.TlsServerName
+	if cfg.ServerName == "" {
+		cfg.ServerName = hostOf(addr)
+	}
+	if c.TlsCA != "" {
+		cfg.RootCAs, err = c.pool()
+	}
+	return cfg, err
+}
+
+// ServerTLS returns the tls.Config for accepting connections, or nil when TLS
+// is off. TlsCert and TlsKey are mandatory. With TlsCA, clients must present
+// a certificate it signed.
+func (c TLSConfig) ServerTLS() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	if c.TlsCert == "" || c.TlsKey == "" {
+		return nil, errors.New("Key Missing : tlsCert and tlsKey")
+	}
+	cfg, err := c.base()
+	if err != nil {
+		return nil, err
+	}
+	if c.TlsCA != "" {
+		cfg.ClientCAs, err = c.pool()
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, err
+}
+
+func (c TLSConfig) base() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if c.TlsMinVersion != "" {
+		version, ok := tlsVersions[c.TlsMinVersion]
+		if !ok {
+			return nil, errors.New("Invalid TLS version " + c.TlsMinVersion)
+		}
+		cfg.MinVersion = version
+	}
+	if c.TlsCert != "" || c.TlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TlsCert, c.TlsKey)
+		if err != nil {
+			return nil, errors.New("Unable to load tlsCert and tlsKey : " + err.Error())
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (c TLSConfig) pool() (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(c.TlsCA)
+	if err != nil {
+		return nil, errors.New("Unable to read tlsCA : " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("No certificates found in tlsCA " + c.TlsCA)
+	}
+	return pool, nil
+}
+
+// hostOf returns the host of an address such as "tls+tcp://host:port".
+func hostOf(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		addr = addr[i+1:]
+	}
+	if i := strings.IndexAny(addr, "/,"); i >= 0 {
+		addr = addr[:i]
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
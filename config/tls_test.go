@@ -0,0 +1,164 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a certificate for localhost that is its own authority,
+// so that it can serve as CA, server and client certificate at once.
+func selfSigned(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir, "judo")
+
+	cases := []struct {
+		name string
+		cfg  TLSConfig
+		err  string
+	}{
+		{"off", TLSConfig{}, ""},
+		{"on", TLSConfig{Tls: true}, ""},
+		{"mutual", TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key, TlsMinVersion: "1.3"}, ""},
+		{"server-name", TLSConfig{TlsServerName: "broker.internal"}, ""},
+		{"bad-version", TLSConfig{TlsMinVersion: "1.4"}, "Invalid TLS version 1.4"},
+		{"missing-ca", TLSConfig{TlsCA: filepath.Join(dir, "missing.crt")}, "Unable to read tlsCA"},
+		{"empty-ca", TLSConfig{TlsCA: key}, "No certificates found in tlsCA " + key},
+		{"cert-without-key", TLSConfig{TlsCert: cert}, "Unable to load tlsCert and tlsKey"},
+	}
+	for _, c := range cases {
+		cfg, err := c.cfg.ClientTLS("tls+tcp://localhost:5555")
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		switch c.name {
+		case "off":
+			if cfg != nil {
+				t.Errorf("%s: TLS enabled", c.name)
+			}
+		case "on":
+			if cfg == nil || cfg.ServerName != "localhost" || cfg.RootCAs != nil {
+				t.Errorf("%s: unexpected config %+v", c.name, cfg)
+			}
+		case "mutual":
+			if cfg.RootCAs == nil || len(cfg.Certificates) != 1 || cfg.MinVersion != tls.VersionTLS13 {
+				t.Errorf("%s: unexpected config %+v", c.name, cfg)
+			}
+		case "server-name":
+			if cfg.ServerName != "broker.internal" {
+				t.Errorf("%s: unexpected config %+v", c.name, cfg)
+			}
+		}
+	}
+
+	if _, err := (TLSConfig{Tls: true}).ServerTLS(); err == nil || err.Error() != "Key Missing : tlsCert and tlsKey" {
+		t.Error("Server without certificate accepted", err)
+	}
+	cfg, err := TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key}.ServerTLS()
+	if err != nil || cfg.ClientCAs == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Client certificates not required %+v %v", cfg, err)
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir, "judo")
+	otherCert, otherKey := selfSigned(t, dir, "other")
+	server := TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key}
+
+	cases := []struct {
+		name   string
+		client TLSConfig
+		ok     bool
+	}{
+		{"mutual", TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key}, true},
+		{"no-client-cert", TLSConfig{TlsCA: cert}, false},
+		{"untrusted-client-cert", TLSConfig{TlsCA: cert, TlsCert: otherCert, TlsKey: otherKey}, false},
+		{"untrusted-server", TLSConfig{TlsCA: otherCert, TlsCert: cert, TlsKey: key}, false},
+		{"server-name", TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key, TlsServerName: "broker.internal"}, false},
+	}
+	for _, c := range cases {
+		serverCfg, err := server.ServerTLS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientCfg, err := c.client.ClientTLS("127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted := make(chan error, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				err = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+			accepted <- err
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientCfg)
+		serverErr := <-accepted
+		if err == nil {
+			conn.Close()
+		}
+		listener.Close()
+
+		if ok := err == nil && serverErr == nil; ok != c.ok {
+			t.Errorf("%s: expected handshake ok %v, got client %v, server %v", c.name, c.ok, err, serverErr)
+		}
+	}
+}
@@ -12,7 +12,7 @@ require (
 	github.com/garyburd/redigo v1.6.2 // indirect
 	github.com/go-mangos/mangos v1.4.0
 	github.com/go-redis/redis v6.15.9+incompatible
-	github.com/google/uuid v1.2.0 // indirect
+	github.com/google/uuid v1.2.0
 	github.com/gopherjs/gopherjs v0.0.0-20210202160940-bed99a852dfe // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/hashicorp/go-uuid v1.0.2 // indirect
@@ -30,5 +30,6 @@ require (
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/streadway/amqp v1.0.0
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	nanomsg.org/go-mangos v1.4.0
 )
@@ -68,6 +68,10 @@ func TestCreateSubscriberSuccess(t *testing.T) {
 			"redis",
 			"sub",
 		},
+		{
+			"redis-stream",
+			"sub",
+		},
 		{
 			"pubnub",
 			"sub",
@@ -113,6 +117,10 @@ func TestCreateSubscriberSuccess(t *testing.T) {
 			if c.protocol != "redis" && c.method != "sub" {
 				t.Fail()
 			}
+		case "*sub.RedisStreamSubscriber":
+			if c.protocol != "redis-stream" && c.method != "sub" {
+				t.Fail()
+			}
 		case "*sub.PubnubSubscriber":
 			if c.protocol != "pubnub" && c.method != "sub" {
 				t.Fail()
@@ -136,6 +144,22 @@ func TestCreatePublisherSuccess(t *testing.T) {
 			"redis",
 			"publish",
 		},
+		{
+			"redis-stream",
+			"publish",
+		},
+		{
+			"amqp",
+			"publish",
+		},
+		{
+			"nats",
+			"publish",
+		},
+		{
+			"nats-core",
+			"publish",
+		},
 	}
 
 	for _, c := range cases {
@@ -153,6 +177,64 @@ func TestCreatePublisherSuccess(t *testing.T) {
 			if c.method != "publish" && c.protocol != "redis" {
 				t.Error("Invalid type returned")
 			}
+		case "*redis.streamPub":
+			if c.method != "publish" && c.protocol != "redis-stream" {
+				t.Error("Invalid type returned")
+			}
+		case "*amqp.amqpPub":
+			if c.method != "publish" && c.protocol != "amqp" {
+				t.Error("Invalid type returned")
+			}
+		case "*stan.stanPub":
+			if c.protocol != "nats" && c.protocol != "nats-streaming" {
+				t.Error("Invalid type returned")
+			}
+		case "*nats.natsPub":
+			if c.protocol != "nats-core" {
+				t.Error("Invalid type returned")
+			}
+		}
+	}
+}
+
+func TestCreatePublisherFailure(t *testing.T) {
+	cases := []struct {
+		protocol string
+		method   string
+		primary  string
+	}{
+		{"oooo", "publish", ""},
+		{"redis", "oooo", ""},
+		{"amagi", "publish", "oooo"},
+	}
+
+	for _, c := range cases {
+		retVal, err := NewPublisher(c.protocol, c.method, c.primary, "")
+		if err == nil || retVal != nil {
+			t.Errorf("Error Should be thrown for invalid protocol and methods: %s-%s", c.protocol, c.method)
 		}
 	}
 }
+
+func TestCreateAmagi(t *testing.T) {
+	_, err := NewSubscriber("amagi", "sub", "amqp", "redis")
+	if err != nil {
+		t.Error("Unable to create amagi subscriber", err.Error())
+	}
+	sub, err := NewSubscriber("amagi", "sub", "amqp", "oooo")
+	if err == nil || sub != nil {
+		t.Error("Error Should be thrown for invalid amagi leg")
+	}
+	_, err = NewSubscriber("amagi", "sub", "amqp", "")
+	if err != nil {
+		t.Error("Unable to create amagi subscriber without backup", err.Error())
+	}
+	_, err = NewPublisher("amagi", "publish", "redis", "nano")
+	if err != nil {
+		t.Error("Unable to create amagi publisher", err.Error())
+	}
+	_, err = NewPublisher("amagi", "publish", "redis", "amqp")
+	if err != nil {
+		t.Error("Unable to create amagi publisher with amqp backup", err.Error())
+	}
+}
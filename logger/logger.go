@@ -0,0 +1,123 @@
+// Package logger lets judo clients report internal events and errors that
+// have no caller to return them to.
+package logger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger takes a message followed by alternating keys and values, like
+// log/slog. A *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Nop discards everything. Clients use it until given a Logger.
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Warn(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+
+// With returns a Logger adding args to every call of l. A nil l gives Nop.
+func With(l Logger, args ...interface{}) Logger {
+	if l == nil {
+		return Nop
+	}
+	if len(args) == 0 {
+		return l
+	}
+	return &with{l, args}
+}
+
+type with struct {
+	Logger
+	args []interface{}
+}
+
+func (w *with) Debug(msg string, args ...interface{}) { w.Logger.Debug(msg, w.join(args)...) }
+func (w *with) Info(msg string, args ...interface{})  { w.Logger.Info(msg, w.join(args)...) }
+func (w *with) Warn(msg string, args ...interface{})  { w.Logger.Warn(msg, w.join(args)...) }
+func (w *with) Error(msg string, args ...interface{}) { w.Logger.Error(msg, w.join(args)...) }
+
+func (w *with) join(args []interface{}) []interface{} {
+	return append(append([]interface{}{}, w.args...), args...)
+}
+
+// Level orders log calls by severity.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// New returns a Logger writing calls at level or above to w, one line of
+// key=value pairs each.
+func New(w io.Writer, level Level) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+func (t *textLogger) Debug(msg string, args ...interface{}) { t.log(LevelDebug, msg, args) }
+func (t *textLogger) Info(msg string, args ...interface{})  { t.log(LevelInfo, msg, args) }
+func (t *textLogger) Warn(msg string, args ...interface{})  { t.log(LevelWarn, msg, args) }
+func (t *textLogger) Error(msg string, args ...interface{}) { t.log(LevelError, msg, args) }
+
+func (t *textLogger) log(level Level, msg string, args []interface{}) {
+	if level < t.level {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%s", time.Now().Format(time.RFC3339), level, quote(msg))
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " !BADKEY=%s", quote(fmt.Sprint(args[i])))
+			break
+		}
+		fmt.Fprintf(&b, " %v=%s", args[i], quote(fmt.Sprint(args[i+1])))
+	}
+	b.WriteByte('\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, b.String())
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
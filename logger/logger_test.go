@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := With(New(&buf, LevelInfo), "protocol", "redis", "topic", "dqi50n.out")
+
+	l.Debug("Dropped duplicate message", "message_id", "1")
+	l.Info("Connection state changed", "state", "connected")
+	l.Error("Unable to fetch missed messages", "err", errors.New("NOSCRIPT No matching script"), "odd")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	expected := []string{
+		`level=INFO msg="Connection state changed" protocol=redis topic=dqi50n.out state=connected`,
+		`level=ERROR msg="Unable to fetch missed messages" protocol=redis topic=dqi50n.out err="NOSCRIPT No matching script" !BADKEY=odd`,
+	}
+	for i, want := range expected {
+		if !strings.HasPrefix(lines[i], "time=") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("Expected %q, got %q", want, lines[i])
+		}
+	}
+}
+
+func TestWithNil(t *testing.T) {
+	if With(nil, "protocol", "redis") != Nop {
+		t.Error("Expected Nop for a nil logger")
+	}
+	Nop.Error("Ignored", "err", errors.New("ignored"))
+}
@@ -1,13 +1,40 @@
 package message
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/amagimedia/judo/v3/logger"
 	"github.com/streadway/amqp"
 )
 
+// NackHeader marks a reply published by SendNack, so that requesters can
+// tell it apart from a reply published by SendAck. Without AMQP headers it
+// travels in an envelope, see NackReply.
+const NackHeader = "x-judo-nack"
+
+// AmqpHeader converts the headers of a delivery into message headers.
+func AmqpHeader(table amqp.Table) map[string]string {
+	header := make(map[string]string, len(table))
+	for key, val := range table {
+		header[key] = fmt.Sprint(val)
+	}
+	return header
+}
+
 type AmqpMessage struct {
 	RawMessage RawMessage
 	Responder  RawChannel
 	Properties map[string]string
+	Header     map[string]string
+	// Logger receives the errors of SendAck and SendNack. It may be nil.
+	Logger logger.Logger
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
+	// RejectRedelivered makes SendNack reject a redelivered message instead
+	// of requeueing it. Subscribers set it under a retry policy.
+	RejectRedelivered bool
 }
 
 func (m AmqpMessage) GetProperty(key string) (string, bool) {
@@ -22,6 +49,19 @@ func (m AmqpMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m AmqpMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m AmqpMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m AmqpMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m AmqpMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -33,12 +73,13 @@ func (m AmqpMessage) SetMessage(msg []byte) Message {
 }
 
 func (m AmqpMessage) SendAck(ackMessage ...[]byte) {
+	m.Settlement.Ack()
 	if val, ok := m.GetProperty("protocol_type"); ok && val == "reqrep" {
 		resp := []byte("OK")
 		if len(ackMessage) > 0 {
 			resp = ackMessage[0]
 		}
-		m.Responder.Publish(
+		err := m.Responder.Publish(
 			"",
 			m.RawMessage.GetReplyTo(),
 			false,
@@ -49,10 +90,81 @@ func (m AmqpMessage) SendAck(ackMessage ...[]byte) {
 				Body:          resp,
 			},
 		)
+		m.logError("Unable to publish reply", err)
 	}
-	m.RawMessage.Ack(false)
+	m.logError("Unable to ack message", m.RawMessage.Ack(false))
 }
 
+// SendNack requeues a subscribed message. With RejectRedelivered, a
+// redelivered message is rejected instead, to the dead-letter exchange of its
+// queue if there is one, so that it does not loop. A request is answered with
+// a nack reply instead and is not requeued, as the requester has already been
+// told.
 func (m AmqpMessage) SendNack(ackMessage ...[]byte) {
-	m.RawMessage.Nack(false, true)
+	if m.Settlement.Nack() {
+		return
+	}
+	if val, ok := m.GetProperty("protocol_type"); ok && val == "reqrep" {
+		resp := []byte("ERR")
+		if len(ackMessage) > 0 {
+			resp = ackMessage[0]
+		}
+		err := m.Responder.Publish(
+			"",
+			m.RawMessage.GetReplyTo(),
+			false,
+			false,
+			amqp.Publishing{
+				ContentType:   "text/plain",
+				CorrelationId: m.RawMessage.GetCorrelationId(),
+				Headers:       amqp.Table{NackHeader: true},
+				Body:          resp,
+			},
+		)
+		m.logError("Unable to publish reply", err)
+		m.logError("Unable to nack message", m.RawMessage.Nack(false, false))
+		return
+	}
+	redelivered, _ := m.GetProperty("redelivered")
+	requeue := !m.RejectRedelivered || redelivered != "true"
+	m.logError("Unable to nack message", m.RawMessage.Nack(false, requeue))
+}
+
+// Republish publishes a copy of a subscribed message, in an envelope keeping
+// its ID and current headers, to the queue it was consumed from through the
+// default exchange.
+func (m AmqpMessage) Republish() error {
+	queue, ok := m.GetProperty("queue")
+	if !ok {
+		return errors.New("Unknown queue")
+	}
+	env := NewEnvelope(m.GetMessage())
+	if id, ok := m.GetProperty("message_id"); ok {
+		env.ID = id
+	}
+	env.Headers = m.Header
+	publishing := amqp.Publishing{Body: Encode(env)}
+	if raw, ok := m.RawMessage.(AmqpRawMessage); ok {
+		publishing.DeliveryMode = raw.DeliveryMode
+		publishing.Priority = raw.Priority
+	}
+	return m.Responder.Publish("", queue, false, false, publishing)
+}
+
+func (m AmqpMessage) logError(msg string, err error) {
+	if err != nil && m.Logger != nil {
+		m.Logger.Error(msg, "err", err)
+	}
+}
+
+// SendReject rejects a subscribed message without requeueing it. The broker
+// routes it to the dead-letter exchange of its queue, if there is one.
+func (m AmqpMessage) SendReject() {
+	m.logError("Unable to reject message", m.RawMessage.Nack(false, false))
+}
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m AmqpMessage) Context() context.Context {
+	return m.Settlement.Context()
 }
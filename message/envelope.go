@@ -0,0 +1,154 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EnvelopeVersion is the envelope format written by this version of judo.
+const EnvelopeVersion = 1
+
+// envelopeMagic prefixes encoded envelopes. Payloads without it are plain
+// or legacy "uuid|payload" messages and are still decoded.
+var envelopeMagic = []byte("JUDO\x00")
+
+// Source names the sender in the envelopes created by NewEnvelope. It
+// defaults to the name of the running binary.
+var Source = filepath.Base(os.Args[0])
+
+// Envelope carries a payload together with the metadata judo needs across
+// hops: an ID for deduplication, when and by whom it was sent, and headers.
+type Envelope struct {
+	Version   int               `json:"v"`
+	ID        string            `json:"id,omitempty"`
+	Timestamp time.Time         `json:"ts"`
+	Source    string            `json:"src,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      []byte            `json:"body"`
+}
+
+// NewEnvelope wraps body with a fresh ID, the current time and Source.
+func NewEnvelope(body []byte) Envelope {
+	return Envelope{
+		Version:   EnvelopeVersion,
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		Source:    Source,
+		Body:      body,
+	}
+}
+
+// Codec turns envelopes into payloads and back.
+type Codec interface {
+	Encode(Envelope) ([]byte, error)
+	Decode([]byte) (Envelope, error)
+}
+
+// JSONCodec encodes envelopes as JSON behind a magic prefix. Decode accepts
+// the legacy "uuid|payload" format of older amagi publishers and treats any
+// other payload as a plain body.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(env Envelope) ([]byte, error) {
+	if env.Version == 0 {
+		env.Version = EnvelopeVersion
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, envelopeMagic...), data...), nil
+}
+
+func (JSONCodec) Decode(data []byte) (Envelope, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return decodeLegacy(data), nil
+	}
+	var env Envelope
+	if err := json.Unmarshal(data[len(envelopeMagic):], &env); err != nil {
+		return Envelope{Body: data}, err
+	}
+	if env.Version > EnvelopeVersion {
+		return Envelope{Body: data}, errors.New("Unsupported envelope version")
+	}
+	return env, nil
+}
+
+// decodeLegacy splits off the ID of a "uuid|payload" message. The payload
+// itself may contain any number of pipes.
+func decodeLegacy(data []byte) Envelope {
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) == 2 {
+		if _, err := uuid.Parse(parts[0]); err == nil && len(parts[0]) == 36 {
+			return Envelope{ID: parts[0], Body: []byte(parts[1])}
+		}
+	}
+	return Envelope{Body: data}
+}
+
+// DefaultCodec is shared by all judo publishers and subscribers.
+var DefaultCodec Codec = JSONCodec{}
+
+// Encode encodes env with DefaultCodec.
+func Encode(env Envelope) []byte {
+	data, err := DefaultCodec.Encode(env)
+	if err != nil {
+		return env.Body
+	}
+	return data
+}
+
+// Decode decodes data with DefaultCodec. Data that cannot be decoded is
+// returned as the body of an otherwise empty envelope. Headers are never nil.
+func Decode(data []byte) Envelope {
+	env, err := DefaultCodec.Decode(data)
+	if err != nil {
+		env = Envelope{Body: data}
+	}
+	if env.Headers == nil {
+		env.Headers = make(map[string]string)
+	}
+	return env
+}
+
+// NackReply wraps body in an envelope carrying NackHeader, so that requesters
+// can tell a nack from an ack whatever its body. Transports without headers,
+// such as NATS and nano, reply with it from SendNack.
+func NackReply(body []byte) []byte {
+	env := NewEnvelope(body)
+	env.Headers = map[string]string{NackHeader: "true"}
+	return Encode(env)
+}
+
+// ParseReply returns the body of a reply and whether it was built by
+// NackReply. Other replies are returned untouched.
+func ParseReply(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return data, false
+	}
+	env := Decode(data)
+	if env.Headers[NackHeader] != "true" {
+		return data, false
+	}
+	return env.Body, true
+}
+
+// SetEnvelopeProperties exposes the envelope metadata as message properties.
+func SetEnvelopeProperties(m Message, env Envelope) {
+	if env.ID != "" {
+		m.SetProperty("message_id", env.ID)
+	}
+	if env.Source != "" {
+		m.SetProperty("source", env.Source)
+	}
+	if !env.Timestamp.IsZero() {
+		m.SetProperty("timestamp", env.Timestamp.Format(time.RFC3339Nano))
+	}
+}
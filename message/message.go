@@ -2,8 +2,15 @@ package message
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/gob"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/amagimedia/judo/v3/redact"
 	gredis "github.com/go-redis/redis"
 	nats "github.com/nats-io/go-nats"
 	natsStream "github.com/nats-io/go-nats-streaming"
@@ -17,10 +24,24 @@ type Message interface {
 	SetMessage([]byte) Message
 	GetProperty(string) (string, bool)
 	SetProperty(string, string)
+	// Headers travel with the message across the wire, unlike properties.
+	GetHeader(string) (string, bool)
+	SetHeader(string, string)
+	GetHeaders() map[string]string
 	SendAck(...[]byte)
 	SendNack(...[]byte)
 }
 
+// AttemptsHeader counts the deliveries of a message under a retry policy.
+const AttemptsHeader = "x-judo-attempts"
+
+// Republisher is implemented by messages that can put a copy of themselves,
+// headers included, back on the queue they came from. A retry policy
+// republishes them instead of holding on to them between attempts.
+type Republisher interface {
+	Republish() error
+}
+
 type RawMessage interface {
 	Ack(bool) error
 	Nack(bool, bool) error
@@ -36,9 +57,13 @@ type RawChannel interface {
 	QueueBind(string, string, string, bool, interface{}) error
 	QueueDeclare(string, bool, bool, bool, bool, interface{}) (amqp.Queue, error)
 	ExchangeDeclare(string, string, bool, bool, bool, bool, interface{}) error
+	ExchangeDeclarePassive(string, string, bool, bool, bool, bool, interface{}) error
 	Consume(string, string, bool, bool, bool, bool, interface{}) (<-chan amqp.Delivery, error)
 	Close() error
 	Qos(int, int, bool) error
+	Confirm(bool) error
+	NotifyReturn(chan amqp.Return) chan amqp.Return
+	NotifyPublish(chan amqp.Confirmation) chan amqp.Confirmation
 }
 
 type RawSocket interface {
@@ -46,13 +71,16 @@ type RawSocket interface {
 	Close() error
 	AddTransport(mangos.Transport)
 	Dial(string) error
+	DialOptions(string, map[string]interface{}) error
 	Listen(string) error
+	ListenOptions(string, map[string]interface{}) error
 	SetOption(string, interface{}) error
 	Send([]byte) error
 }
 
 type RawConnection interface {
 	Publish(string, []byte) error
+	Request(string, []byte, time.Duration) (*nats.Msg, error)
 	ChanSubscribe(string, interface{}) (*nats.Subscription, error)
 	Close()
 	Subscribe(string, natsStream.MsgHandler, ...natsStream.SubscriptionOption) (natsStream.Subscription, error)
@@ -67,6 +95,18 @@ type RawClient interface {
 	EvalSha(string, []string, ...interface{}) *gredis.Cmd
 }
 
+// StreamRawClient is the part of the Redis client used by the Redis Streams
+// publisher and subscriber.
+type StreamRawClient interface {
+	XAdd(*gredis.XAddArgs) (string, error)
+	XGroupCreate(stream, group string) error
+	XReadGroup(*gredis.XReadGroupArgs) ([]gredis.XStream, error)
+	XAck(stream, group string, ids ...string) error
+	XPendingExt(*gredis.XPendingExtArgs) ([]gredis.XPendingExt, error)
+	XClaim(*gredis.XClaimArgs) ([]gredis.XMessage, error)
+	Close() error
+}
+
 type RawPubnubClient interface {
 	FetchHistory(string, bool, int64, bool, int) ([]*pubnub.PNMessage, error)
 	Publish(string, []byte) error
@@ -108,8 +148,38 @@ func (d AmqpRawMessage) GetTimetoken() int64 {
 	return 0
 }
 
+// AmqpRawChannel wraps a channel together with the connection it was opened
+// on, so that closing the channel also releases the connection when set.
 type AmqpRawChannel struct {
 	*amqp.Channel
+	Connection *amqp.Connection
+}
+
+// DialAmqp opens a channel on a new connection to the broker at host:port.
+// The credentials are sent with SASL PLAIN instead of in the URL, so they
+// need no escaping and never show up in errors. A non nil tlsCfg dials
+// amqps.
+func DialAmqp(user, password, host, port string, tlsCfg *tls.Config) (AmqpRawChannel, error) {
+	u := url.URL{Scheme: "amqp", Host: net.JoinHostPort(host, port), Path: "/"}
+	if tlsCfg != nil {
+		u.Scheme = "amqps"
+	}
+	connection, err := amqp.DialConfig(u.String(), amqp.Config{
+		SASL:            []amqp.Authentication{&amqp.PlainAuth{Username: user, Password: password}},
+		Heartbeat:       10 * time.Second,
+		Locale:          "en_US",
+		TLSClientConfig: tlsCfg,
+	})
+	if err != nil {
+		return AmqpRawChannel{}, redact.Error(err, password)
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return AmqpRawChannel{}, redact.Error(err, password)
+	}
+	return AmqpRawChannel{Channel: channel, Connection: connection}, nil
 }
 
 func (d AmqpRawChannel) Publish(exchange, key string, mandatory, immediate bool, msg interface{}) error {
@@ -128,21 +198,43 @@ func (d AmqpRawChannel) ExchangeDeclare(name, kind string, durable, autoDelete,
 	return d.Channel.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args.(amqp.Table))
 }
 
+func (d AmqpRawChannel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args interface{}) error {
+	return d.Channel.ExchangeDeclarePassive(name, kind, durable, autoDelete, internal, noWait, args.(amqp.Table))
+}
+
 func (d AmqpRawChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args interface{}) (<-chan amqp.Delivery, error) {
 	return d.Channel.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args.(amqp.Table))
 }
 
 func (d AmqpRawChannel) Close() error {
+	var err error
 	if d.Channel != nil {
-		return d.Channel.Close()
+		err = d.Channel.Close()
 	}
-	return nil
+	if d.Connection != nil {
+		if connErr := d.Connection.Close(); err == nil && connErr != amqp.ErrClosed {
+			err = connErr
+		}
+	}
+	return err
 }
 
 func (d AmqpRawChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
 	return d.Channel.Qos(prefetchCount, prefetchSize, global)
 }
 
+func (d AmqpRawChannel) Confirm(noWait bool) error {
+	return d.Channel.Confirm(noWait)
+}
+
+func (d AmqpRawChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	return d.Channel.NotifyReturn(c)
+}
+
+func (d AmqpRawChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	return d.Channel.NotifyPublish(c)
+}
+
 type NanoRawMessage struct {
 	Raw []byte
 }
@@ -211,6 +303,14 @@ func (d NanoRawSocket) Listen(addr string) error {
 	return d.Socket.Listen(addr)
 }
 
+func (d NanoRawSocket) DialOptions(addr string, opts map[string]interface{}) error {
+	return d.Socket.DialOptions(addr, opts)
+}
+
+func (d NanoRawSocket) ListenOptions(addr string, opts map[string]interface{}) error {
+	return d.Socket.ListenOptions(addr, opts)
+}
+
 type NatsRawMessage struct {
 	*nats.Msg
 }
@@ -245,13 +345,17 @@ func (d NatsRawMessage) GetTimetoken() int64 {
 }
 
 type NatsRawConnection struct {
-	nats.Conn
+	*nats.Conn
 }
 
 func (d *NatsRawConnection) Publish(subject string, msg []byte) error {
 	return d.Conn.Publish(subject, msg)
 }
 
+func (d *NatsRawConnection) Request(subject string, msg []byte, timeout time.Duration) (*nats.Msg, error) {
+	return d.Conn.Request(subject, msg, timeout)
+}
+
 func (d *NatsRawConnection) ChanSubscribe(subject string, ch interface{}) (*nats.Subscription, error) {
 	return d.Conn.ChanSubscribe(subject, ch.(chan *nats.Msg))
 }
@@ -262,7 +366,7 @@ func (d *NatsRawConnection) Subscribe(subject string, cb natsStream.MsgHandler,
 }
 
 func (d *NatsRawConnection) Close() {
-	if d.Conn.IsConnected() {
+	if d.Conn != nil && d.Conn.IsConnected() {
 		d.Conn.Close()
 	}
 }
@@ -300,13 +404,20 @@ func (d NatsStreamRawMessage) GetTimetoken() int64 {
 	return 0
 }
 
+// NatsStreamRawConnection wraps a NATS Streaming connection. Nats is the
+// NATS connection it was given, if any, which is closed along with it.
 type NatsStreamRawConnection struct {
 	natsStream.Conn
+	Nats *nats.Conn
 }
 
 func (d NatsStreamRawConnection) Publish(subject string, msg []byte) error {
 	return d.Conn.Publish(subject, msg)
 }
+func (d NatsStreamRawConnection) Request(subject string, msg []byte, timeout time.Duration) (*nats.Msg, error) {
+	return nil, errors.New("Request is not supported on a NATS Streaming connection")
+}
+
 func (d NatsStreamRawConnection) ChanSubscribe(subject string, ch interface{}) (*nats.Subscription, error) {
 	return &nats.Subscription{}, nil
 }
@@ -319,10 +430,16 @@ func (d NatsStreamRawConnection) Close() {
 	if d.Conn != nil {
 		d.Conn.Close()
 	}
+	if d.Nats != nil {
+		d.Nats.Close()
+	}
 }
 
+// RedisRawMessage is a message received on a channel. Timetoken is its
+// sequence number, which persistence resumes from.
 type RedisRawMessage struct {
-	Message *gredis.Message
+	Message   *gredis.Message
+	Timetoken int64
 }
 
 func (d RedisRawMessage) Ack(multiple bool) error {
@@ -351,7 +468,7 @@ func (d RedisRawMessage) GetCorrelationId() string {
 }
 
 func (d RedisRawMessage) GetTimetoken() int64 {
-	return 0
+	return d.Timetoken
 }
 
 type RedisRawClient struct {
@@ -387,6 +504,90 @@ func (d RedisRawClient) Close() error {
 	return nil
 }
 
+// RedisStreamField is the field of a stream entry holding the message.
+const RedisStreamField = "data"
+
+// RedisStreamRawMessage is a stream entry read through a consumer group. Ack
+// acknowledges it to the group, which removes it from the pending entries.
+type RedisStreamRawMessage struct {
+	Message *gredis.XMessage
+	Stream  string
+	Group   string
+	Client  StreamRawClient
+}
+
+func (d RedisStreamRawMessage) Ack(multiple bool) error {
+	return d.Client.XAck(d.Stream, d.Group, d.Message.ID)
+}
+
+func (d RedisStreamRawMessage) Nack(multiple, requeue bool) error {
+	return nil
+}
+
+func (d RedisStreamRawMessage) GetBody() []byte {
+	body, _ := d.Message.Values[RedisStreamField].(string)
+	return []byte(body)
+}
+
+func (d RedisStreamRawMessage) SetBody(body []byte) RawMessage {
+	d.Message.Values[RedisStreamField] = string(body)
+	return d
+}
+
+func (d RedisStreamRawMessage) GetReplyTo() string {
+	return ""
+}
+
+func (d RedisStreamRawMessage) GetCorrelationId() string {
+	return ""
+}
+
+func (d RedisStreamRawMessage) GetTimetoken() int64 {
+	return 0
+}
+
+// RedisStreamRawClient adapts a Redis client to StreamRawClient.
+type RedisStreamRawClient struct {
+	Client *gredis.Client
+}
+
+func (d RedisStreamRawClient) XAdd(a *gredis.XAddArgs) (string, error) {
+	return d.Client.XAdd(a).Result()
+}
+
+// XGroupCreate creates group, and stream if needed, delivering the entries
+// added from now on. A group that already exists is left as is.
+func (d RedisStreamRawClient) XGroupCreate(stream, group string) error {
+	err := d.Client.XGroupCreateMkStream(stream, group, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (d RedisStreamRawClient) XReadGroup(a *gredis.XReadGroupArgs) ([]gredis.XStream, error) {
+	return d.Client.XReadGroup(a).Result()
+}
+
+func (d RedisStreamRawClient) XAck(stream, group string, ids ...string) error {
+	return d.Client.XAck(stream, group, ids...).Err()
+}
+
+func (d RedisStreamRawClient) XPendingExt(a *gredis.XPendingExtArgs) ([]gredis.XPendingExt, error) {
+	return d.Client.XPendingExt(a).Result()
+}
+
+func (d RedisStreamRawClient) XClaim(a *gredis.XClaimArgs) ([]gredis.XMessage, error) {
+	return d.Client.XClaim(a).Result()
+}
+
+func (d RedisStreamRawClient) Close() error {
+	if d.Client != nil {
+		return d.Client.Close()
+	}
+	return nil
+}
+
 type PubnubRawMessage struct {
 	Message *pubnub.PNMessage
 }
@@ -1,23 +1,39 @@
 package message_test
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
 
+	"github.com/amagimedia/judo/v3/logger"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
 	"github.com/go-mangos/mangos/protocol/sub"
 	nats "github.com/nats-io/go-nats"
 	natsStream "github.com/nats-io/go-nats-streaming"
 	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/mock"
 )
 
+// nackOf matches a reply built by NackReply around body.
+func nackOf(body []byte) interface{} {
+	return mock.MatchedBy(func(data []byte) bool {
+		reply, nack := message.ParseReply(data)
+		return nack && string(reply) == string(body)
+	})
+}
+
 func TestAmqpMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawChannel := &mocks.RawChannel{}
+	var logs bytes.Buffer
 	fakeMessage := &message.AmqpMessage{
-		fakeRawMessage,
-		fakeRawChannel,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawChannel,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
+		Logger:     logger.New(&logs, logger.LevelError),
 	}
 
 	cases := []struct {
@@ -62,6 +78,13 @@ func TestAmqpMessage(t *testing.T) {
 			[]byte("MSG"),
 			[]byte("OK"),
 		},
+		{
+			"ack_err",
+			"protocol_type",
+			"reqrep",
+			[]byte("MSG"),
+			[]byte("DONE"),
+		},
 		{
 			"nack",
 			"protocol_type",
@@ -69,6 +92,27 @@ func TestAmqpMessage(t *testing.T) {
 			[]byte("MSG"),
 			[]byte("ERR"),
 		},
+		{
+			"nack_sub",
+			"protocol_type",
+			"sub",
+			[]byte("MSG"),
+			[]byte(""),
+		},
+		{
+			"nack_redelivered",
+			"redelivered",
+			"true",
+			[]byte("MSG"),
+			[]byte(""),
+		},
+		{
+			"republish",
+			"queue",
+			"jobs",
+			[]byte("MSG"),
+			[]byte(""),
+		},
 	}
 
 	for _, c := range cases {
@@ -83,7 +127,7 @@ func TestAmqpMessage(t *testing.T) {
 				t.Error("Got Unset Property.")
 			}
 		case "set_message":
-			fakeRawMessage.On("SetBody", c.msg).Return(mocks.RawMessage{})
+			fakeRawMessage.On("SetBody", c.msg).Return(&mocks.RawMessage{})
 			fakeRawMessage.On("GetBody").Return(c.msg)
 			_ = fakeMessage.SetMessage(c.msg)
 			if string(fakeMessage.GetMessage()) != string(c.msg) {
@@ -100,13 +144,61 @@ func TestAmqpMessage(t *testing.T) {
 				Body:          c.ack,
 			}).Return(nil).Once()
 			fakeMessage.SendAck(c.ack)
+		case "ack_err":
+			fakeMessage.SetProperty(c.propertyName, c.propertyVal)
+			fakeRawMessage.On("GetReplyTo").Return("TestReplyTo").Once()
+			fakeRawMessage.On("GetCorrelationId").Return("corelid").Once()
+			fakeRawMessage.On("Ack", false).Return(nil).Once()
+			fakeRawChannel.On("Publish", "", "TestReplyTo", false, false, amqp.Publishing{
+				ContentType:   "text/plain",
+				CorrelationId: "corelid",
+				Body:          c.ack,
+			}).Return(errors.New("channel closed")).Once()
+			fakeMessage.SendAck(c.ack)
+			if !strings.Contains(logs.String(), `msg="Unable to publish reply" err="channel closed"`) {
+				t.Errorf("Publish error not logged: %q", logs.String())
+			}
 		case "nack":
+			fakeMessage.SetProperty(c.propertyName, c.propertyVal)
+			fakeRawMessage.On("GetReplyTo").Return("TestReplyTo").Once()
+			fakeRawMessage.On("GetCorrelationId").Return("corelid").Once()
+			fakeRawMessage.On("Nack", false, false).Return(nil).Once()
+			fakeRawChannel.On("Publish", "", "TestReplyTo", false, false, amqp.Publishing{
+				ContentType:   "text/plain",
+				CorrelationId: "corelid",
+				Headers:       amqp.Table{message.NackHeader: true},
+				Body:          c.ack,
+			}).Return(nil).Once()
+			fakeMessage.SendNack(c.ack)
+		case "nack_sub":
+			fakeMessage.SetProperty(c.propertyName, c.propertyVal)
+			fakeRawMessage.On("Nack", false, true).Return(nil).Once()
+			fakeMessage.SendNack(c.ack)
+		case "nack_redelivered":
+			fakeMessage.SetProperty(c.propertyName, c.propertyVal)
 			fakeRawMessage.On("Nack", false, true).Return(nil).Once()
 			fakeMessage.SendNack(c.ack)
+			fakeMessage.RejectRedelivered = true
+			fakeRawMessage.On("Nack", false, false).Return(nil).Once()
+			fakeMessage.SendNack(c.ack)
+			fakeMessage.RejectRedelivered = false
+		case "republish":
+			fakeMessage.SetProperty(c.propertyName, c.propertyVal)
+			fakeMessage.SetProperty("message_id", "id-1")
+			fakeMessage.SetHeader(message.AttemptsHeader, "2")
+			fakeRawChannel.On("Publish", "", c.propertyVal, false, false, mock.MatchedBy(func(p amqp.Publishing) bool {
+				env := message.Decode(p.Body)
+				return env.ID == "id-1" && env.Headers[message.AttemptsHeader] == "2" && string(env.Body) == string(c.msg)
+			})).Return(nil).Once()
+			if err := fakeMessage.Republish(); err != nil {
+				t.Error("Republish failed", err)
+			}
 		default:
 			t.Error("Unknown case")
 		}
 	}
+	fakeRawMessage.AssertExpectations(t)
+	fakeRawChannel.AssertExpectations(t)
 
 }
 
@@ -126,9 +218,10 @@ func TestNanoMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawSocket := &mocks.RawSocket{}
 	fakeMessage := &message.NanoMessage{
-		fakeRawMessage,
-		fakeRawSocket,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawSocket,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
 	}
 
 	cases := []struct {
@@ -194,7 +287,7 @@ func TestNanoMessage(t *testing.T) {
 				t.Error("Got Unset Property.")
 			}
 		case "set_message":
-			fakeRawMessage.On("SetBody", c.msg).Return(mocks.RawMessage{})
+			fakeRawMessage.On("SetBody", c.msg).Return(&mocks.RawMessage{})
 			fakeRawMessage.On("GetBody").Return(c.msg)
 			_ = fakeMessage.SetMessage(c.msg)
 			if string(fakeMessage.GetMessage()) != string(c.msg) {
@@ -204,8 +297,9 @@ func TestNanoMessage(t *testing.T) {
 			fakeRawSocket.On("Send", c.ack).Return(nil).Once()
 			fakeMessage.SendAck(c.ack)
 		case "nack":
-			fakeRawSocket.On("Send", c.ack).Return(nil).Once()
+			fakeRawSocket.On("Send", nackOf(c.ack)).Return(nil).Once()
 			fakeMessage.SendNack(c.ack)
+			fakeRawSocket.AssertExpectations(t)
 		default:
 			t.Error("Unknown case")
 		}
@@ -231,9 +325,10 @@ func TestNatsMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawConnect := &mocks.RawConnection{}
 	fakeMessage := &message.NatsMessage{
-		fakeRawMessage,
-		fakeRawConnect,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawConnect,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
 	}
 
 	cases := []struct {
@@ -299,7 +394,7 @@ func TestNatsMessage(t *testing.T) {
 				t.Error("Got Unset Property.")
 			}
 		case "set_message":
-			fakeRawMessage.On("SetBody", c.msg).Return(mocks.RawMessage{})
+			fakeRawMessage.On("SetBody", c.msg).Return(&mocks.RawMessage{})
 			fakeRawMessage.On("GetBody").Return(c.msg)
 			_ = fakeMessage.SetMessage(c.msg)
 			if string(fakeMessage.GetMessage()) != string(c.msg) {
@@ -310,9 +405,10 @@ func TestNatsMessage(t *testing.T) {
 			fakeRawMessage.On("GetReplyTo").Return(c.propertyVal).Once()
 			fakeMessage.SendAck(c.ack)
 		case "nack":
-			fakeRawConnect.On("Publish", c.propertyVal, c.ack).Return(nil).Once()
+			fakeRawConnect.On("Publish", c.propertyVal, nackOf(c.ack)).Return(nil).Once()
 			fakeRawMessage.On("GetReplyTo").Return(c.propertyVal).Once()
 			fakeMessage.SendNack(c.ack)
+			fakeRawConnect.AssertExpectations(t)
 		default:
 			t.Error("Unknown case")
 		}
@@ -330,7 +426,7 @@ func TestNatsRawWrapper(t *testing.T) {
 	wrapMessage.GetReplyTo()
 	wrapMessage.GetCorrelationId()
 
-	wrapConnection := message.NatsRawConnection{nats.Conn{}}
+	wrapConnection := message.NatsRawConnection{&nats.Conn{}}
 	wrapConnection.Publish("", []byte(""))
 
 }
@@ -339,9 +435,10 @@ func TestNatsStreamingMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawConnect := &mocks.RawConnection{}
 	fakeMessage := &message.NatsStreamMessage{
-		fakeRawMessage,
-		fakeRawConnect,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawConnect,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
 	}
 
 	cases := []struct {
@@ -407,7 +504,7 @@ func TestNatsStreamingMessage(t *testing.T) {
 				t.Error("Got Unset Property.")
 			}
 		case "set_message":
-			fakeRawMessage.On("SetBody", c.msg).Return(mocks.RawMessage{})
+			fakeRawMessage.On("SetBody", c.msg).Return(&mocks.RawMessage{})
 			fakeRawMessage.On("GetBody").Return(c.msg)
 			_ = fakeMessage.SetMessage(c.msg)
 			if string(fakeMessage.GetMessage()) != string(c.msg) {
@@ -443,9 +540,10 @@ func TestRedisMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawClient := &mocks.RawClient{}
 	fakeMessage := &message.RedisMessage{
-		fakeRawMessage,
-		fakeRawClient,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawClient,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
 	}
 
 	cases := []struct {
@@ -543,9 +641,10 @@ func TestPubnubMessage(t *testing.T) {
 	fakeRawMessage := &mocks.RawMessage{}
 	fakeRawClient := &mocks.PubnubRawClient{}
 	fakeMessage := &message.PubnubMessage{
-		fakeRawMessage,
-		fakeRawClient,
-		map[string]string{"protocol_type": "sub"},
+		RawMessage: fakeRawMessage,
+		Responder:  fakeRawClient,
+		Properties: map[string]string{"protocol_type": "sub"},
+		Header:     map[string]string{},
 	}
 
 	cases := []struct {
@@ -638,3 +737,62 @@ func TestPubnubRawWrapper(t *testing.T) {
 	wrapMessage.Ack(false)
 	wrapMessage.Nack(false, true)
 }
+
+func TestEnvelope(t *testing.T) {
+	env := message.NewEnvelope([]byte("a|b|c"))
+	env.Headers = map[string]string{"trace-id": "abc"}
+	legacyID := "4f3c5fb6-3c0e-4e43-a2bb-90d7f5f6d5b2"
+
+	cases := []struct {
+		name    string
+		data    []byte
+		id      string
+		headers map[string]string
+		body    []byte
+	}{
+		{"enveloped", message.Encode(env), env.ID, map[string]string{"trace-id": "abc"}, []byte("a|b|c")},
+		{"unversioned", []byte("JUDO\x00{\"headers\":{\"k\":\"v\"},\"body\":\"TVNH\"}"), "", map[string]string{"k": "v"}, []byte("MSG")},
+		{"legacy", []byte(legacyID + "|a|b"), legacyID, map[string]string{}, []byte("a|b")},
+		{"legacy-4", []byte(legacyID + "|a|b|c"), legacyID, map[string]string{}, []byte("a|b|c")},
+		{"pipes", []byte("topic|a|b|c"), "", map[string]string{}, []byte("topic|a|b|c")},
+		{"plain", []byte("MSG"), "", map[string]string{}, []byte("MSG")},
+		{"corrupt", []byte("JUDO\x00{"), "", map[string]string{}, []byte("JUDO\x00{")},
+		{"future", []byte("JUDO\x00{\"v\":99,\"body\":\"TVNH\"}"), "", map[string]string{}, []byte("JUDO\x00{\"v\":99,\"body\":\"TVNH\"}")},
+	}
+	for _, c := range cases {
+		decoded := message.Decode(c.data)
+		if string(decoded.Body) != string(c.body) {
+			t.Errorf("%s: expected body %q, got %q", c.name, c.body, decoded.Body)
+		}
+		if decoded.ID != c.id {
+			t.Errorf("%s: expected id %q, got %q", c.name, c.id, decoded.ID)
+		}
+		if len(decoded.Headers) != len(c.headers) {
+			t.Errorf("%s: expected headers %v, got %v", c.name, c.headers, decoded.Headers)
+		}
+		for key, val := range c.headers {
+			if decoded.Headers[key] != val {
+				t.Errorf("%s: expected header %s=%s, got %s", c.name, key, val, decoded.Headers[key])
+			}
+		}
+	}
+
+	decoded := message.Decode(message.Encode(env))
+	if decoded.Version != message.EnvelopeVersion || decoded.Source != message.Source || !decoded.Timestamp.Equal(env.Timestamp) {
+		t.Error("Envelope metadata lost", decoded)
+	}
+
+	msg := message.NatsMessage{RawMessage: message.NatsRawMessage{Msg: &nats.Msg{}}, Properties: map[string]string{}, Header: map[string]string{}}
+	msg.SetHeader("tenant", "amagi")
+	if val, ok := msg.GetHeader("tenant"); !ok || val != "amagi" || msg.GetHeaders()["tenant"] != "amagi" {
+		t.Error("Header not set on message")
+	}
+	if _, ok := msg.GetProperty("tenant"); ok {
+		t.Error("Header leaked into properties")
+	}
+
+	amqpHeader := message.AmqpHeader(amqp.Table{"attempt": int32(2), "tenant": "amagi"})
+	if amqpHeader["attempt"] != "2" || amqpHeader["tenant"] != "amagi" {
+		t.Error("Unexpected amqp headers", amqpHeader)
+	}
+}
@@ -24,6 +24,20 @@ func (_m RawChannel) Close() error {
 	return r0
 }
 
+// Confirm provides a mock function with given fields: _a0
+func (_m RawChannel) Confirm(_a0 bool) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(bool) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Consume provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4, _a5, _a6
 func (_m RawChannel) Consume(_a0 string, _a1 string, _a2 bool, _a3 bool, _a4 bool, _a5 bool, _a6 interface{}) (<-chan amqp.Delivery, error) {
 	ret := _m.Called(_a0, _a1, _a2, _a3, _a4, _a5, _a6)
@@ -61,6 +75,52 @@ func (_m RawChannel) ExchangeDeclare(_a0 string, _a1 string, _a2 bool, _a3 bool,
 	return r0
 }
 
+// ExchangeDeclarePassive provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4, _a5, _a6
+func (_m RawChannel) ExchangeDeclarePassive(_a0 string, _a1 string, _a2 bool, _a3 bool, _a4 bool, _a5 bool, _a6 interface{}) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3, _a4, _a5, _a6)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, bool, bool, bool, bool, interface{}) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3, _a4, _a5, _a6)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NotifyPublish provides a mock function with given fields: _a0
+func (_m RawChannel) NotifyPublish(_a0 chan amqp.Confirmation) chan amqp.Confirmation {
+	ret := _m.Called(_a0)
+
+	var r0 chan amqp.Confirmation
+	if rf, ok := ret.Get(0).(func(chan amqp.Confirmation) chan amqp.Confirmation); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(chan amqp.Confirmation)
+		}
+	}
+
+	return r0
+}
+
+// NotifyReturn provides a mock function with given fields: _a0
+func (_m RawChannel) NotifyReturn(_a0 chan amqp.Return) chan amqp.Return {
+	ret := _m.Called(_a0)
+
+	var r0 chan amqp.Return
+	if rf, ok := ret.Get(0).(func(chan amqp.Return) chan amqp.Return); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(chan amqp.Return)
+		}
+	}
+
+	return r0
+}
+
 // Publish provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4
 func (_m RawChannel) Publish(_a0 string, _a1 string, _a2 bool, _a3 bool, _a4 interface{}) error {
 	ret := _m.Called(_a0, _a1, _a2, _a3, _a4)
@@ -4,6 +4,7 @@ package mocks
 import mock "github.com/stretchr/testify/mock"
 import nats "github.com/nats-io/go-nats"
 import stan "github.com/nats-io/go-nats-streaming"
+import time "time"
 
 // RawConnection is an autogenerated mock type for the RawConnection type
 type RawConnection struct {
@@ -52,6 +53,29 @@ func (_m *RawConnection) Publish(_a0 string, _a1 []byte) error {
 	return r0
 }
 
+// Request provides a mock function with given fields: _a0, _a1, _a2
+func (_m *RawConnection) Request(_a0 string, _a1 []byte, _a2 time.Duration) (*nats.Msg, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 *nats.Msg
+	if rf, ok := ret.Get(0).(func(string, []byte, time.Duration) *nats.Msg); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*nats.Msg)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, []byte, time.Duration) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Subscribe provides a mock function with given fields: _a0, _a1, _a2
 func (_m *RawConnection) Subscribe(_a0 string, _a1 stan.MsgHandler, _a2 ...stan.SubscriptionOption) (stan.Subscription, error) {
 	_va := make([]interface{}, len(_a2))
@@ -43,6 +43,20 @@ func (_m *RawSocket) Dial(_a0 string) error {
 	return r0
 }
 
+// DialOptions provides a mock function with given fields: _a0, _a1
+func (_m *RawSocket) DialOptions(_a0 string, _a1 map[string]interface{}) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]interface{}) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Listen provides a mock function with given fields: _a0
 func (_m *RawSocket) Listen(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -57,6 +71,20 @@ func (_m *RawSocket) Listen(_a0 string) error {
 	return r0
 }
 
+// ListenOptions provides a mock function with given fields: _a0, _a1
+func (_m *RawSocket) ListenOptions(_a0 string, _a1 map[string]interface{}) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]interface{}) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Recv provides a mock function with given fields:
 func (_m *RawSocket) Recv() ([]byte, error) {
 	ret := _m.Called()
@@ -1,9 +1,14 @@
 package message
 
+import "context"
+
 type NanoMessage struct {
 	RawMessage RawMessage
 	Responder  RawSocket
 	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
 }
 
 func (m NanoMessage) GetProperty(key string) (string, bool) {
@@ -18,6 +23,19 @@ func (m NanoMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m NanoMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m NanoMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m NanoMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m NanoMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -29,6 +47,7 @@ func (m NanoMessage) SetMessage(msg []byte) Message {
 }
 
 func (m NanoMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
 	resp := []byte("OK")
 	if len(ackMsg) > 0 {
 		resp = ackMsg[0]
@@ -37,11 +56,22 @@ func (m NanoMessage) SendAck(ackMsg ...[]byte) {
 	return
 }
 
+// SendNack replies with the given body, "ERR" by default, marked as a nack.
 func (m NanoMessage) SendNack(ackMessage ...[]byte) {
+	if m.Settlement.Nack() {
+		return
+	}
 	resp := []byte("ERR")
 	if len(ackMessage) > 0 {
 		resp = ackMessage[0]
 	}
+	resp = NackReply(resp)
 	m.Responder.Send(resp)
 	return
 }
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m NanoMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
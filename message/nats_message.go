@@ -1,9 +1,14 @@
 package message
 
+import "context"
+
 type NatsMessage struct {
 	RawMessage RawMessage
 	Responder  RawConnection
 	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
 }
 
 func (m NatsMessage) GetProperty(key string) (string, bool) {
@@ -18,6 +23,19 @@ func (m NatsMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m NatsMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m NatsMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m NatsMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m NatsMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -29,6 +47,7 @@ func (m NatsMessage) SetMessage(msg []byte) Message {
 }
 
 func (m NatsMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
 	resp := []byte("OK")
 	if len(ackMsg) > 0 {
 		resp = ackMsg[0]
@@ -37,11 +56,22 @@ func (m NatsMessage) SendAck(ackMsg ...[]byte) {
 	return
 }
 
+// SendNack replies with the given body, "NOK" by default, marked as a nack.
 func (m NatsMessage) SendNack(ackMessage ...[]byte) {
+	if m.Settlement.Nack() {
+		return
+	}
 	resp := []byte("NOK")
 	if len(ackMessage) > 0 {
 		resp = ackMessage[0]
 	}
+	resp = NackReply(resp)
 	m.Responder.Publish(m.RawMessage.GetReplyTo(), resp)
 	return
 }
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m NatsMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
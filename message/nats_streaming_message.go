@@ -1,9 +1,14 @@
 package message
 
+import "context"
+
 type NatsStreamMessage struct {
 	RawMessage RawMessage
 	Responder  RawConnection
 	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
 }
 
 func (m NatsStreamMessage) GetProperty(key string) (string, bool) {
@@ -18,6 +23,19 @@ func (m NatsStreamMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m NatsStreamMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m NatsStreamMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m NatsStreamMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m NatsStreamMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -29,10 +47,18 @@ func (m NatsStreamMessage) SetMessage(msg []byte) Message {
 }
 
 func (m NatsStreamMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
 	m.RawMessage.Ack(false)
 	return
 }
 
 func (m NatsStreamMessage) SendNack(ackMessage ...[]byte) {
+	m.Settlement.Nack()
 	return
 }
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m NatsStreamMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
@@ -1,9 +1,30 @@
 package message
 
+import (
+	"context"
+	"fmt"
+)
+
+// PubnubHeader reads the headers field that publishers add next to msg.
+func PubnubHeader(msg interface{}) map[string]string {
+	header := make(map[string]string)
+	if body, ok := msg.(map[string]interface{}); ok {
+		if fields, ok := body["headers"].(map[string]interface{}); ok {
+			for key, val := range fields {
+				header[key] = fmt.Sprint(val)
+			}
+		}
+	}
+	return header
+}
+
 type PubnubMessage struct {
 	RawMessage RawMessage
 	Responder  RawPubnubClient
 	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
 }
 
 func (m *PubnubMessage) GetProperty(key string) (string, bool) {
@@ -18,6 +39,19 @@ func (m *PubnubMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m *PubnubMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m *PubnubMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m *PubnubMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m *PubnubMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -29,11 +63,21 @@ func (m *PubnubMessage) SetMessage(msg []byte) Message {
 }
 
 func (m *PubnubMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
 	m.SetProperty("ack", "OK")
 	return
 }
 
 func (m *PubnubMessage) SendNack(ackMessage ...[]byte) {
+	if m.Settlement.Nack() {
+		return
+	}
 	m.SetProperty("ack", "NOK")
 	return
 }
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m *PubnubMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
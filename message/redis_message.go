@@ -1,9 +1,14 @@
 package message
 
+import "context"
+
 type RedisMessage struct {
 	RawMessage RawMessage
 	Responder  RawClient
 	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
 }
 
 func (m *RedisMessage) GetProperty(key string) (string, bool) {
@@ -18,6 +23,19 @@ func (m *RedisMessage) SetProperty(key string, val string) {
 	m.Properties[key] = val
 }
 
+func (m *RedisMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m *RedisMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m *RedisMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
 func (m *RedisMessage) GetMessage() []byte {
 	return m.RawMessage.GetBody()
 }
@@ -29,11 +47,21 @@ func (m *RedisMessage) SetMessage(msg []byte) Message {
 }
 
 func (m *RedisMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
 	m.SetProperty("ack", "OK")
 	return
 }
 
 func (m *RedisMessage) SendNack(ackMessage ...[]byte) {
+	if m.Settlement.Nack() {
+		return
+	}
 	m.SetProperty("ack", "NOK")
 	return
 }
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m *RedisMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
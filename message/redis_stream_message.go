@@ -0,0 +1,60 @@
+package message
+
+import "context"
+
+type RedisStreamMessage struct {
+	RawMessage RawMessage
+	Properties map[string]string
+	Header     map[string]string
+	// Settlement, when set, observes SendAck and SendNack.
+	Settlement *Settlement
+}
+
+func (m *RedisStreamMessage) GetProperty(key string) (string, bool) {
+	val, ok := m.Properties[key]
+	return val, ok
+}
+
+func (m *RedisStreamMessage) SetProperty(key string, val string) {
+	m.Properties[key] = val
+}
+
+func (m *RedisStreamMessage) GetHeader(key string) (string, bool) {
+	val, ok := m.Header[key]
+	return val, ok
+}
+
+func (m *RedisStreamMessage) SetHeader(key string, val string) {
+	m.Header[key] = val
+}
+
+func (m *RedisStreamMessage) GetHeaders() map[string]string {
+	return m.Header
+}
+
+func (m *RedisStreamMessage) GetMessage() []byte {
+	return m.RawMessage.GetBody()
+}
+
+func (m *RedisStreamMessage) SetMessage(msg []byte) Message {
+	m.RawMessage = m.RawMessage.SetBody(msg)
+	return m
+}
+
+// SendAck acknowledges the entry with XACK.
+func (m *RedisStreamMessage) SendAck(ackMsg ...[]byte) {
+	m.Settlement.Ack()
+	m.RawMessage.Ack(false)
+}
+
+// SendNack leaves the entry pending, so that it is claimed and delivered
+// again once it has been idle for the claim interval of the subscriber.
+func (m *RedisStreamMessage) SendNack(ackMessage ...[]byte) {
+	m.Settlement.Nack()
+}
+
+// Context returns the context of the callback call the message is delivered
+// to, see ContextOf.
+func (m *RedisStreamMessage) Context() context.Context {
+	return m.Settlement.Context()
+}
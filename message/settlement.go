@@ -0,0 +1,134 @@
+package message
+
+import (
+	"context"
+	"sync"
+)
+
+// Settlement observes how a delivered message is settled. Subscribers attach
+// one to the messages they deliver, so that a nack can be acted upon without
+// wrapping the message, which would hide its type from the callback. A nil
+// Settlement observes nothing.
+type Settlement struct {
+	onAck     []func()
+	onNack    []func()
+	onDone    []func()
+	intercept func() bool
+
+	mu    sync.Mutex
+	quiet bool
+	ctx   context.Context
+}
+
+// OnAck registers fn to run when the message is acked. Functions are
+// registered before the message is delivered.
+func (s *Settlement) OnAck(fn func()) {
+	s.onAck = append(s.onAck, fn)
+}
+
+// Ack runs the functions registered with OnAck. Messages call it from
+// SendAck.
+func (s *Settlement) Ack() {
+	if s == nil || s.isQuiet() {
+		return
+	}
+	for _, fn := range s.onAck {
+		fn()
+	}
+}
+
+// OnNack registers fn to run when the message is nacked. Functions are
+// registered before the message is delivered.
+func (s *Settlement) OnNack(fn func()) {
+	s.onNack = append(s.onNack, fn)
+}
+
+// OnDone registers fn to run once the subscriber is done with the message,
+// that is when its callback returned without the message being held for a
+// retry, or when its retries are over.
+func (s *Settlement) OnDone(fn func()) {
+	s.onDone = append(s.onDone, fn)
+}
+
+// Done runs the functions registered with OnDone. The runtime of the
+// subscriber calls it.
+func (s *Settlement) Done() {
+	if s == nil {
+		return
+	}
+	for _, fn := range s.onDone {
+		fn()
+	}
+}
+
+// Intercept lets fn take over the nacks of the message: when fn returns true
+// the nack is not passed on to the transport. It runs after the functions
+// registered with OnNack, and is set before the message is delivered.
+func (s *Settlement) Intercept(fn func() bool) {
+	s.intercept = fn
+}
+
+// Nack runs the functions registered with OnNack and reports whether the
+// nack was taken over. Messages call it from SendNack, and return straight
+// away when it reports true.
+func (s *Settlement) Nack() bool {
+	if s == nil || s.isQuiet() {
+		return false
+	}
+	for _, fn := range s.onNack {
+		fn()
+	}
+	return s.intercept != nil && s.intercept()
+}
+
+// Quietly runs fn, in which whoever took over a nack settles the message with
+// the transport, without observing the settlement: neither the functions
+// registered with OnAck and OnNack nor the interceptor run.
+func (s *Settlement) Quietly(fn func()) {
+	s.mu.Lock()
+	s.quiet = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.quiet = false
+		s.mu.Unlock()
+	}()
+	fn()
+}
+
+// SetContext sets the context returned by Context. The runtime of the
+// subscriber sets one for each call of the callback.
+func (s *Settlement) SetContext(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+}
+
+// Context returns the context set with SetContext, or context.Background.
+func (s *Settlement) Context() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// ContextOf returns the context of the callback call msg is delivered to. It
+// is cancelled when the call returns or the subscriber shuts down. Messages
+// that have none get context.Background.
+func ContextOf(msg Message) context.Context {
+	if c, ok := msg.(interface{ Context() context.Context }); ok {
+		return c.Context()
+	}
+	return context.Background()
+}
+
+func (s *Settlement) isQuiet() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quiet
+}
@@ -0,0 +1,71 @@
+package message
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader carries the W3C trace context of a message.
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span of a distributed trace, as described by
+// the W3C Trace Context recommendation.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the sender recorded the span.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value. Values of unknown
+// versions are accepted as long as they start like version 00.
+func ParseTraceparent(val string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(val) < 55 || (len(val) > 55 && val[55] != '-') {
+		return sc, false
+	}
+	parts := strings.Split(val[:55], "-")
+	version, flags := make([]byte, 1), make([]byte, 1)
+	if len(parts) != 4 || !decodeHex(version, parts[0]) || version[0] == 0xff || (version[0] == 0 && len(val) != 55) {
+		return sc, false
+	}
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags, parts[3]) {
+		return SpanContext{}, false
+	}
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes lowercase hex into dst, which it must fill exactly.
+func decodeHex(dst []byte, src string) bool {
+	if len(src) != 2*len(dst) || strings.ToLower(src) != src {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(src))
+	return err == nil
+}
+
+// SpanContextOf returns the span context carried by m, which is invalid when
+// m was sent without one. Inside a handler wrapped by tracing.Subscriber it
+// is the context of the span processing m, so headers copied from m onto a
+// new publish continue the trace.
+func SpanContextOf(m Message) SpanContext {
+	val, _ := m.GetHeader(TraceparentHeader)
+	sc, _ := ParseTraceparent(val)
+	return sc
+}
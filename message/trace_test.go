@@ -0,0 +1,49 @@
+package message_test
+
+import (
+	"testing"
+
+	"github.com/amagimedia/judo/v3/message"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		val   string
+		valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		sc, ok := message.ParseTraceparent(c.val)
+		if ok != c.valid {
+			t.Errorf("%q: expected valid %v", c.val, c.valid)
+		}
+		if ok && sc.Traceparent()[3:] != c.val[3:55] {
+			t.Errorf("%q: formatted as %q", c.val, sc.Traceparent())
+		}
+	}
+
+	sc, _ := message.ParseTraceparent(cases[0].val)
+	if !sc.Sampled() || sc.Traceparent() != cases[0].val {
+		t.Errorf("Unexpected span context %+v", sc)
+	}
+}
+
+func TestSpanContextOf(t *testing.T) {
+	msg := message.NanoMessage{RawMessage: message.NanoRawMessage{}, Properties: map[string]string{}, Header: map[string]string{}}
+	if message.SpanContextOf(msg).IsValid() {
+		t.Error("Span context found on a message without traceparent")
+	}
+	msg.SetHeader(message.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if sc := message.SpanContextOf(msg); !sc.IsValid() || sc.Sampled() {
+		t.Errorf("Unexpected span context %+v", sc)
+	}
+}
@@ -0,0 +1,179 @@
+// Package metrics counts what judo clients do and exposes the counts in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Names of the metrics recorded by judo clients.
+const (
+	Received     = "judo_messages_received_total"
+	Acked        = "judo_messages_acked_total"
+	Nacked       = "judo_messages_nacked_total"
+	Duplicates   = "judo_duplicates_dropped_total"
+	Dropped      = "judo_messages_dropped_total"
+	Published    = "judo_messages_published_total"
+	PublishError = "judo_publish_errors_total"
+	Reconnects   = "judo_reconnects_total"
+	HandlerTime  = "judo_handler_duration_seconds"
+	PublishTime  = "judo_publish_duration_seconds"
+)
+
+// Labels identify the client a metric belongs to.
+type Labels struct {
+	Protocol string
+	Topic    string
+}
+
+// Recorder receives the metrics of judo clients.
+type Recorder interface {
+	Inc(name string, labels Labels)
+	Observe(name string, labels Labels, seconds float64)
+}
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets
+// used by NewRegistry.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type series struct {
+	name   string
+	labels Labels
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Registry is an in-memory Recorder. It serves its metrics over HTTP in the
+// Prometheus text format.
+type Registry struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	counters   map[series]float64
+	histograms map[series]*histogram
+}
+
+// NewRegistry returns an empty Registry using DefaultBuckets.
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets:    DefaultBuckets,
+		counters:   make(map[series]float64),
+		histograms: make(map[series]*histogram),
+	}
+}
+
+// Inc adds one to a counter.
+func (r *Registry) Inc(name string, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[series{name, labels}]++
+}
+
+// Observe records seconds in a histogram.
+func (r *Registry) Observe(name string, labels Labels, seconds float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := series{name, labels}
+	h, ok := r.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		r.histograms[key] = h
+	}
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Counter returns the value of a counter.
+func (r *Registry) Counter(name string, labels Labels) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[series{name, labels}]
+}
+
+// Count returns the number of observations made in a histogram.
+func (r *Registry) Count(name string, labels Labels) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.histograms[series{name, labels}]; ok {
+		return h.count
+	}
+	return 0
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	counters := make([]series, 0, len(r.counters))
+	for key := range r.counters {
+		counters = append(counters, key)
+	}
+	sortSeries(counters)
+	for i, key := range counters {
+		if i == 0 || counters[i-1].name != key.name {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", key.name)
+		}
+		fmt.Fprintf(&b, "%s{%s} %g\n", key.name, formatLabels(key.labels), r.counters[key])
+	}
+
+	histograms := make([]series, 0, len(r.histograms))
+	for key := range r.histograms {
+		histograms = append(histograms, key)
+	}
+	sortSeries(histograms)
+	for i, key := range histograms {
+		if i == 0 || histograms[i-1].name != key.name {
+			fmt.Fprintf(&b, "# TYPE %s histogram\n", key.name)
+		}
+		h, labels := r.histograms[key], formatLabels(key.labels)
+		for j, bound := range r.buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%g\"} %d\n", key.name, labels, bound, h.counts[j])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", key.name, labels, h.count)
+		fmt.Fprintf(&b, "%s_sum{%s} %g\n", key.name, labels, h.sum)
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", key.name, labels, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+func sortSeries(s []series) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].name != s[j].name {
+			return s[i].name < s[j].name
+		}
+		if s[i].labels.Protocol != s[j].labels.Protocol {
+			return s[i].labels.Protocol < s[j].labels.Protocol
+		}
+		return s[i].labels.Topic < s[j].labels.Topic
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(l Labels) string {
+	return fmt.Sprintf(`protocol="%s",topic="%s"`, labelEscaper.Replace(l.Protocol), labelEscaper.Replace(l.Topic))
+}
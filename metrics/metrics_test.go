@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amagimedia/judo/v3/publisher"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	nats := Labels{"nats", "orders"}
+	reg.Inc(Received, nats)
+	reg.Inc(Received, nats)
+	reg.Inc(Received, Labels{"redis", `odd"topic`})
+	reg.Observe(HandlerTime, nats, 0.02)
+	reg.Observe(HandlerTime, nats, 3)
+
+	if got := reg.Counter(Received, nats); got != 2 {
+		t.Errorf("Expected 2 received, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE judo_messages_received_total counter",
+		`judo_messages_received_total{protocol="nats",topic="orders"} 2`,
+		`judo_messages_received_total{protocol="redis",topic="odd\"topic"} 1`,
+		"# TYPE judo_handler_duration_seconds histogram",
+		`judo_handler_duration_seconds_bucket{protocol="nats",topic="orders",le="0.01"} 0`,
+		`judo_handler_duration_seconds_bucket{protocol="nats",topic="orders",le="0.025"} 1`,
+		`judo_handler_duration_seconds_bucket{protocol="nats",topic="orders",le="5"} 2`,
+		`judo_handler_duration_seconds_bucket{protocol="nats",topic="orders",le="+Inf"} 2`,
+		`judo_handler_duration_seconds_sum{protocol="nats",topic="orders"} 3.02`,
+		`judo_handler_duration_seconds_count{protocol="nats",topic="orders"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Missing %q in\n%s", line, body)
+		}
+	}
+	if strings.Count(body, "# TYPE judo_messages_received_total") != 1 {
+		t.Error("Type line repeated for each series")
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	for _, c := range []string{"subject", "no-topic"} {
+		reg := NewRegistry()
+		labels := Labels{Protocol: "nats", Topic: "orders"}
+		topic := func(subject string) string { return subject }
+		if c == "no-topic" {
+			labels.Topic, topic = "", nil
+		}
+		var fail error
+		publish := publisher.Chain(func(string, []byte, map[string]string) error {
+			return fail
+		}, Publisher(reg, "nats", topic))
+
+		publish("orders", []byte("a"), nil)
+		fail = errors.New("Unable to publish")
+		if err := publish("orders", []byte("b"), nil); err != fail {
+			t.Errorf("%s: publish error not returned: %v", c, err)
+		}
+
+		if reg.Counter(Published, labels) != 1 || reg.Counter(PublishError, labels) != 1 {
+			t.Errorf("%s: unexpected counts: published %v, errors %v", c, reg.Counter(Published, labels), reg.Counter(PublishError, labels))
+		}
+		if n := reg.Count(PublishTime, labels); n != 2 {
+			t.Errorf("%s: expected 2 publish latencies, got %d", c, n)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/amagimedia/judo/v3/publisher"
+)
+
+// Publisher returns a publisher.Middleware that counts publishes and publish
+// errors in r and records how long each publish takes, labelled with protocol.
+// The topic label is left empty unless topic is given, which maps the
+// subject published to onto it. Subjects that embed IDs should be mapped onto
+// a few topics, as every distinct label makes a new series.
+func Publisher(r Recorder, protocol string, topic func(subject string) string) publisher.Middleware {
+	return func(next publisher.PublishFunc) publisher.PublishFunc {
+		return func(subject string, msg []byte, headers map[string]string) error {
+			labels := Labels{Protocol: protocol}
+			if topic != nil {
+				labels.Topic = topic(subject)
+			}
+			start := time.Now()
+			err := next(subject, msg, headers)
+			r.Observe(PublishTime, labels, time.Since(start).Seconds())
+			if err != nil {
+				r.Inc(PublishError, labels)
+				return err
+			}
+			r.Inc(Published, labels)
+			return nil
+		}
+	}
+}
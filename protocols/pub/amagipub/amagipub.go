@@ -1,23 +1,40 @@
 package pub
 
 import (
-	"fmt"
+	"errors"
 
-	pubnubPub "github.com/amagimedia/judo/v3/protocols/pub/pubnub"
-	redispub "github.com/amagimedia/judo/v3/protocols/pub/redis"
-	sidekiqpub "github.com/amagimedia/judo/v3/protocols/pub/sidekiq"
-	stanpub "github.com/amagimedia/judo/v3/protocols/pub/stan"
-	nanoreq "github.com/amagimedia/judo/v3/protocols/req/nano"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/amqp"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/nats"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/pubnub"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/redis"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/sidekiq"
+	_ "github.com/amagimedia/judo/v3/protocols/pub/stan"
+	_ "github.com/amagimedia/judo/v3/protocols/req/nano"
+	_ "github.com/amagimedia/judo/v3/protocols/req/nats"
 	"github.com/amagimedia/judo/v3/publisher"
-	"github.com/google/uuid"
+	"github.com/amagimedia/judo/v3/registry"
 )
 
+// legMethods are tried in order when resolving a primary or backup leg, so
+// request-only transports such as nano can still be used as a leg.
+var legMethods = []string{"publish", "req"}
+
 type AmagiPub struct {
 	primaryPublisher publisher.JudoPub
 	backupPublisher  publisher.JudoPub
 }
 
+func init() {
+	registry.RegisterPublisher("amagi", "publish", func(legs registry.Legs) (publisher.JudoPub, error) {
+		return New(legs.Primary, legs.Backup)
+	})
+}
+
 func New(primaryPubProtocol, backupPubProtocol string) (publisher.JudoPub, error) {
+	if primaryPubProtocol == "" {
+		return nil, errors.New("Invalid Parameters, primary protocol missing")
+	}
 	publishers := &AmagiPub{}
 	var err error
 	publishers.primaryPublisher, err = NewPublisher(primaryPubProtocol)
@@ -30,13 +47,15 @@ func New(primaryPubProtocol, backupPubProtocol string) (publisher.JudoPub, error
 	}
 	return publishers, nil
 }
-
 func (publishers *AmagiPub) Connect(configs []interface{}) error {
 	primaryConfig := []interface{}{configs[0]}
 	err := publishers.primaryPublisher.Connect(primaryConfig)
 	if err != nil {
 		return err
 	}
+	if publishers.backupPublisher == nil {
+		return nil
+	}
 	backupConfig := []interface{}{configs[1]}
 	err = publishers.backupPublisher.Connect(backupConfig)
 	if err != nil {
@@ -46,63 +65,48 @@ func (publishers *AmagiPub) Connect(configs []interface{}) error {
 }
 
 func (publishers *AmagiPub) Publish(subject string, msg []byte) error {
-	dt, _ := uuid.NewRandom()
-	msgString := fmt.Sprintf("%s|%s", dt.String(), string(msg))
-	msgNew := []byte(msgString)
-	err := publishers.primaryPublisher.Publish(subject, msgNew)
+	return publishers.PublishWithHeaders(subject, msg, nil)
+}
+
+// PublishWithHeaders sends the same envelope on both legs, so that
+// subscribers of both can drop the copy that arrives second.
+func (publishers *AmagiPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	data := jmsg.Encode(env)
+	err := publishers.primaryPublisher.Publish(subject, data)
 	if err != nil {
 		return err
 	}
 	if publishers.backupPublisher != nil {
-		err = publishers.backupPublisher.Publish(subject, msgNew)
-		return err
+		return publishers.backupPublisher.Publish(subject, data)
 	}
 	return nil
 }
 
 func (publishers *AmagiPub) Close() error {
-	var errs []error
-	errs = append(errs, publishers.primaryPublisher.Close())
+	err := publishers.primaryPublisher.Close()
 	if publishers.backupPublisher != nil {
-		errs = append(errs, publishers.backupPublisher.Close())
-	}
-	if len(errs) != 0 {
-		return errs[0]
+		if backupErr := publishers.backupPublisher.Close(); err == nil {
+			err = backupErr
+		}
 	}
-	return nil
+	return err
 }
 
+// NewPublisher resolves a single amagi leg. An empty protocol means the leg
+// is not used.
 func NewPublisher(protocol string) (publisher.JudoPub, error) {
-	var pub publisher.JudoPub
-	var err error
-	switch protocol {
-	case "redis":
-		pub, err = redispub.New()
-		if err != nil {
-			return nil, err
-		}
-	case "sidekiq":
-		pub, err = sidekiqpub.New()
-		if err != nil {
-			return nil, err
-		}
-	case "nano":
-		pub, err = nanoreq.New()
-		if err != nil {
-			return nil, err
-		}
-	case "nats":
-		pub, err = stanpub.New()
-		if err != nil {
-			return nil, err
-		}
-	case "pubnub":
-		pub, err = pubnubPub.New()
-		if err != nil {
-			return nil, err
+	if protocol == "" {
+		return nil, nil
+	}
+	var unknown *registry.UnknownProtocolError
+	for _, method := range legMethods {
+		pub, err := registry.NewPublisher(protocol, method, registry.Legs{})
+		if errors.As(err, &unknown) {
+			continue
 		}
-	default:
-		return pub, nil
+		return pub, err
 	}
-	return pub, nil
+	return nil, &registry.UnknownProtocolError{Kind: "publisher", Protocol: protocol, Method: legMethods[0]}
 }
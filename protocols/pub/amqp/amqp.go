@@ -0,0 +1,270 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
+	gamqp "github.com/streadway/amqp"
+)
+
+type Config struct {
+	User               string
+	Password           string
+	Host               string
+	Port               string
+	ExchangeName       string
+	ExchangeType       string
+	ExchangeDurable    bool
+	ExchangeAutoDelete bool
+	Internal           bool
+	ExchangeNoWait     bool
+	Passive            bool
+	RoutingKey         string
+	Mandatory          bool
+	Persistent         bool
+	ContentType        string
+	Headers            gamqp.Table
+	Args               gamqp.Table
+	judoConfig.TLSConfig
+}
+
+var amqpmap = map[string]string{
+	"user":               "User",
+	"password":           "Password",
+	"host":               "Host",
+	"port":               "Port",
+	"exchangeName":       "ExchangeName",
+	"exchangeType":       "ExchangeType",
+	"exchangeDurable":    "ExchangeDurable",
+	"exchangeAutoDelete": "ExchangeAutoDelete",
+	"internal":           "Internal",
+	"exchangeNoWait":     "ExchangeNoWait",
+	"passive":            "Passive",
+	"routingKey":         "RoutingKey",
+	"mandatory":          "Mandatory",
+	"persistent":         "Persistent",
+	"contentType":        "ContentType",
+	"headers":            "Headers",
+	"args":               "Args",
+}
+
+func (c *Config) GetKeys() []string {
+	return append([]string{
+		"user",
+		"password",
+		"host",
+		"port",
+		"exchangeName",
+		"exchangeType",
+		"exchangeDurable",
+		"exchangeAutoDelete",
+		"internal",
+		"exchangeNoWait",
+		"passive",
+		"routingKey",
+		"mandatory",
+		"persistent",
+		"contentType",
+		"headers",
+		"args",
+	}, judoConfig.TLSKeys...)
+}
+
+func (c *Config) GetMandatoryKeys() []string {
+	return []string{
+		"user",
+		"password",
+		"host",
+		"port",
+		"exchangeName",
+		"exchangeType",
+	}
+}
+
+func (c *Config) GetField(key string) string {
+	if field, ok := amqpmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+type amqpConnector func(*Config) (jmsg.RawChannel, error)
+
+type amqpPub struct {
+	connector amqpConnector
+	channel   jmsg.RawChannel
+	config    *Config
+	returns   chan gamqp.Return
+	confirms  chan gamqp.Confirmation
+	mu        sync.Mutex
+}
+
+func (pub *amqpPub) Connect(configs []interface{}) error {
+
+	config := &Config{ContentType: "text/plain"}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+
+	err := cfgHelper.Load(configs[0])
+	if err != nil {
+		return err
+	}
+
+	pub.channel, err = pub.connector(config)
+	if err != nil {
+		return redact.Error(err, config.Password)
+	}
+	pub.config = config
+
+	return redact.Error(pub.setup(config), config.Password)
+}
+
+// setup declares the exchange, or only checks that it exists when passive is
+// set. Mandatory publishing puts the channel in confirm mode so that
+// unroutable messages can be reported back to the caller of Publish.
+func (pub *amqpPub) setup(c *Config) error {
+
+	declare := pub.channel.ExchangeDeclare
+	if c.Passive {
+		declare = pub.channel.ExchangeDeclarePassive
+	}
+
+	err := declare(
+		c.ExchangeName,
+		c.ExchangeType,
+		c.ExchangeDurable,
+		c.ExchangeAutoDelete,
+		c.Internal,
+		c.ExchangeNoWait,
+		c.Args,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !c.Mandatory {
+		return nil
+	}
+
+	err = pub.channel.Confirm(false)
+	if err != nil {
+		return err
+	}
+	pub.returns = pub.channel.NotifyReturn(make(chan gamqp.Return))
+	pub.confirms = pub.channel.NotifyPublish(make(chan gamqp.Confirmation))
+
+	return nil
+}
+
+// Publish sends msg to the configured exchange. The subject is used as the
+// routing key and falls back to the configured routingKey when empty.
+func (pub *amqpPub) Publish(subject string, msg []byte) error {
+	return pub.publish(subject, msg, pub.config.Headers)
+}
+
+// PublishWithHeaders sends headers as AMQP headers, on top of the configured
+// ones.
+func (pub *amqpPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if pub.config == nil {
+		return pub.publish(subject, msg, nil)
+	}
+	table := gamqp.Table{}
+	for key, val := range pub.config.Headers {
+		table[key] = val
+	}
+	for key, val := range headers {
+		table[key] = val
+	}
+	return pub.publish(subject, msg, table)
+}
+
+func (pub *amqpPub) publish(subject string, msg []byte, headers gamqp.Table) error {
+	if pub.channel == nil {
+		return errors.New("Unable to publish message, not connected to server.")
+	}
+
+	key := subject
+	if key == "" {
+		key = pub.config.RoutingKey
+	}
+
+	publishing := gamqp.Publishing{
+		ContentType:  pub.config.ContentType,
+		Headers:      headers,
+		DeliveryMode: gamqp.Transient,
+		Body:         msg,
+	}
+	if pub.config.Persistent {
+		publishing.DeliveryMode = gamqp.Persistent
+	}
+
+	if !pub.config.Mandatory {
+		return pub.channel.Publish(pub.config.ExchangeName, key, false, false, publishing)
+	}
+
+	// Confirms are matched to publishes by order, so mandatory publishes
+	// are serialised.
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+
+	err := pub.channel.Publish(pub.config.ExchangeName, key, true, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	return pub.waitConfirm()
+}
+
+// waitConfirm blocks until the broker confirms the last publish. A return for
+// an unroutable message always arrives before its confirmation.
+func (pub *amqpPub) waitConfirm() error {
+	var returned error
+	for {
+		select {
+		case ret, ok := <-pub.returns:
+			if !ok {
+				return errors.New("Channel closed while waiting for publish confirmation.")
+			}
+			returned = fmt.Errorf("Message returned by server: %d %s", ret.ReplyCode, ret.ReplyText)
+		case confirm, ok := <-pub.confirms:
+			if !ok {
+				return errors.New("Channel closed while waiting for publish confirmation.")
+			}
+			if !confirm.Ack {
+				return errors.New("Message rejected by server.")
+			}
+			return returned
+		}
+	}
+}
+
+func (pub *amqpPub) Close() error {
+	if pub.channel == nil {
+		return nil
+	}
+	return pub.channel.Close()
+}
+
+func amqpConnect(cfg *Config) (jmsg.RawChannel, error) {
+
+	tlsCfg, err := cfg.ClientTLS(cfg.Host)
+	if err != nil {
+		return jmsg.AmqpRawChannel{}, err
+	}
+	return jmsg.DialAmqp(cfg.User, cfg.Password, cfg.Host, cfg.Port, tlsCfg)
+}
+
+func init() {
+	registry.RegisterPublisher("amqp", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
+func New() (publisher.JudoPub, error) {
+	return &amqpPub{connector: amqpConnect}, nil
+}
@@ -0,0 +1,139 @@
+package amqp
+
+import (
+	"errors"
+	"testing"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	gamqp "github.com/streadway/amqp"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAmqpPublisher(t *testing.T) {
+	cfg := func(extra map[string]interface{}) []interface{} {
+		c := map[string]interface{}{
+			"user":         "guest",
+			"password":     "guest",
+			"host":         "localhost",
+			"port":         "5672",
+			"exchangeName": "blip_localhost",
+			"exchangeType": "topic",
+			"routingKey":   "blip.da",
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return []interface{}{c}
+	}
+
+	cases := []struct {
+		name   string
+		config []interface{}
+	}{
+		{"declare", cfg(nil)},
+		{"passive", cfg(map[string]interface{}{"passive": true})},
+		{"declare-err", cfg(nil)},
+		{"cfg-err", []interface{}{map[string]interface{}{"host": "localhost"}}},
+		{"persistent", cfg(map[string]interface{}{"persistent": true, "contentType": "application/json", "headers": map[string]interface{}{"tenant": "amagi"}})},
+		{"headers", cfg(map[string]interface{}{"headers": map[string]interface{}{"tenant": "amagi"}})},
+		{"mandatory-ok", cfg(map[string]interface{}{"mandatory": true})},
+		{"mandatory-returned", cfg(map[string]interface{}{"mandatory": true})},
+	}
+
+	for _, c := range cases {
+		fakeChannel := &mocks.RawChannel{}
+		pub := &amqpPub{connector: func(*Config) (jmsg.RawChannel, error) { return fakeChannel, nil }}
+
+		switch c.name {
+		case "declare":
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(nil).Once()
+			if err := pub.Connect(c.config); err != nil {
+				t.Error("Unable to connect", err.Error())
+			}
+			fakeChannel.On("Publish", "blip_localhost", "blip.da", false, false, gamqp.Publishing{
+				ContentType:  "text/plain",
+				DeliveryMode: gamqp.Transient,
+				Body:         []byte("MSG"),
+			}).Return(nil).Once()
+			if err := pub.Publish("", []byte("MSG")); err != nil {
+				t.Error("Unable to publish", err.Error())
+			}
+		case "passive":
+			fakeChannel.On("ExchangeDeclarePassive", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(nil).Once()
+			if err := pub.Connect(c.config); err != nil {
+				t.Error("Unable to connect", err.Error())
+			}
+		case "declare-err":
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(errors.New("Error in Exchange-declare")).Once()
+			if err := pub.Connect(c.config); err == nil {
+				t.Error("Did not throw error correctly")
+			}
+		case "cfg-err":
+			if err := pub.Connect(c.config); err == nil || err.Error() != "Key Missing : user" {
+				t.Error("Incorrect error thrown in ConfigHelper", err)
+			}
+		case "persistent":
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(nil).Once()
+			if err := pub.Connect(c.config); err != nil {
+				t.Error("Unable to connect", err.Error())
+			}
+			fakeChannel.On("Publish", "blip_localhost", "blip.other", false, false, gamqp.Publishing{
+				ContentType:  "application/json",
+				Headers:      gamqp.Table{"tenant": "amagi"},
+				DeliveryMode: gamqp.Persistent,
+				Body:         []byte("MSG"),
+			}).Return(nil).Once()
+			if err := pub.Publish("blip.other", []byte("MSG")); err != nil {
+				t.Error("Unable to publish", err.Error())
+			}
+		case "headers":
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(nil).Once()
+			if err := pub.Connect(c.config); err != nil {
+				t.Error("Unable to connect", err.Error())
+			}
+			fakeChannel.On("Publish", "blip_localhost", "blip.da", false, false, gamqp.Publishing{
+				ContentType:  "text/plain",
+				Headers:      gamqp.Table{"tenant": "amagi", "trace-id": "abc"},
+				DeliveryMode: gamqp.Transient,
+				Body:         []byte("MSG"),
+			}).Return(nil).Once()
+			if err := pub.PublishWithHeaders("", []byte("MSG"), map[string]string{"trace-id": "abc"}); err != nil {
+				t.Error("Unable to publish", err.Error())
+			}
+			if pub.config.Headers["trace-id"] != nil {
+				t.Error("Per message headers leaked into the configured headers")
+			}
+		case "mandatory-ok", "mandatory-returned":
+			returns := make(chan gamqp.Return)
+			confirms := make(chan gamqp.Confirmation)
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, gamqp.Table(nil)).Return(nil).Once()
+			fakeChannel.On("Confirm", false).Return(nil).Once()
+			fakeChannel.On("NotifyReturn", mock.AnythingOfType("chan amqp.Return")).Return(returns).Once()
+			fakeChannel.On("NotifyPublish", mock.AnythingOfType("chan amqp.Confirmation")).Return(confirms).Once()
+			if err := pub.Connect(c.config); err != nil {
+				t.Error("Unable to connect", err.Error())
+			}
+			fakeChannel.On("Publish", "blip_localhost", "blip.da", true, false, gamqp.Publishing{
+				ContentType:  "text/plain",
+				DeliveryMode: gamqp.Transient,
+				Body:         []byte("MSG"),
+			}).Return(nil).Once()
+			returned := c.name == "mandatory-returned"
+			go func() {
+				if returned {
+					returns <- gamqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+				}
+				confirms <- gamqp.Confirmation{DeliveryTag: 1, Ack: true}
+			}()
+			err := pub.Publish("", []byte("MSG"))
+			if returned && err == nil {
+				t.Error("Returned message not reported")
+			}
+			if !returned && err != nil {
+				t.Error("Unable to publish", err.Error())
+			}
+		}
+		fakeChannel.AssertExpectations(t)
+	}
+}
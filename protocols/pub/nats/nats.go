@@ -0,0 +1,149 @@
+package nats
+
+import (
+	"errors"
+	"strings"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
+	gnats "github.com/nats-io/go-nats"
+)
+
+type Config struct {
+	Name     string
+	Topic    string
+	Endpoint string
+	User     string
+	Password string
+	Token    string
+	judoConfig.TLSConfig
+}
+
+var natsmap = map[string]string{
+	"name":     "Name",
+	"topic":    "Topic",
+	"endpoint": "Endpoint",
+	"user":     "User",
+	"password": "Password",
+	"token":    "Token",
+}
+
+func (c *Config) GetKeys() []string {
+	return append([]string{
+		"name",
+		"topic",
+		"endpoint",
+		"user",
+		"password",
+		"token",
+	}, judoConfig.TLSKeys...)
+}
+
+func (c *Config) GetMandatoryKeys() []string {
+	return []string{"name", "endpoint"}
+}
+
+func (c *Config) GetField(key string) string {
+	if field, ok := natsmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+type natsConnector func(*Config) (jmsg.RawConnection, error)
+
+type natsPub struct {
+	connector  natsConnector
+	connection jmsg.RawConnection
+	config     *Config
+}
+
+func (pub *natsPub) Connect(configs []interface{}) error {
+
+	config := &Config{}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+
+	err := cfgHelper.Load(configs[0])
+	if err != nil {
+		return err
+	}
+
+	pub.connection, err = pub.connector(config)
+	if err != nil {
+		return redact.Error(err, config.Password, config.Token)
+	}
+	pub.config = config
+
+	return nil
+}
+
+// Publish sends msg to subject, or to the configured topic when subject is
+// empty.
+func (pub *natsPub) Publish(subject string, msg []byte) error {
+	if pub.connection == nil {
+		return errors.New("Unable to publish message, not connected to server.")
+	}
+	if subject == "" {
+		subject = pub.config.Topic
+	}
+	return pub.connection.Publish(subject, msg)
+}
+
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (pub *natsPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return pub.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return pub.Publish(subject, jmsg.Encode(env))
+}
+
+func (pub *natsPub) Close() error {
+	if pub.connection != nil {
+		pub.connection.Close()
+	}
+	return nil
+}
+
+func natsConnect(cfg *Config) (jmsg.RawConnection, error) {
+	url := cfg.Endpoint
+	if !strings.Contains(url, "://") {
+		url = "nats://" + url
+	}
+
+	opts := []gnats.Option{gnats.Name(cfg.Name)}
+	if cfg.User != "" && cfg.Password != "" {
+		opts = append(opts, gnats.UserInfo(cfg.User, cfg.Password))
+	} else if cfg.Token != "" {
+		opts = append(opts, gnats.Token(cfg.Token))
+	}
+	tlsCfg, err := cfg.ClientTLS("")
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, gnats.Secure(tlsCfg))
+	}
+
+	connection, err := gnats.Connect(url, opts...)
+	if err != nil {
+		return nil, redact.Error(err, cfg.Password, cfg.Token)
+	}
+	return &jmsg.NatsRawConnection{Conn: connection}, nil
+}
+
+func init() {
+	// "nats-publish" stays on NATS Streaming for existing callers.
+	registry.RegisterPublisher("nats-core", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
+func New() (publisher.JudoPub, error) {
+	return &natsPub{connector: natsConnect}, nil
+}
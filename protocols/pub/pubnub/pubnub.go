@@ -3,6 +3,7 @@ package pubnub
 import (
 	judoConfig "github.com/amagimedia/judo/v3/config"
 	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/registry"
 	pubnub "github.com/pubnub/go"
 )
 
@@ -38,8 +39,8 @@ type pubnubPub struct {
 func (pub *pubnubPub) Connect(configs []interface{}) error {
 
 	config := &Config{}
-	cfgHelper := judoConfig.ConfigHelper{config}
-	err := cfgHelper.ValidateAndSet(configs[0].(map[string]interface{}))
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+	err := cfgHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
@@ -53,9 +54,17 @@ func (pub *pubnubPub) Connect(configs []interface{}) error {
 }
 
 func (pub *pubnubPub) Publish(subject string, msg []byte) error {
+	return pub.PublishWithHeaders(subject, msg, nil)
+}
+
+// PublishWithHeaders sends headers as a field next to msg.
+func (pub *pubnubPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
 	mesg := map[string]interface{}{
 		"msg": string(msg),
 	}
+	if len(headers) > 0 {
+		mesg["headers"] = headers
+	}
 	_, _, err := pub.Client.Publish().
 		Channel(subject).
 		Message(mesg).
@@ -67,6 +76,12 @@ func (pub *pubnubPub) Close() error {
 	return nil
 }
 
+func init() {
+	registry.RegisterPublisher("pubnub", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
 func New() (publisher.JudoPub, error) {
 	return &pubnubPub{}, nil
 }
@@ -1,10 +1,11 @@
 package redis
 
 import (
-	"crypto/tls"
-
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/scripts"
 	gredis "github.com/go-redis/redis"
 )
@@ -14,11 +15,11 @@ type Config struct {
 	Port     string
 	Password string
 	DB       int
-	Tls      bool
+	judoConfig.TLSConfig
 }
 
 func (c *Config) GetKeys() []string {
-	return []string{"host", "port", "password", "db", "tls"}
+	return append([]string{"host", "port", "password", "db"}, judoConfig.TLSKeys...)
 }
 
 func (c *Config) GetMandatoryKeys() []string {
@@ -35,10 +36,8 @@ func (c *Config) GetField(key string) string {
 		return "Password"
 	case "db":
 		return "DB"
-	case "tls":
-		return "Tls"
 	default:
-		return ""
+		return judoConfig.TLSField(key)
 	}
 	return ""
 }
@@ -50,33 +49,27 @@ type redisPub struct {
 func (pub *redisPub) Connect(configs []interface{}) error {
 
 	config := &Config{}
-	cfgHelper := judoConfig.ConfigHelper{config}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
 
-	err := cfgHelper.ValidateAndSet(configs[0].(map[string]interface{}))
+	err := cfgHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
 
-	if config.Tls {
-
-		pub.Client = gredis.NewClient(&gredis.Options{
-			Addr:      config.Host + ":" + config.Port,
-			Password:  config.Password,
-			TLSConfig: &tls.Config{},
-		})
-
-	} else {
-
-		pub.Client = gredis.NewClient(&gredis.Options{
-			Addr:     config.Host + ":" + config.Port,
-			Password: config.Password,
-		})
-
+	tlsCfg, err := config.ClientTLS(config.Host)
+	if err != nil {
+		return err
 	}
+	pub.Client = gredis.NewClient(&gredis.Options{
+		Addr:      config.Host + ":" + config.Port,
+		Password:  config.Password,
+		DB:        config.DB,
+		TLSConfig: tlsCfg,
+	})
 
 	err = pub.loadRedisScripts()
 
-	return err
+	return redact.Error(err, config.Password)
 }
 
 func (pub *redisPub) Publish(subject string, msg []byte) error {
@@ -84,6 +77,17 @@ func (pub *redisPub) Publish(subject string, msg []byte) error {
 	return errCap.Err()
 }
 
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (pub *redisPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return pub.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return pub.Publish(subject, jmsg.Encode(env))
+}
+
 func (pub *redisPub) Close() error {
 	return pub.Client.Close()
 }
@@ -99,6 +103,12 @@ func (pub *redisPub) loadRedisScripts() error {
 	return nil
 }
 
+func init() {
+	registry.RegisterPublisher("redis", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
 func New() (publisher.JudoPub, error) {
 	return &redisPub{}, nil
 }
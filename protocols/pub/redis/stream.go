@@ -0,0 +1,100 @@
+package redis
+
+import (
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
+	gredis "github.com/go-redis/redis"
+)
+
+// StreamConfig adds to Config the length around which streams are trimmed
+// on every publish. Streams grow without bound when MaxLen is zero.
+type StreamConfig struct {
+	Config
+	MaxLen int
+}
+
+func (c *StreamConfig) GetKeys() []string {
+	return append(c.Config.GetKeys(), "maxLen")
+}
+
+func (c *StreamConfig) GetField(key string) string {
+	if key == "maxLen" {
+		return "MaxLen"
+	}
+	return c.Config.GetField(key)
+}
+
+// streamPub appends messages to the stream named by the subject with XADD.
+// The redis-stream subscriber reads them with XREADGROUP and reclaims them
+// with XPENDING and XCLAIM, as go-redis v6 has no XAUTOCLAIM.
+type streamPub struct {
+	Client jmsg.StreamRawClient
+	maxLen int64
+}
+
+func (pub *streamPub) Connect(configs []interface{}) error {
+
+	config := &StreamConfig{}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+
+	err := cfgHelper.Load(configs[0])
+	if err != nil {
+		return err
+	}
+
+	tlsCfg, err := config.ClientTLS(config.Host)
+	if err != nil {
+		return err
+	}
+	client := gredis.NewClient(&gredis.Options{
+		Addr:      config.Host + ":" + config.Port,
+		Password:  config.Password,
+		DB:        config.DB,
+		TLSConfig: tlsCfg,
+	})
+	if err = client.Ping().Err(); err != nil {
+		client.Close()
+		return redact.Error(err, config.Password)
+	}
+
+	pub.Client = jmsg.RedisStreamRawClient{Client: client}
+	pub.maxLen = int64(config.MaxLen)
+	return nil
+}
+
+func (pub *streamPub) Publish(subject string, msg []byte) error {
+	_, err := pub.Client.XAdd(&gredis.XAddArgs{
+		Stream:       subject,
+		MaxLenApprox: pub.maxLen,
+		Values:       map[string]interface{}{jmsg.RedisStreamField: msg},
+	})
+	return err
+}
+
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (pub *streamPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return pub.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return pub.Publish(subject, jmsg.Encode(env))
+}
+
+func (pub *streamPub) Close() error {
+	return pub.Client.Close()
+}
+
+func init() {
+	registry.RegisterPublisher("redis-stream", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return NewStream()
+	})
+}
+
+func NewStream() (publisher.JudoPub, error) {
+	return &streamPub{}, nil
+}
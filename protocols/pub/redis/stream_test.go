@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"testing"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	gredis "github.com/go-redis/redis"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStreamConfig(t *testing.T) {
+	config := &StreamConfig{}
+	err := judoConfig.ConfigHelper{Config: config}.ValidateAndSetStrict(map[string]interface{}{
+		"host":   "localhost",
+		"port":   "6379",
+		"maxLen": 1000,
+		"tls":    true,
+	})
+	if err != nil || config.Host != "localhost" || config.MaxLen != 1000 || !config.Tls {
+		t.Errorf("Unexpected config %+v %v", config, err)
+	}
+}
+
+func TestStreamPublish(t *testing.T) {
+	fakeClient := &mocks.StreamRawClient{}
+	pub := &streamPub{Client: fakeClient, maxLen: 1000}
+	fakeClient.On("XAdd", mock.MatchedBy(func(a *gredis.XAddArgs) bool {
+		return a.Stream == "jobs" && a.MaxLenApprox == 1000 && string(a.Values[jmsg.RedisStreamField].([]byte)) == "plain"
+	})).Return("1-0", nil)
+	fakeClient.On("XAdd", mock.MatchedBy(func(a *gredis.XAddArgs) bool {
+		env := jmsg.Decode(a.Values[jmsg.RedisStreamField].([]byte))
+		return string(env.Body) == "wrapped" && env.Headers["trace"] == "abc"
+	})).Return("2-0", nil)
+
+	if err := pub.Publish("jobs", []byte("plain")); err != nil {
+		t.Error("Publish failed", err)
+	}
+	if err := pub.PublishWithHeaders("jobs", []byte("wrapped"), map[string]string{"trace": "abc"}); err != nil {
+		t.Error("PublishWithHeaders failed", err)
+	}
+	fakeClient.AssertNumberOfCalls(t, "XAdd", 2)
+}
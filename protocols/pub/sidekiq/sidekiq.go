@@ -6,6 +6,8 @@ import (
 
 	judoConfig "github.com/amagimedia/judo/v3/config"
 	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
 	workers "github.com/jrallison/go-workers"
 )
 
@@ -53,9 +55,9 @@ type sidekiqPub struct {
 func (pub *sidekiqPub) Connect(configs []interface{}) error {
 
 	config := &Config{}
-	cfgHelper := judoConfig.ConfigHelper{config}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
 
-	err := cfgHelper.ValidateAndSet(configs[0].(map[string]interface{}))
+	err := cfgHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
@@ -90,13 +92,19 @@ func (pub *sidekiqPub) Publish(subject string, msg []byte) error {
 			At:    float64((time.Now().Add(3 * time.Second)).UnixNano()) / workers.NanoSecondPrecision},
 	)
 
-	return err
+	return redact.Error(err, pub.config.Password)
 }
 
 func (pub *sidekiqPub) Close() error {
 	return nil
 }
 
+func init() {
+	registry.RegisterPublisher("sidekiq", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
 func New() (publisher.JudoPub, error) {
 	return &sidekiqPub{}, nil
 }
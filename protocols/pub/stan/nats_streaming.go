@@ -5,7 +5,10 @@ import (
 	"time"
 
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/registry"
+	gnats "github.com/nats-io/go-nats"
 	gstan "github.com/nats-io/go-nats-streaming"
 )
 
@@ -14,7 +17,8 @@ type Config struct {
 	Topic    string
 	Endpoint string
 	Cluster  string
-	AckTime  int
+	AckTime  time.Duration
+	judoConfig.TLSConfig
 }
 
 var natsSmap = map[string]string{
@@ -26,13 +30,13 @@ var natsSmap = map[string]string{
 }
 
 func (c *Config) GetKeys() []string {
-	return []string{
+	return append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"cluster",
 		"ack_time",
-	}
+	}, judoConfig.TLSKeys...)
 }
 
 func (c *Config) GetMandatoryKeys() []string {
@@ -46,34 +50,54 @@ func (c *Config) GetMandatoryKeys() []string {
 }
 
 func (c *Config) GetField(key string) string {
-	return natsSmap[key]
+	if field, ok := natsSmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
 }
 
 type stanPub struct {
 	Client    gstan.Conn
+	nc        *gnats.Conn
 	connected bool
 }
 
 func (pub *stanPub) Connect(configs []interface{}) error {
 
 	config := &Config{}
-	cfgHelper := judoConfig.ConfigHelper{config}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
 
-	err := cfgHelper.ValidateAndSet(configs[0].(map[string]interface{}))
+	err := cfgHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
 
-	pub.Client, err = gstan.Connect(
-		config.Cluster,
-		config.Name,
+	opts := []gstan.Option{
 		gstan.NatsURL(config.Endpoint),
-		gstan.PubAckWait(time.Millisecond*time.Duration(config.AckTime)),
+		gstan.PubAckWait(config.AckTime),
 		gstan.SetConnectionLostHandler(pub.disconnected),
-	)
+	}
+	tlsCfg, err := config.ClientTLS("")
 	if err != nil {
 		return err
 	}
+	if tlsCfg != nil {
+		// NATS Streaming cannot dial TLS itself, so it is handed a NATS
+		// connection set up like the one it would open.
+		pub.nc, err = gnats.Connect(config.Endpoint, gnats.Name(config.Name), gnats.MaxReconnects(-1), gnats.ReconnectBufSize(-1), gnats.Secure(tlsCfg))
+		if err != nil {
+			return err
+		}
+		opts = append(opts, gstan.NatsConn(pub.nc))
+	}
+
+	pub.Client, err = gstan.Connect(config.Cluster, config.Name, opts...)
+	if err != nil {
+		if pub.nc != nil {
+			pub.nc.Close()
+		}
+		return err
+	}
 	pub.connected = true
 
 	return nil
@@ -87,8 +111,23 @@ func (pub *stanPub) Publish(subject string, msg []byte) error {
 	return fmt.Errorf("Unable to publish message, disconnected from server.")
 }
 
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (pub *stanPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return pub.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return pub.Publish(subject, jmsg.Encode(env))
+}
+
 func (pub *stanPub) Close() error {
-	return pub.Client.Close()
+	err := pub.Client.Close()
+	if pub.nc != nil {
+		pub.nc.Close()
+	}
+	return err
 }
 
 func (pub *stanPub) isConnected() bool {
@@ -99,6 +138,16 @@ func (pub *stanPub) disconnected(conn gstan.Conn, err error) {
 	pub.connected = false
 }
 
+func init() {
+	// "nats-publish" has historically created a NATS Streaming publisher.
+	registry.RegisterPublisher("nats-streaming", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+	registry.RegisterPublisher("nats", "publish", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
 func New() (publisher.JudoPub, error) {
 	return &stanPub{}, nil
 }
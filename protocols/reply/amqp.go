@@ -2,11 +2,16 @@ package reply
 
 import (
 	"errors"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/amagimedia/judo/v3/service"
 	"github.com/streadway/amqp"
 )
 
@@ -17,8 +22,10 @@ type AmqpReply struct {
 	connector amqpConnector
 	queue     amqp.Queue
 	msgQueue  <-chan amqp.Delivery
-	amqpConfig
+	AmqpConfig
 	callback func(jmsg.Message)
+	runtime  service.Runtime
+	mu       sync.Mutex
 }
 
 var amqpmap = map[string]string{
@@ -37,7 +44,7 @@ var amqpmap = map[string]string{
 	"port":            "Port",
 }
 
-type amqpConfig struct {
+type AmqpConfig struct {
 	User       string
 	Password   string
 	Host       string
@@ -51,10 +58,12 @@ type amqpConfig struct {
 	NoWait     bool
 	NoLocal    bool
 	Args       amqp.Table
+	service.ReconnectConfig
+	judoConfig.TLSConfig
 }
 
-func (c amqpConfig) GetKeys() []string {
-	return []string{
+func (c AmqpConfig) GetKeys() []string {
+	keys := append([]string{
 		"user",
 		"password",
 		"host",
@@ -67,10 +76,11 @@ func (c amqpConfig) GetKeys() []string {
 		"autoAck",
 		"exclusive",
 		"noLocal",
-	}
+	}, service.ReconnectKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c amqpConfig) GetMandatoryKeys() []string {
+func (c AmqpConfig) GetMandatoryKeys() []string {
 	return []string{
 		"user",
 		"password",
@@ -81,8 +91,20 @@ func (c amqpConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c amqpConfig) GetField(key string) string {
-	return amqpmap[key]
+func (c AmqpConfig) GetField(key string) string {
+	if field, ok := amqpmap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	return service.ReconnectField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("amqp", "reply", func(registry.Legs) (client.JudoClient, error) {
+		return NewAmqpReply(), nil
+	})
 }
 
 func NewAmqpReply() *AmqpReply {
@@ -94,64 +116,166 @@ func (rep *AmqpReply) Start() (<-chan error, error) {
 
 	var err error
 	errorChannel := make(chan error)
+	if rep.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
-	rep.msgQueue, err = rep.channel.Consume(
-		rep.queue.Name,           // queue
-		rep.amqpConfig.Tag,       // consumer
-		rep.amqpConfig.AutoAck,   // consumer
-		rep.amqpConfig.Exclusive, // consumer
-		rep.amqpConfig.NoLocal,   // consumer
-		rep.amqpConfig.NoWait,    // consumer
-		rep.amqpConfig.Args,      // consumer
-	)
-
+	rep.msgQueue, err = rep.consume()
 	if err != nil {
-		return errorChannel, err
+		return errorChannel, rep.runtime.Redact(err)
 	}
 
-	go func() {
+	rep.runtime.Labels = metrics.Labels{Protocol: "amqp", Topic: rep.AmqpConfig.QueueName}
+	rep.runtime.Open()
+	rep.runtime.Notify(client.Status{State: client.Connected})
+	go rep.receive(errorChannel)
+
+	return errorChannel, nil
+}
+
+func (rep *AmqpReply) consume() (<-chan amqp.Delivery, error) {
+	return rep.channel.Consume(
+		rep.queue.Name,           // queue
+		rep.AmqpConfig.Tag,       // consumer
+		rep.AmqpConfig.AutoAck,   // consumer
+		rep.AmqpConfig.Exclusive, // consumer
+		rep.AmqpConfig.NoLocal,   // consumer
+		rep.AmqpConfig.NoWait,    // consumer
+		rep.AmqpConfig.Args,      // consumer
+	)
+}
+
+func (rep *AmqpReply) receive(ec chan error) {
+	for {
 		for msg := range rep.msgQueue {
-			wrappedMsg := jmsg.AmqpMessage{jmsg.AmqpRawMessage{msg}, rep.channel, make(map[string]string)}
+			wrappedMsg := jmsg.AmqpMessage{
+				RawMessage: jmsg.AmqpRawMessage{Delivery: msg},
+				Responder:  rep.channel,
+				Properties: make(map[string]string),
+				Header:     jmsg.AmqpHeader(msg.Headers),
+				Logger:     rep.runtime.Log(),
+				Settlement: &jmsg.Settlement{},
+			}
 			wrappedMsg.SetProperty("protocol_type", "reqrep")
-			rep.callback(wrappedMsg)
+			rep.runtime.Received()
+			rep.runtime.Deliver("", wrappedMsg, wrappedMsg.Settlement, rep.runtime.Recover(ec, rep.callback))
 		}
-		errorChannel <- errors.New("Disconnected from server, connection closed.")
-	}()
+		if !rep.AmqpConfig.Reconnect {
+			rep.runtime.Report(ec, errors.New("Disconnected from server, connection closed."))
+			return
+		}
+		if !rep.reconnect() {
+			return
+		}
+	}
+}
 
-	return errorChannel, nil
+// reconnect redials with backoff until consuming resumes. It returns false
+// once the replier is closed.
+func (rep *AmqpReply) reconnect() bool {
+	select {
+	case <-rep.runtime.Done():
+		return false
+	default:
+	}
+	backoff := rep.AmqpConfig.Backoff()
+	rep.runtime.Notify(client.Status{State: client.Disconnected})
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff.Duration(attempt)):
+		case <-rep.runtime.Done():
+			return false
+		}
+		rep.runtime.Notify(client.Status{State: client.Reconnecting, Attempt: attempt})
+		err := rep.redial()
+		if err == nil {
+			rep.runtime.Notify(client.Status{State: client.Connected, Attempt: attempt})
+			return true
+		}
+		select {
+		case <-rep.runtime.Done():
+			return false
+		default:
+		}
+		rep.runtime.Notify(client.Status{State: client.Disconnected, Attempt: attempt, Err: err})
+	}
 }
 
-func (rep *AmqpReply) Close() {
+// redial replaces the channel and redeclares the queue before consuming
+// again.
+func (rep *AmqpReply) redial() error {
+	channel, err := rep.connector(rep.AmqpConfig)
+	if err != nil {
+		return err
+	}
+
+	rep.mu.Lock()
+	select {
+	case <-rep.runtime.Done():
+		rep.mu.Unlock()
+		channel.Close()
+		return errors.New("Replier closed.")
+	default:
+	}
 	rep.channel.Close()
+	rep.channel = channel
+	rep.mu.Unlock()
+
+	err = rep.setup(rep.AmqpConfig)
+	if err != nil {
+		return err
+	}
+	rep.msgQueue, err = rep.consume()
+	return err
+}
+
+// Status reports connection loss and recovery when reconnect is enabled.
+func (rep *AmqpReply) Status() <-chan client.Status {
+	return rep.runtime.Status()
+}
+
+// Close stops delivering requests, waits for the callbacks in flight and
+// then closes the channel.
+func (rep *AmqpReply) Close() error {
+	rep.runtime.Shutdown()
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.channel.Close()
 }
 
 func (rep *AmqpReply) OnMessage(callback func(jmsg.Message)) client.JudoClient {
-	rep.callback = callback
+	rep.callback = client.Chain(callback, rep.runtime.Middleware...)
 	return rep
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (rep *AmqpReply) Use(mw ...client.Middleware) {
+	rep.runtime.Middleware = append(rep.runtime.Middleware, mw...)
+}
+
 func (rep *AmqpReply) Configure(configs []interface{}) error {
 
 	// extract connection details from config and call connect
 	var err error
-	config := configs[0].(map[string]interface{})
-	cfgHelper := judoConfig.ConfigHelper{&rep.amqpConfig}
-	err = cfgHelper.ValidateAndSet(config)
+	cfgHelper := judoConfig.ConfigHelper{Config: &rep.AmqpConfig}
+	err = cfgHelper.Load(configs[0])
+	if err == nil {
+		err = rep.AmqpConfig.ValidateReconnect()
+	}
 	if err != nil {
 		return err
 	}
+	rep.runtime.SetSecrets(rep.AmqpConfig.Password)
 
-	rep.channel, err = rep.connector(rep.amqpConfig)
+	rep.channel, err = rep.connector(rep.AmqpConfig)
 	if err != nil {
-		return err
+		return rep.runtime.Redact(err)
 	}
 
-	err = rep.setup(rep.amqpConfig)
-
-	return err
+	return rep.runtime.Redact(rep.setup(rep.AmqpConfig))
 }
 
-func (rep *AmqpReply) setup(c amqpConfig) error {
+func (rep *AmqpReply) setup(c AmqpConfig) error {
 	var err error
 
 	rep.queue, err = rep.channel.QueueDeclare(
@@ -179,19 +303,21 @@ func (rep *AmqpReply) setup(c amqpConfig) error {
 
 func amqpConnect(c judoConfig.Config) (jmsg.RawChannel, error) {
 
-	var err error
-	cfg := c.(amqpConfig)
-	connectTo := fmt.Sprintf("amqp://%s:%s@%s:%s/", cfg.User, cfg.Password, cfg.Host, cfg.Port)
-	connection, err := amqp.Dial(connectTo)
-
+	cfg := c.(AmqpConfig)
+	tlsCfg, err := cfg.ClientTLS(cfg.Host)
 	if err != nil {
 		return jmsg.AmqpRawChannel{}, err
 	}
+	return jmsg.DialAmqp(cfg.User, cfg.Password, cfg.Host, cfg.Port, tlsCfg)
+}
 
-	channel, err := connection.Channel()
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the replier in m.
+func (rep *AmqpReply) SetMetrics(m metrics.Recorder) {
+	rep.runtime.Metrics = m
+}
 
-	if err != nil {
-		return jmsg.AmqpRawChannel{}, err
-	}
-	return jmsg.AmqpRawChannel{channel}, nil
+// SetLogger routes the internal events and errors of the replier to l.
+func (rep *AmqpReply) SetLogger(l logger.Logger) {
+	rep.runtime.Logger = l
 }
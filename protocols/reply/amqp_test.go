@@ -272,6 +272,7 @@ func TestAmqpSubscriber(t *testing.T) {
 				t.Error("Error in Configure", err.Error())
 			}
 			fakeChannel.On("Consume", "", "test", true, false, false, true, amqp.Table(nil)).Return(make(<-chan amqp.Delivery), nil).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err != nil {
 				t.Error("Error in Starting Consumer", err.Error())
@@ -3,10 +3,16 @@ package reply
 import (
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/amagimedia/judo/v3/service"
 	mangoRep "github.com/go-mangos/mangos/protocol/rep"
 	"github.com/go-mangos/mangos/transport/ipc"
 	"github.com/go-mangos/mangos/transport/tcp"
+	"github.com/go-mangos/mangos/transport/tlstcp"
+	mangos "nanomsg.org/go-mangos"
 )
 
 type nanoConnector func() (jmsg.RawSocket, error)
@@ -21,27 +27,29 @@ var nanomap = map[string]string{
 type NanoReply struct {
 	connector  nanoConnector
 	connection jmsg.RawSocket
-	nanoConfig
+	NanoConfig
 	callback func(jmsg.Message)
+	runtime  service.Runtime
 }
 
-type nanoConfig struct {
+type NanoConfig struct {
 	Name      string
 	Topic     string
 	Endpoint  string
 	Separator string
+	judoConfig.TLSConfig
 }
 
-func (c nanoConfig) GetKeys() []string {
-	return []string{
+func (c NanoConfig) GetKeys() []string {
+	return append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"separator",
-	}
+	}, judoConfig.TLSKeys...)
 }
 
-func (c nanoConfig) GetMandatoryKeys() []string {
+func (c NanoConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -49,8 +57,17 @@ func (c nanoConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c nanoConfig) GetField(key string) string {
-	return nanomap[key]
+func (c NanoConfig) GetField(key string) string {
+	if field, ok := nanomap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("nano", "reply", func(registry.Legs) (client.JudoClient, error) {
+		return NewNanoReply(), nil
+	})
 }
 
 func NewNanoReply() *NanoReply {
@@ -61,9 +78,8 @@ func NewNanoReply() *NanoReply {
 func (rep *NanoReply) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&rep.nanoConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &rep.NanoConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
@@ -71,19 +87,33 @@ func (rep *NanoReply) Configure(configs []interface{}) error {
 	return err
 }
 
-func (rep *NanoReply) Close() {
-	rep.connection.Close()
+// Close waits for the callbacks in flight and then closes the socket.
+func (rep *NanoReply) Close() error {
+	rep.runtime.Shutdown()
+	if rep.connection == nil {
+		return nil
+	}
+	return rep.connection.Close()
 }
 
 func (rep *NanoReply) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	rep.callback = callback
+	rep.callback = client.Chain(callback, rep.runtime.Middleware...)
 	return rep
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (rep *NanoReply) Use(mw ...client.Middleware) {
+	rep.runtime.Middleware = append(rep.runtime.Middleware, mw...)
+}
+
 func (rep *NanoReply) Start() (<-chan error, error) {
 
 	var err error
 	errorChannel := make(chan error)
+	if rep.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
+
 	rep.connection, err = rep.connector()
 
 	if err != nil {
@@ -92,12 +122,23 @@ func (rep *NanoReply) Start() (<-chan error, error) {
 
 	rep.connection.AddTransport(ipc.NewTransport())
 	rep.connection.AddTransport(tcp.NewTransport())
-	err = rep.connection.Listen(rep.nanoConfig.Endpoint)
+	rep.connection.AddTransport(tlstcp.NewTransport())
+	tlsCfg, err := rep.NanoConfig.ServerTLS()
+	if err != nil {
+		return errorChannel, err
+	}
+	if tlsCfg != nil {
+		err = rep.connection.ListenOptions(rep.NanoConfig.Endpoint, map[string]interface{}{mangos.OptionTLSConfig: tlsCfg})
+	} else {
+		err = rep.connection.Listen(rep.NanoConfig.Endpoint)
+	}
 
 	if err != nil {
 		return errorChannel, err
 	}
 
+	rep.runtime.Labels = metrics.Labels{Protocol: "nano", Topic: rep.NanoConfig.Topic}
+	rep.runtime.Open()
 	go rep.receive(errorChannel)
 
 	return errorChannel, err
@@ -107,11 +148,22 @@ func (rep *NanoReply) receive(ec chan error) {
 	for {
 		msg, err := rep.connection.Recv()
 		if err != nil {
-			ec <- err
+			rep.runtime.Report(ec, err)
+			return
+		}
+		env := jmsg.Decode(msg)
+		message := jmsg.NanoMessage{
+			RawMessage: jmsg.NanoRawMessage{Raw: env.Body},
+			Responder:  rep.connection,
+			Properties: make(map[string]string),
+			Header:     env.Headers,
+			Settlement: &jmsg.Settlement{},
+		}
+		jmsg.SetEnvelopeProperties(message, env)
+		rep.runtime.Received()
+		if !rep.runtime.Deliver("", message, message.Settlement, rep.runtime.Recover(ec, rep.callback)) {
 			return
 		}
-		message := jmsg.NanoMessage{jmsg.NanoRawMessage{msg}, rep.connection, make(map[string]string)}
-		rep.callback(message)
 	}
 }
 
@@ -121,5 +173,16 @@ func nanoConnect() (jmsg.RawSocket, error) {
 		return jmsg.NanoRawSocket{}, err
 	}
 
-	return jmsg.NanoRawSocket{socket}, nil
+	return jmsg.NanoRawSocket{Socket: socket}, nil
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the replier in m.
+func (rep *NanoReply) SetMetrics(m metrics.Recorder) {
+	rep.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the replier to l.
+func (rep *NanoReply) SetLogger(l logger.Logger) {
+	rep.runtime.Logger = l
 }
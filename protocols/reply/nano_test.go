@@ -1,12 +1,26 @@
 package reply
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
+	judoConfig "github.com/amagimedia/judo/v3/config"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
+	reqNano "github.com/amagimedia/judo/v3/protocols/req/nano"
+	"github.com/amagimedia/judo/v3/publisher"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -129,28 +143,34 @@ func TestNanoReply(t *testing.T) {
 				t.Error("Invalid Error thrown", err.Error())
 			}
 		case "success-start":
-			err := fakeSubscriber.Configure(c.config)
+			// The receive loop keeps reading the socket until it sees the
+			// subscriber closed, so later cases get a subscriber of their own.
+			fSocket := &mocks.RawSocket{}
+			fSubscriber := &NanoReply{connector: func() (message.RawSocket, error) {
+				return fSocket, nil
+			}}
+			err := fSubscriber.Configure(c.config)
 			if err != nil {
 				t.Error("Failed in verifying start method.")
 			}
 			called := false
-			fakeSubscriber.OnMessage(func(message.Message) {
+			fSubscriber.OnMessage(func(message.Message) {
 				called = true
 			})
-			fakeSocket.On("AddTransport", mock.Anything).Return(nil)
-			fakeSocket.On("Listen", "ipc:///tmp/dqi50n.out").Return(nil).Once()
-			fakeSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
-			fakeSocket.On("Recv").Return([]byte("a|b"), nil)
-			_, err = fakeSubscriber.Start()
+			fSocket.On("AddTransport", mock.Anything).Return(nil)
+			fSocket.On("Listen", "ipc:///tmp/dqi50n.out").Return(nil).Once()
+			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
+			fSocket.On("Recv").Return([]byte("a|b"), nil)
+			_, err = fSubscriber.Start()
 			if err != nil {
 				t.Error("Failed in verifying start method.")
 			}
 			time.Sleep(time.Millisecond * 100)
+			fSocket.On("Close").Return(nil)
+			fSubscriber.Close()
 			if !called {
 				t.Error("Failed in verifying start method.")
 			}
-			fakeSocket.On("Close").Return(nil)
-			fakeSubscriber.Close()
 		case "dial-err":
 			err := fakeSubscriber.Configure(c.config)
 			if err != nil {
@@ -158,6 +178,7 @@ func TestNanoReply(t *testing.T) {
 			}
 			fakeSocket.On("AddTransport", mock.Anything).Return(nil)
 			fakeSocket.On("Listen", "ipc:///tmp/dqi50n.out").Return(c.retType).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("Start did not fail when expected")
@@ -178,6 +199,7 @@ func TestNanoReply(t *testing.T) {
 			fSocket.On("Listen", "ipc:///tmp/dqi50n.out").Return(nil).Once()
 			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
 			fSocket.On("Recv").Return([]byte(""), c.retType).Once()
+			fSubscriber.OnMessage(func(message.Message) {})
 			ec, err := fSubscriber.Start()
 			err = <-ec
 			if err.Error() != c.retType.Error() {
@@ -192,6 +214,7 @@ func TestNanoReply(t *testing.T) {
 				t.Error("Configure failed when not expected.")
 			}
 
+			fSubscriber.OnMessage(func(message.Message) {})
 			_, err = fSubscriber.Start()
 
 			if err.Error() != c.retType.Error() {
@@ -201,3 +224,105 @@ func TestNanoReply(t *testing.T) {
 	}
 
 }
+
+// selfSigned writes a certificate for 127.0.0.1 that is its own authority,
+// so that it can serve as CA, server and client certificate at once.
+func selfSigned(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestNanoReplyTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := selfSigned(t, dir, "judo")
+	otherCert, otherKey := selfSigned(t, dir, "other")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := "tls+tcp://" + l.Addr().String()
+	l.Close()
+
+	rep := NewNanoReply()
+	err = rep.Configure([]interface{}{NanoConfig{
+		Name:      "agent",
+		Topic:     "jobs",
+		Endpoint:  endpoint,
+		TLSConfig: judoConfig.TLSConfig{TlsCA: cert, TlsCert: cert, TlsKey: key},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep.OnMessage(func(msg message.Message) {
+		msg.SendAck([]byte("DONE"))
+	})
+	if _, err := rep.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer rep.Close()
+
+	cases := []struct {
+		name     string
+		cert     string
+		key      string
+		expected string
+	}{
+		{"mutual", cert, key, "DONE"},
+		{"no-client-cert", "", "", ""},
+		{"untrusted-client-cert", otherCert, otherKey, ""},
+	}
+	for _, c := range cases {
+		client, _ := reqNano.New()
+		cfg := map[string]interface{}{
+			"name":     "client",
+			"topic":    "jobs",
+			"endpoint": endpoint,
+			"timeout":  float64(200),
+			"tlsCA":    cert,
+		}
+		if c.cert != "" {
+			cfg["tlsCert"], cfg["tlsKey"] = c.cert, c.key
+		}
+		if err := client.Connect([]interface{}{cfg}); err != nil {
+			t.Fatal(c.name, err)
+		}
+		reply, err := client.(publisher.Requester).Request(context.Background(), "", []byte("job"), 0)
+		client.Close()
+		switch c.name {
+		case "mutual":
+			if err != nil || string(reply) != c.expected {
+				t.Errorf("%s: expected %q, got %q %v", c.name, c.expected, reply, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("%s: request answered without a trusted client certificate", c.name)
+			}
+		}
+	}
+}
@@ -2,54 +2,71 @@ package reply
 
 import (
 	"errors"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/amagimedia/judo/v3/service"
 	nats "github.com/nats-io/go-nats"
 )
 
 var natsmap = map[string]string{
-	"name":     "Name",
-	"topic":    "Topic",
-	"endpoint": "Endpoint",
-	"user":     "User",
-	"password": "Password",
-	"token":    "Token",
+	"name":          "Name",
+	"topic":         "Topic",
+	"endpoint":      "Endpoint",
+	"user":          "User",
+	"password":      "Password",
+	"token":         "Token",
+	"maxReconnects": "MaxReconnects",
+	"reconnectWait": "ReconnectWait",
 }
 
-type natsConnector func(string) (jmsg.RawConnection, error)
+type natsConnector func(string, []nats.Option) (jmsg.RawConnection, error)
 
 type NatsReply struct {
 	connector  natsConnector
 	connection jmsg.RawConnection
-	msgQueue   <-chan *nats.Msg
-	natsConfig
-	callback func(jmsg.Message)
+	msgQueue   chan *nats.Msg
+	NatsConfig
+	callback     func(jmsg.Message)
+	runtime      service.Runtime
+	mu           sync.Mutex
+	errorChannel chan error
 }
 
-type natsConfig struct {
-	Name     string
-	Topic    string
-	Endpoint string
-	User     string
-	Password string
-	Token    string
+// MaxReconnects is left nil to keep the client default of 60 attempts; zero
+// disables reconnecting and -1 retries forever.
+type NatsConfig struct {
+	Name          string
+	Topic         string
+	Endpoint      string
+	User          string
+	Password      string
+	Token         string
+	MaxReconnects *int
+	ReconnectWait time.Duration
+	judoConfig.TLSConfig
 }
 
-func (c natsConfig) GetKeys() []string {
-	return []string{
+func (c NatsConfig) GetKeys() []string {
+	return append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"user",
 		"password",
 		"token",
-	}
+		"maxReconnects",
+		"reconnectWait",
+	}, judoConfig.TLSKeys...)
 }
 
-func (c natsConfig) GetMandatoryKeys() []string {
+func (c NatsConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -57,69 +74,178 @@ func (c natsConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c natsConfig) GetField(key string) string {
-	return natsmap[key]
+func (c NatsConfig) GetField(key string) string {
+	if field, ok := natsmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("nats", "reply", func(registry.Legs) (client.JudoClient, error) {
+		return NewNatsReply(), nil
+	})
 }
 
 func NewNatsReply() *NatsReply {
-	rep := &NatsReply{connector: natsConnect, msgQueue: make(<-chan *nats.Msg)}
+	rep := &NatsReply{connector: natsConnect, msgQueue: make(chan *nats.Msg)}
 	return rep
 }
 
 func (rep *NatsReply) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&rep.natsConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &rep.NatsConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
 
-	url := rep.natsConfig.Endpoint
-	if rep.natsConfig.User != "" && rep.natsConfig.Password != "" {
-		url = fmt.Sprintf("%s:%s@%s", rep.natsConfig.User, rep.natsConfig.Password, rep.natsConfig.Endpoint)
-	} else if rep.natsConfig.Token != "" {
-		url = fmt.Sprintf("%s@%s", rep.natsConfig.Token, rep.natsConfig.Endpoint)
+	rep.runtime.SetSecrets(rep.NatsConfig.Password, rep.NatsConfig.Token)
+	opts := rep.options()
+	// The client names every server of the cluster after its own URL.
+	tlsCfg, err := rep.NatsConfig.ClientTLS("")
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, nats.Secure(tlsCfg))
 	}
 
-	rep.connection, err = rep.connector(url)
+	rep.connection, err = rep.connector(rep.NatsConfig.Endpoint, opts)
+	err = rep.runtime.Redact(err)
 
 	return err
 }
 
 func (rep *NatsReply) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	rep.callback = callback
+	rep.callback = client.Chain(callback, rep.runtime.Middleware...)
 	return rep
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (rep *NatsReply) Use(mw ...client.Middleware) {
+	rep.runtime.Middleware = append(rep.runtime.Middleware, mw...)
+}
+
+// options hooks connection state changes into the status channel. The
+// client resubscribes on its own after reconnecting, so only a connection
+// that is closed for good is reported as an error. Credentials are passed
+// as options rather than in the URL.
+func (rep *NatsReply) options() []nats.Option {
+	opts := []nats.Option{
+		nats.DisconnectHandler(func(*nats.Conn) {
+			rep.runtime.Notify(client.Status{State: client.Disconnected})
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			rep.runtime.Notify(client.Status{State: client.Connected})
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			rep.disconnected()
+		}),
+	}
+	if rep.NatsConfig.MaxReconnects != nil {
+		opts = append(opts, nats.MaxReconnects(*rep.NatsConfig.MaxReconnects))
+	}
+	if rep.NatsConfig.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(rep.NatsConfig.ReconnectWait))
+	}
+	if rep.NatsConfig.User != "" && rep.NatsConfig.Password != "" {
+		opts = append(opts, nats.UserInfo(rep.NatsConfig.User, rep.NatsConfig.Password))
+	} else if rep.NatsConfig.Token != "" {
+		opts = append(opts, nats.Token(rep.NatsConfig.Token))
+	}
+	return opts
+}
+
+// Status reports connection loss and recovery.
+func (rep *NatsReply) Status() <-chan client.Status {
+	return rep.runtime.Status()
+}
+
 func (rep *NatsReply) Start() (<-chan error, error) {
 
 	errorChannel := make(chan error)
+	if rep.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
-	_, err := rep.connection.ChanSubscribe(rep.natsConfig.Topic, rep.msgQueue)
+	_, err := rep.connection.ChanSubscribe(rep.NatsConfig.Topic, rep.msgQueue)
 	if err != nil {
-		return errorChannel, err
+		return errorChannel, rep.runtime.Redact(err)
 	}
 
+	rep.mu.Lock()
+	rep.errorChannel = errorChannel
+	rep.mu.Unlock()
+
+	rep.runtime.Labels = metrics.Labels{Protocol: "nats", Topic: rep.NatsConfig.Topic}
+	rep.runtime.Open()
 	go rep.receive(errorChannel)
 
 	return errorChannel, err
 }
 
-func (rep *NatsReply) Close() {
-	rep.connection.Close()
+// Close waits for the callbacks in flight and then closes the connection.
+func (rep *NatsReply) Close() error {
+	rep.runtime.Shutdown()
+	if rep.connection != nil {
+		rep.connection.Close()
+	}
+	return nil
 }
 
 func (rep *NatsReply) receive(ec chan error) {
-	for msg := range rep.msgQueue {
-		message := jmsg.NatsMessage{jmsg.NatsRawMessage{msg}, rep.connection, make(map[string]string)}
-		rep.callback(message)
+	for {
+		var msg *nats.Msg
+		var ok bool
+		select {
+		case msg, ok = <-rep.msgQueue:
+		case <-rep.runtime.Done():
+			return
+		}
+		if !ok {
+			rep.disconnected()
+			return
+		}
+		env := jmsg.Decode(msg.Data)
+		msg.Data = env.Body
+		message := jmsg.NatsMessage{
+			RawMessage: jmsg.NatsRawMessage{Msg: msg},
+			Responder:  rep.connection,
+			Properties: make(map[string]string),
+			Header:     env.Headers,
+			Settlement: &jmsg.Settlement{},
+		}
+		jmsg.SetEnvelopeProperties(message, env)
+		rep.runtime.Received()
+		if !rep.runtime.Deliver("", message, message.Settlement, rep.runtime.Recover(ec, rep.callback)) {
+			return
+		}
 	}
-	ec <- errors.New("Disconnected from nats server for " + rep.natsConfig.Name)
 }
 
-func natsConnect(url string) (jmsg.RawConnection, error) {
-	nc, err := nats.Connect("nats://" + url)
-	return &jmsg.NatsRawConnection{*nc}, err
+func (rep *NatsReply) disconnected() {
+	rep.mu.Lock()
+	ec := rep.errorChannel
+	rep.mu.Unlock()
+	if ec != nil {
+		rep.runtime.Report(ec, errors.New("Disconnected from nats server for "+rep.NatsConfig.Name))
+	}
+}
+
+func natsConnect(url string, opts []nats.Option) (jmsg.RawConnection, error) {
+	nc, err := nats.Connect("nats://"+url, opts...)
+	return &jmsg.NatsRawConnection{Conn: nc}, err
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the replier in m.
+func (rep *NatsReply) SetMetrics(m metrics.Recorder) {
+	rep.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the replier to l.
+func (rep *NatsReply) SetLogger(l logger.Logger) {
+	rep.runtime.Logger = l
 }
@@ -14,11 +14,11 @@ import (
 func TestNatsReply(t *testing.T) {
 	fakeConn := &mocks.RawConnection{}
 
-	connector := func(url string) (message.RawConnection, error) {
+	connector := func(url string, opts []nats.Option) (message.RawConnection, error) {
 		return fakeConn, nil
 	}
 
-	_ = func(url string) (message.RawConnection, error) {
+	_ = func(url string, opts []nats.Option) (message.RawConnection, error) {
 		return fakeConn, errors.New("Cannot Create connection, Server not found")
 	}
 
@@ -142,6 +142,7 @@ func TestNatsReply(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err != nil {
 				t.Error("UnExpected Error")
@@ -153,14 +154,14 @@ func TestNatsReply(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, c.retType).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("UnExpected Type of Error")
 			}
 		case "err-conn":
 			ch := make(chan *nats.Msg)
-			rcv := func() <-chan *nats.Msg { return ch }
-			fakeSubscriber = &NatsReply{connector: connector, msgQueue: rcv()}
+			fakeSubscriber = &NatsReply{connector: connector, msgQueue: ch}
 			called := false
 			fakeSubscriber.OnMessage(func(message.Message) {
 				called = true
@@ -0,0 +1,303 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/google/uuid"
+	gamqp "github.com/streadway/amqp"
+)
+
+// directReplyTo is the RabbitMQ pseudo queue used when directReplyTo is set.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+var ErrTimeout = errors.New("Request timed out waiting for reply")
+
+type Config struct {
+	User          string
+	Password      string
+	Host          string
+	Port          string
+	ExchangeName  string
+	RoutingKey    string
+	ContentType   string
+	DirectReplyTo bool
+	Timeout       time.Duration
+	judoConfig.TLSConfig
+}
+
+var amqpmap = map[string]string{
+	"user":          "User",
+	"password":      "Password",
+	"host":          "Host",
+	"port":          "Port",
+	"exchangeName":  "ExchangeName",
+	"routingKey":    "RoutingKey",
+	"contentType":   "ContentType",
+	"directReplyTo": "DirectReplyTo",
+	"timeout":       "Timeout",
+}
+
+func (c *Config) GetKeys() []string {
+	return append([]string{
+		"user",
+		"password",
+		"host",
+		"port",
+		"exchangeName",
+		"routingKey",
+		"contentType",
+		"directReplyTo",
+		"timeout",
+	}, judoConfig.TLSKeys...)
+}
+
+func (c *Config) GetMandatoryKeys() []string {
+	return []string{
+		"user",
+		"password",
+		"host",
+		"port",
+		"timeout",
+	}
+}
+
+func (c *Config) GetField(key string) string {
+	if field, ok := amqpmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+type amqpConnector func(*Config) (jmsg.RawChannel, error)
+
+// amqpReq is the client side of reply.AmqpReply. Replies arrive on a single
+// callback queue and are matched to their callers by correlation id, so any
+// number of requests can be in flight at once.
+type amqpReq struct {
+	connector amqpConnector
+	channel   jmsg.RawChannel
+	config    *Config
+	replyTo   string
+	mu        sync.Mutex
+	pending   map[string]chan gamqp.Delivery
+	closed    bool
+}
+
+func (req *amqpReq) Connect(configs []interface{}) error {
+
+	config := &Config{ContentType: "text/plain"}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+
+	err := cfgHelper.Load(configs[0])
+	if err != nil {
+		return err
+	}
+
+	req.channel, err = req.connector(config)
+	if err != nil {
+		return err
+	}
+	req.config = config
+
+	return req.setup(config)
+}
+
+// setup creates the callback queue and starts consuming replies. With
+// directReplyTo the RabbitMQ direct reply-to pseudo queue is used instead of
+// an exclusive server named queue.
+func (req *amqpReq) setup(c *Config) error {
+
+	req.replyTo = directReplyTo
+	if !c.DirectReplyTo {
+		queue, err := req.channel.QueueDeclare(
+			"",    // server named
+			false, // durable
+			true,  // autoDelete
+			true,  // exclusive
+			false, // noWait
+			gamqp.Table(nil),
+		)
+		if err != nil {
+			return err
+		}
+		req.replyTo = queue.Name
+	}
+
+	replies, err := req.channel.Consume(
+		req.replyTo,
+		"",    // consumer
+		true,  // autoAck
+		true,  // exclusive
+		false, // noLocal
+		false, // noWait
+		gamqp.Table(nil),
+	)
+	if err != nil {
+		return err
+	}
+
+	req.mu.Lock()
+	req.pending = make(map[string]chan gamqp.Delivery)
+	req.closed = false
+	req.mu.Unlock()
+
+	go req.dispatch(replies)
+
+	return nil
+}
+
+func (req *amqpReq) dispatch(replies <-chan gamqp.Delivery) {
+	for reply := range replies {
+		req.mu.Lock()
+		waiter, ok := req.pending[reply.CorrelationId]
+		delete(req.pending, reply.CorrelationId)
+		req.mu.Unlock()
+		if ok {
+			waiter <- reply
+		}
+	}
+
+	// The channel is gone, release everyone still waiting.
+	req.mu.Lock()
+	req.closed = true
+	for id, waiter := range req.pending {
+		close(waiter)
+		delete(req.pending, id)
+	}
+	req.mu.Unlock()
+}
+
+// Request publishes msg with a fresh correlation id and waits for the
+// matching reply. The subject is used as the routing key and falls back to
+// the configured routingKey when empty.
+func (req *amqpReq) Request(ctx context.Context, subject string, msg []byte, timeout time.Duration) ([]byte, error) {
+	return req.request(ctx, subject, msg, timeout, nil)
+}
+
+func (req *amqpReq) request(ctx context.Context, subject string, msg []byte, timeout time.Duration, headers gamqp.Table) ([]byte, error) {
+	if req.channel == nil {
+		return nil, errors.New("Unable to send request, not connected to server.")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := subject
+	if key == "" {
+		key = req.config.RoutingKey
+	}
+
+	id := uuid.New().String()
+	waiter := make(chan gamqp.Delivery, 1)
+
+	req.mu.Lock()
+	if req.closed {
+		req.mu.Unlock()
+		return nil, errors.New("Reply consumer closed, unable to send request.")
+	}
+	req.pending[id] = waiter
+	req.mu.Unlock()
+
+	err := req.channel.Publish(
+		req.config.ExchangeName,
+		key,
+		false,
+		false,
+		gamqp.Publishing{
+			ContentType:   req.config.ContentType,
+			CorrelationId: id,
+			ReplyTo:       req.replyTo,
+			Headers:       headers,
+			Body:          msg,
+		},
+	)
+	if err != nil {
+		req.forget(id)
+		return nil, err
+	}
+
+	// The deadline of ctx is honoured by the select below.
+	wait := publisher.RequestTimeout(context.Background(), timeout, req.config.Timeout)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case reply, ok := <-waiter:
+		if !ok {
+			return nil, errors.New("Reply consumer closed while waiting for reply.")
+		}
+		if nack, _ := reply.Headers[jmsg.NackHeader].(bool); nack {
+			return nil, &publisher.NackError{Reply: reply.Body}
+		}
+		return reply.Body, nil
+	case <-ctx.Done():
+		req.forget(id)
+		return nil, ctx.Err()
+	case <-timer.C:
+		req.forget(id)
+		return nil, ErrTimeout
+	}
+}
+
+func (req *amqpReq) forget(id string) {
+	req.mu.Lock()
+	delete(req.pending, id)
+	req.mu.Unlock()
+}
+
+// Publish sends msg as a request and succeeds on any reply other than a nack.
+func (req *amqpReq) Publish(subject string, msg []byte) error {
+	_, err := req.Request(context.Background(), subject, msg, 0)
+	return err
+}
+
+// PublishContext is Publish, abandoned once ctx is done.
+func (req *amqpReq) PublishContext(ctx context.Context, subject string, msg []byte) error {
+	_, err := req.Request(ctx, subject, msg, 0)
+	return err
+}
+
+// PublishWithHeaders sends msg as a request carrying headers as AMQP headers.
+func (req *amqpReq) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	table := gamqp.Table{}
+	for key, val := range headers {
+		table[key] = val
+	}
+	_, err := req.request(context.Background(), subject, msg, 0, table)
+	return err
+}
+
+func (req *amqpReq) Close() error {
+	if req.channel == nil {
+		return nil
+	}
+	return req.channel.Close()
+}
+
+func amqpConnect(cfg *Config) (jmsg.RawChannel, error) {
+
+	tlsCfg, err := cfg.ClientTLS(cfg.Host)
+	if err != nil {
+		return jmsg.AmqpRawChannel{}, err
+	}
+	return jmsg.DialAmqp(cfg.User, cfg.Password, cfg.Host, cfg.Port, tlsCfg)
+}
+
+func init() {
+	registry.RegisterPublisher("amqp", "req", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
+var _ publisher.Requester = (*amqpReq)(nil)
+
+func New() (publisher.JudoPub, error) {
+	return &amqpReq{connector: amqpConnect}, nil
+}
@@ -0,0 +1,124 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	"github.com/amagimedia/judo/v3/publisher"
+	gamqp "github.com/streadway/amqp"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAmqpRequest(t *testing.T) {
+	config := []interface{}{
+		map[string]interface{}{
+			"user":       "guest",
+			"password":   "guest",
+			"host":       "localhost",
+			"port":       "5672",
+			"routingKey": "rpc_queue",
+			"timeout":    float64(500),
+		},
+	}
+
+	fakeChannel := &mocks.RawChannel{}
+	replies := make(chan gamqp.Delivery)
+	req := &amqpReq{connector: func(*Config) (jmsg.RawChannel, error) { return fakeChannel, nil }}
+
+	fakeChannel.On("QueueDeclare", "", false, true, true, false, gamqp.Table(nil)).Return(gamqp.Queue{Name: "amq.gen-cb"}, nil).Once()
+	fakeChannel.On("Consume", "amq.gen-cb", "", true, true, false, false, gamqp.Table(nil)).Return((<-chan gamqp.Delivery)(replies), nil).Once()
+	if err := req.Connect(config); err != nil {
+		t.Fatal("Unable to connect", err.Error())
+	}
+
+	// Replies are sent back in reverse order to check that each caller gets
+	// the reply carrying its own correlation id.
+	var mu sync.Mutex
+	published := make([]gamqp.Publishing, 0)
+	fakeChannel.On("Publish", "", "rpc_queue", false, false, mock.AnythingOfType("amqp.Publishing")).Return(nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := args.Get(4).(gamqp.Publishing)
+		if p.ReplyTo != "amq.gen-cb" || p.CorrelationId == "" {
+			t.Error("Request published without reply-to or correlation id")
+		}
+		published = append(published, p)
+		if len(published) == 2 {
+			go func(ps []gamqp.Publishing) {
+				for i := len(ps) - 1; i >= 0; i-- {
+					replies <- gamqp.Delivery{CorrelationId: ps[i].CorrelationId, Body: append([]byte("re:"), ps[i].Body...)}
+				}
+			}(append([]gamqp.Publishing{}, published...))
+		}
+	}).Twice()
+
+	var wg sync.WaitGroup
+	for _, body := range []string{"one", "two"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			reply, err := req.Request(context.Background(), "", []byte(body), 0)
+			if err != nil {
+				t.Error("Request failed", err.Error())
+				return
+			}
+			if string(reply) != "re:"+body {
+				t.Errorf("Reply %s matched to request %s", reply, body)
+			}
+		}(body)
+	}
+	wg.Wait()
+
+	fakeChannel.On("Publish", "", "rpc_queue", false, false, mock.AnythingOfType("amqp.Publishing")).Return(nil).Run(func(args mock.Arguments) {
+		p := args.Get(4).(gamqp.Publishing)
+		go func() {
+			replies <- gamqp.Delivery{CorrelationId: p.CorrelationId, Headers: gamqp.Table{jmsg.NackHeader: true}, Body: []byte("ERR")}
+		}()
+	}).Once()
+	_, err := req.Request(context.Background(), "", []byte("nacked"), 0)
+	var nack *publisher.NackError
+	if !errors.As(err, &nack) || string(nack.Reply) != "ERR" {
+		t.Error("Nack reply not reported as NackError", err)
+	}
+
+	fakeChannel.On("Publish", "", "rpc_queue", false, false, mock.AnythingOfType("amqp.Publishing")).Return(nil).Twice()
+	if _, err := req.Request(context.Background(), "", []byte("lost"), 20*time.Millisecond); err != ErrTimeout {
+		t.Error("Timeout not reported", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := req.Request(ctx, "", []byte("lost"), 0); err != context.DeadlineExceeded {
+		t.Error("Context deadline not honoured", err)
+	}
+
+	close(replies)
+	fakeChannel.On("Publish", "", "rpc_queue", false, false, mock.AnythingOfType("amqp.Publishing")).Return(nil).Maybe()
+	if _, err := req.Request(context.Background(), "", []byte("closed"), 0); err == nil {
+		t.Error("Request succeeded after reply consumer closed")
+	}
+}
+
+func TestAmqpDirectReplyTo(t *testing.T) {
+	fakeChannel := &mocks.RawChannel{}
+	req := &amqpReq{connector: func(*Config) (jmsg.RawChannel, error) { return fakeChannel, nil }}
+	fakeChannel.On("Consume", directReplyTo, "", true, true, false, false, gamqp.Table(nil)).Return(make(<-chan gamqp.Delivery), nil).Once()
+	err := req.Connect([]interface{}{
+		map[string]interface{}{
+			"user":          "guest",
+			"password":      "guest",
+			"host":          "localhost",
+			"port":          "5672",
+			"directReplyTo": true,
+			"timeout":       float64(500),
+		},
+	})
+	if err != nil {
+		t.Error("Unable to connect", err.Error())
+	}
+	fakeChannel.AssertExpectations(t)
+}
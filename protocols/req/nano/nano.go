@@ -1,14 +1,17 @@
 package nano
 
 import (
-	"fmt"
+	"context"
+	"sync"
 	"time"
 
 	judoConfig "github.com/amagimedia/judo/v3/config"
 	jmsg "github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/registry"
 	greq "github.com/go-mangos/mangos/protocol/req"
 	"github.com/go-mangos/mangos/transport/ipc"
+	"github.com/go-mangos/mangos/transport/tlstcp"
 	gomangos "nanomsg.org/go-mangos"
 )
 
@@ -17,7 +20,8 @@ type Config struct {
 	Topic     string
 	Endpoint  string
 	Separator string
-	Timeout   float64
+	Timeout   time.Duration
+	judoConfig.TLSConfig
 }
 
 var nanomap = map[string]string{
@@ -29,13 +33,13 @@ var nanomap = map[string]string{
 }
 
 func (c *Config) GetKeys() []string {
-	return []string{
+	return append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"timeout",
 		"separator",
-	}
+	}, judoConfig.TLSKeys...)
 }
 
 func (c *Config) GetMandatoryKeys() []string {
@@ -43,19 +47,24 @@ func (c *Config) GetMandatoryKeys() []string {
 }
 
 func (c *Config) GetField(key string) string {
-	return nanomap[key]
+	if field, ok := nanomap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
 }
 
 type nanoReq struct {
 	Socket jmsg.RawSocket
+	config *Config
+	mu     sync.Mutex
 }
 
 func (req *nanoReq) Connect(configs []interface{}) error {
 
 	config := &Config{}
-	cfgHelper := judoConfig.ConfigHelper{config}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
 
-	err := cfgHelper.ValidateAndSet(configs[0].(map[string]interface{}))
+	err := cfgHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
@@ -66,42 +75,117 @@ func (req *nanoReq) Connect(configs []interface{}) error {
 	}
 
 	req.Socket.AddTransport(ipc.NewTransport())
-	err = req.Socket.Dial(config.Endpoint)
+	req.Socket.AddTransport(tlstcp.NewTransport())
+	tlsCfg, err := config.ClientTLS(config.Endpoint)
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		err = req.Socket.DialOptions(config.Endpoint, map[string]interface{}{gomangos.OptionTLSConfig: tlsCfg})
+	} else {
+		err = req.Socket.Dial(config.Endpoint)
+	}
 	if err != nil {
 		return err
 	}
 
-	err = req.Socket.SetOption(gomangos.OptionRecvDeadline, time.Duration(config.Timeout)*time.Millisecond)
+	err = req.Socket.SetOption(gomangos.OptionRecvDeadline, config.Timeout)
 	if err != nil {
 		return err
 	}
+	req.config = config
 
 	return nil
 }
 
-func (req *nanoReq) Publish(_ string, msg []byte) error {
-	err := req.Socket.Send(msg)
-	if err != nil {
-		return err
+// Request sends msg and waits for the reply. A REQ socket carries one request
+// at a time, so concurrent calls are serialised. Cancelling ctx returns at
+// once, but the next request still waits for the abandoned one to time out.
+func (req *nanoReq) Request(ctx context.Context, _ string, msg []byte, timeout time.Duration) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	wait := publisher.RequestTimeout(ctx, timeout, req.config.Timeout)
+	replies := make(chan []byte, 1)
+	errs := make(chan error, 1)
+	go func() {
+		rmsg, err := req.roundTrip(msg, wait)
+		if err != nil {
+			errs <- err
+			return
+		}
+		replies <- rmsg
+	}()
+
+	select {
+	case rmsg := <-replies:
+		if body, nack := jmsg.ParseReply(rmsg); nack {
+			return nil, &publisher.NackError{Reply: body}
+		}
+		return rmsg, nil
+	case err := <-errs:
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
+
+func (req *nanoReq) roundTrip(msg []byte, wait time.Duration) ([]byte, error) {
+	req.mu.Lock()
+	defer req.mu.Unlock()
 
-	rmsg, err := req.Socket.Recv()
+	err := req.Socket.SetOption(gomangos.OptionRecvDeadline, wait)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if string(rmsg) != "OK" {
-		return fmt.Errorf("Invalid ack. Please send 'OK'")
+	err = req.Socket.Send(msg)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return req.Socket.Recv()
+}
+
+// Publish sends msg and succeeds on any reply other than a nack.
+func (req *nanoReq) Publish(subject string, msg []byte) error {
+	_, err := req.Request(context.Background(), subject, msg, 0)
+	return err
+}
 
+// PublishContext is Publish, abandoned once ctx is done.
+func (req *nanoReq) PublishContext(ctx context.Context, subject string, msg []byte) error {
+	_, err := req.Request(ctx, subject, msg, 0)
+	return err
+}
+
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (req *nanoReq) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return req.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return req.Publish(subject, jmsg.Encode(env))
 }
 
 func (req *nanoReq) Close() error {
 	return req.Socket.Close()
 }
 
+func init() {
+	registry.RegisterPublisher("nano", "req", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
+var _ publisher.Requester = (*nanoReq)(nil)
+
 func New() (publisher.JudoPub, error) {
 	return &nanoReq{}, nil
 }
@@ -0,0 +1,74 @@
+package nano
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	"github.com/amagimedia/judo/v3/publisher"
+	gomangos "nanomsg.org/go-mangos"
+)
+
+func TestNanoRequest(t *testing.T) {
+	cases := []struct {
+		name  string
+		reply []byte
+		err   error
+	}{
+		{"ack", []byte("OK"), nil},
+		{"custom-reply", []byte("DONE"), nil},
+		{"nack", jmsg.NackReply([]byte("ERR")), nil},
+		{"plain-err", []byte("ERR"), nil},
+		{"recv-err", nil, errors.New("Receive timed out")},
+	}
+
+	for _, c := range cases {
+		fakeSocket := &mocks.RawSocket{}
+		req := &nanoReq{Socket: fakeSocket, config: &Config{Timeout: 100 * time.Millisecond}}
+
+		fakeSocket.On("SetOption", gomangos.OptionRecvDeadline, 100*time.Millisecond).Return(nil).Once()
+		fakeSocket.On("Send", []byte("MSG")).Return(nil).Once()
+		fakeSocket.On("Recv").Return(c.reply, c.err).Once()
+
+		body, err := req.Request(context.Background(), "", []byte("MSG"), 0)
+		var nack *publisher.NackError
+		switch c.name {
+		case "ack", "custom-reply", "plain-err":
+			if err != nil || string(body) != string(c.reply) {
+				t.Error("Reply body not returned", err)
+			}
+		case "nack":
+			if !errors.As(err, &nack) || string(nack.Reply) != "ERR" {
+				t.Error("Nack reply not reported as NackError", err)
+			}
+		case "recv-err":
+			if err == nil || errors.As(err, &nack) {
+				t.Error("Transport error not reported", err)
+			}
+		}
+		fakeSocket.AssertExpectations(t)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := &nanoReq{Socket: &mocks.RawSocket{}, config: &Config{Timeout: 100 * time.Millisecond}}
+	if _, err := req.Request(ctx, "", []byte("MSG"), 0); err != context.Canceled {
+		t.Error("Cancelled context not honoured", err)
+	}
+
+	// Cancelling aborts a request in progress.
+	fakeSocket := &mocks.RawSocket{}
+	req = &nanoReq{Socket: fakeSocket, config: &Config{Timeout: time.Second}}
+	fakeSocket.On("SetOption", gomangos.OptionRecvDeadline, time.Second).Return(nil).Once()
+	fakeSocket.On("Send", []byte("SLOW")).Return(nil).Once()
+	fakeSocket.On("Recv").Return([]byte("OK"), nil).After(time.Second).Once()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := req.Request(ctx, "", []byte("SLOW"), 0); err != context.Canceled || time.Since(start) > 500*time.Millisecond {
+		t.Error("Request in progress not aborted", err)
+	}
+}
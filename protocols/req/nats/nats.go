@@ -0,0 +1,197 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
+	gnats "github.com/nats-io/go-nats"
+)
+
+type Config struct {
+	Name     string
+	Topic    string
+	Endpoint string
+	User     string
+	Password string
+	Token    string
+	Timeout  time.Duration
+	judoConfig.TLSConfig
+}
+
+var natsmap = map[string]string{
+	"name":     "Name",
+	"topic":    "Topic",
+	"endpoint": "Endpoint",
+	"user":     "User",
+	"password": "Password",
+	"token":    "Token",
+	"timeout":  "Timeout",
+}
+
+func (c *Config) GetKeys() []string {
+	return append([]string{
+		"name",
+		"topic",
+		"endpoint",
+		"user",
+		"password",
+		"token",
+		"timeout",
+	}, judoConfig.TLSKeys...)
+}
+
+func (c *Config) GetMandatoryKeys() []string {
+	return []string{"name", "topic", "endpoint", "timeout"}
+}
+
+func (c *Config) GetField(key string) string {
+	if field, ok := natsmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+type natsConnector func(*Config) (jmsg.RawConnection, error)
+
+type natsReq struct {
+	connector  natsConnector
+	connection jmsg.RawConnection
+	config     *Config
+}
+
+func (req *natsReq) Connect(configs []interface{}) error {
+
+	config := &Config{}
+	cfgHelper := judoConfig.ConfigHelper{Config: config}
+
+	err := cfgHelper.Load(configs[0])
+	if err != nil {
+		return err
+	}
+
+	req.connection, err = req.connector(config)
+	if err != nil {
+		return err
+	}
+	req.config = config
+
+	return nil
+}
+
+// Request sends msg to subject, or to the configured topic when subject is
+// empty, and returns the body the replier passed to SendAck. Cancelling ctx
+// abandons the request; a late reply is discarded.
+func (req *natsReq) Request(ctx context.Context, subject string, msg []byte, timeout time.Duration) ([]byte, error) {
+	if req.connection == nil {
+		return nil, errors.New("Unable to send request, not connected to server.")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		subject = req.config.Topic
+	}
+
+	wait := publisher.RequestTimeout(ctx, timeout, req.config.Timeout)
+	replies := make(chan *gnats.Msg, 1)
+	errs := make(chan error, 1)
+	go func() {
+		reply, err := req.connection.Request(subject, msg, wait)
+		if err != nil {
+			errs <- err
+			return
+		}
+		replies <- reply
+	}()
+
+	select {
+	case reply := <-replies:
+		if body, nack := jmsg.ParseReply(reply.Data); nack {
+			return nil, &publisher.NackError{Reply: body}
+		}
+		return reply.Data, nil
+	case err := <-errs:
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Publish sends msg as a request and succeeds on any reply other than a nack.
+func (req *natsReq) Publish(subject string, msg []byte) error {
+	_, err := req.Request(context.Background(), subject, msg, 0)
+	return err
+}
+
+// PublishContext is Publish, abandoned once ctx is done.
+func (req *natsReq) PublishContext(ctx context.Context, subject string, msg []byte) error {
+	_, err := req.Request(ctx, subject, msg, 0)
+	return err
+}
+
+// PublishWithHeaders wraps msg and headers in an envelope. Without headers
+// msg is published as is.
+func (req *natsReq) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	if len(headers) == 0 {
+		return req.Publish(subject, msg)
+	}
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	return req.Publish(subject, jmsg.Encode(env))
+}
+
+func (req *natsReq) Close() error {
+	if req.connection != nil {
+		req.connection.Close()
+	}
+	return nil
+}
+
+func natsConnect(cfg *Config) (jmsg.RawConnection, error) {
+	url := cfg.Endpoint
+	if !strings.Contains(url, "://") {
+		url = "nats://" + url
+	}
+
+	opts := []gnats.Option{gnats.Name(cfg.Name)}
+	if cfg.User != "" && cfg.Password != "" {
+		opts = append(opts, gnats.UserInfo(cfg.User, cfg.Password))
+	} else if cfg.Token != "" {
+		opts = append(opts, gnats.Token(cfg.Token))
+	}
+	tlsCfg, err := cfg.ClientTLS("")
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, gnats.Secure(tlsCfg))
+	}
+
+	connection, err := gnats.Connect(url, opts...)
+	if err != nil {
+		return nil, redact.Error(err, cfg.Password, cfg.Token)
+	}
+	return &jmsg.NatsRawConnection{Conn: connection}, nil
+}
+
+func init() {
+	registry.RegisterPublisher("nats", "req", func(registry.Legs) (publisher.JudoPub, error) {
+		return New()
+	})
+}
+
+var _ publisher.Requester = (*natsReq)(nil)
+
+func New() (publisher.JudoPub, error) {
+	return &natsReq{connector: natsConnect}, nil
+}
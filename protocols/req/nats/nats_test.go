@@ -0,0 +1,115 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	"github.com/amagimedia/judo/v3/publisher"
+	gnats "github.com/nats-io/go-nats"
+)
+
+func TestNatsRequest(t *testing.T) {
+	config := []interface{}{
+		map[string]interface{}{
+			"name":     "requester",
+			"topic":    "rpc.in",
+			"endpoint": "localhost:4222",
+			"timeout":  float64(200),
+		},
+	}
+
+	cases := []struct {
+		name    string
+		reply   *gnats.Msg
+		err     error
+		subject string
+	}{
+		{"ack", &gnats.Msg{Data: []byte("OK")}, nil, ""},
+		{"custom-reply", &gnats.Msg{Data: []byte("DONE")}, nil, "rpc.other"},
+		{"nack", &gnats.Msg{Data: jmsg.NackReply([]byte("NOK"))}, nil, ""},
+		{"plain-nok", &gnats.Msg{Data: []byte("NOK")}, nil, ""},
+		{"timeout", nil, gnats.ErrTimeout, ""},
+	}
+
+	for _, c := range cases {
+		fakeConn := &mocks.RawConnection{}
+		req := &natsReq{connector: func(*Config) (jmsg.RawConnection, error) { return fakeConn, nil }}
+		if err := req.Connect(config); err != nil {
+			t.Error("Unable to connect", err.Error())
+		}
+
+		expectedSubject := c.subject
+		if expectedSubject == "" {
+			expectedSubject = "rpc.in"
+		}
+		fakeConn.On("Request", expectedSubject, []byte("MSG"), 200*time.Millisecond).Return(c.reply, c.err).Twice()
+
+		body, err := req.Request(context.Background(), c.subject, []byte("MSG"), 0)
+		switch c.name {
+		case "ack", "custom-reply", "plain-nok":
+			if err != nil || string(body) != string(c.reply.Data) {
+				t.Error("Reply body not returned", err)
+			}
+		case "nack":
+			var nack *publisher.NackError
+			if !errors.As(err, &nack) || string(nack.Reply) != "NOK" {
+				t.Error("Nack reply not reported as NackError", err)
+			}
+		case "timeout":
+			if err != gnats.ErrTimeout {
+				t.Error("Timeout not reported", err)
+			}
+		}
+
+		err = req.Publish(c.subject, []byte("MSG"))
+		if (c.name == "ack" || c.name == "custom-reply" || c.name == "plain-nok") && err != nil {
+			t.Error("Publish failed for ack reply", err.Error())
+		}
+		if (c.name == "nack" || c.name == "timeout") && err == nil {
+			t.Error("Publish accepted a failed request")
+		}
+		fakeConn.AssertExpectations(t)
+	}
+
+	errReq := &natsReq{connector: func(*Config) (jmsg.RawConnection, error) {
+		return nil, errors.New("Cannot Create connection, Server not found")
+	}}
+	if err := errReq.Connect(config); err == nil {
+		t.Error("Did not throw error correctly")
+	}
+	if _, err := errReq.Request(context.Background(), "", []byte("MSG"), 0); err == nil {
+		t.Error("Request on unconnected client did not fail")
+	}
+}
+
+func TestNatsRequestTimeout(t *testing.T) {
+	fakeConn := &mocks.RawConnection{}
+	req := &natsReq{
+		connection: fakeConn,
+		config:     &Config{Topic: "rpc.in", Timeout: time.Second},
+	}
+
+	fakeConn.On("Request", "rpc.in", []byte("MSG"), 50*time.Millisecond).Return(&gnats.Msg{Data: []byte("OK")}, nil).Once()
+	if _, err := req.Request(context.Background(), "", []byte("MSG"), 50*time.Millisecond); err != nil {
+		t.Error("Request with explicit timeout failed", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := req.Request(ctx, "", []byte("MSG"), 0); err != context.Canceled {
+		t.Error("Cancelled context not honoured", err)
+	}
+
+	// Cancelling aborts a request in progress.
+	fakeConn.On("Request", "rpc.in", []byte("SLOW"), time.Second).Return(&gnats.Msg{Data: []byte("OK")}, nil).After(time.Second).Once()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := req.Request(ctx, "", []byte("SLOW"), 0); err != context.Canceled || time.Since(start) > 500*time.Millisecond {
+		t.Error("Request in progress not aborted", err)
+	}
+}
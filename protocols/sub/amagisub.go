@@ -1,88 +1,217 @@
 package sub
 
 import (
-	"sync"
+	"errors"
 
 	"github.com/amagimedia/judo/v3/client"
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/amagimedia/judo/v3/service"
 )
 
 type AmagiSubscriber struct {
 	primarySubscriber client.JudoClient
 	backupSubscriber  client.JudoClient
+	runtime           service.Runtime
 }
 
-func NewAmagiSub(primarySubProtocol, backupSubProtocol string) *AmagiSubscriber {
+func init() {
+	registry.RegisterSubscriber("amagi", "sub", func(legs registry.Legs) (client.JudoClient, error) {
+		subs, err := NewAmagiSub(legs.Primary, legs.Backup)
+		if err != nil {
+			return nil, err
+		}
+		return subs, nil
+	})
+}
+
+// NewAmagiSub subscribes through the primary protocol and, unless it is
+// empty, the backup protocol, as the amagi publisher does.
+func NewAmagiSub(primarySubProtocol, backupSubProtocol string) (*AmagiSubscriber, error) {
+	if primarySubProtocol == "" {
+		return nil, errors.New("Invalid Parameters, primary protocol missing")
+	}
+	var err error
 	subs := &AmagiSubscriber{}
-	subs.primarySubscriber = newSub(primarySubProtocol)
-	subs.backupSubscriber = newSub(backupSubProtocol)
-	return subs
+	subs.primarySubscriber, err = registry.NewSubscriber(primarySubProtocol, "sub", registry.Legs{})
+	if err != nil {
+		return nil, err
+	}
+	if backupSubProtocol == "" {
+		return subs, nil
+	}
+	subs.backupSubscriber, err = registry.NewSubscriber(backupSubProtocol, "sub", registry.Legs{})
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// legs returns the legs in use, the primary first.
+func (subs *AmagiSubscriber) legs() []client.JudoClient {
+	if subs.backupSubscriber == nil {
+		return []client.JudoClient{subs.primarySubscriber}
+	}
+	return []client.JudoClient{subs.primarySubscriber, subs.backupSubscriber}
+}
+
+// deduplicated is implemented by subscribers that can share a Deduplicator.
+type deduplicated interface {
+	SetDeduplicator(service.Deduplicator)
+}
+
+// retrying is implemented by subscribers that accept a retry policy.
+type retrying interface {
+	SetRetryPolicy(*service.RetryPolicy)
+}
+
+// SetRetryPolicy passes p on to the legs that support retries.
+func (subs *AmagiSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	for _, leg := range subs.legs() {
+		if r, ok := leg.(retrying); ok {
+			r.SetRetryPolicy(p)
+		}
+	}
+}
+
+// instrumented is implemented by subscribers that record metrics.
+type instrumented interface {
+	SetMetrics(metrics.Recorder)
+}
+
+// SetMetrics passes m on to the legs that record metrics. Each leg labels
+// its metrics with its own protocol and topic.
+func (subs *AmagiSubscriber) SetMetrics(m metrics.Recorder) {
+	for _, leg := range subs.legs() {
+		if i, ok := leg.(instrumented); ok {
+			i.SetMetrics(m)
+		}
+	}
 }
 
+// logging is implemented by subscribers that accept a logger.
+type logging interface {
+	SetLogger(logger.Logger)
+}
+
+// SetLogger passes l on to the legs that accept a logger. Each leg logs with
+// its own protocol and topic.
+func (subs *AmagiSubscriber) SetLogger(l logger.Logger) {
+	for _, leg := range subs.legs() {
+		if lg, ok := leg.(logging); ok {
+			lg.SetLogger(l)
+		}
+	}
+}
+
+// Configure configures both legs. They share a single deduplicator built
+// from the third config, so a message arriving on both legs is delivered
+// once, whichever backend is used. The second config is ignored when there
+// is no backup leg, but must be given all the same.
 func (subs *AmagiSubscriber) Configure(config []interface{}) error {
-	primaryConfig := []interface{}{config[0], config[2]}
-	err := subs.primarySubscriber.Configure(primaryConfig)
+	if len(config) != 3 {
+		return errors.New("Invalid Parameters, expected primary, backup and dedup configs")
+	}
+	dedupConfig := config[2]
+	dedup, err := service.NewDeduplicator(dedupConfig, legName(config[0]))
 	if err != nil {
 		return err
 	}
-	backupConfig := []interface{}{config[1], config[2]}
-	err = subs.backupSubscriber.Configure(backupConfig)
+	err = subs.configureLeg(subs.primarySubscriber, config[0], dedupConfig, dedup)
+	if err != nil || subs.backupSubscriber == nil {
+		return err
+	}
+	return subs.configureLeg(subs.backupSubscriber, config[1], dedupConfig, dedup)
+}
+
+// configureLeg hands dedup to leg, falling back to the dedup config for legs
+// that cannot share one.
+func (subs *AmagiSubscriber) configureLeg(leg client.JudoClient, cfg interface{}, dedupConfig interface{}, dedup service.Deduplicator) error {
+	shared, ok := leg.(deduplicated)
+	if !ok {
+		return leg.Configure([]interface{}{cfg, dedupConfig})
+	}
+	err := leg.Configure([]interface{}{cfg})
 	if err != nil {
 		return err
 	}
-	return err
+	shared.SetDeduplicator(dedup)
+	return nil
 }
 
-func (subs *AmagiSubscriber) Close() {
-	subs.primarySubscriber.Close()
-	subs.backupSubscriber.Close()
+// legName picks the default dedup namespace from the primary leg config.
+func legName(cfg interface{}) string {
+	config, _ := judoConfig.ToMap(cfg)
+	for _, key := range []string{"name", "queueName"} {
+		if name, ok := config[key].(string); ok {
+			return name
+		}
+	}
+	return "amagi"
+}
+
+// Close closes both legs, waiting for their callbacks in flight, and returns
+// the first error.
+func (subs *AmagiSubscriber) Close() error {
+	subs.runtime.Shutdown()
+	var err error
+	for _, leg := range subs.legs() {
+		if legErr := leg.Close(); err == nil {
+			err = legErr
+		}
+	}
+	return err
 }
 
+// Start starts both legs. When one fails, the legs already started are
+// closed again.
 func (subs *AmagiSubscriber) Start() (<-chan error, error) {
 	combinedErrorChannel := make(chan error)
-	errorChannel, err := subs.primarySubscriber.Start()
-	if err != nil {
-		return combinedErrorChannel, err
-	}
-	var mu sync.Mutex
-	go func(errorChannel <-chan error) {
-		mu.Lock()
-		defer mu.Unlock()
-		cherr := <-errorChannel
-		combinedErrorChannel <- cherr
-	}(errorChannel)
-
-	backupErrorChannel, err := subs.backupSubscriber.Start()
-	go func(backupErrorChannel <-chan error) {
-		mu.Lock()
-		defer mu.Unlock()
-		cherr := <-backupErrorChannel
-		combinedErrorChannel <- cherr
-	}(backupErrorChannel)
-	return combinedErrorChannel, err
+	subs.runtime.Open()
+	legs := subs.legs()
+	for i, leg := range legs {
+		errorChannel, err := leg.Start()
+		if err != nil {
+			subs.runtime.Shutdown()
+			for _, started := range legs[:i] {
+				started.Close()
+			}
+			return combinedErrorChannel, err
+		}
+		go subs.forward(errorChannel, combinedErrorChannel)
+	}
+	return combinedErrorChannel, nil
+}
+
+// forward passes the errors of a leg on to the combined channel, up to and
+// including the first one that is not a recovered panic.
+func (subs *AmagiSubscriber) forward(ec <-chan error, combined chan<- error) {
+	for {
+		select {
+		case err := <-ec:
+			subs.runtime.Report(combined, err)
+			if _, ok := err.(*client.PanicError); !ok {
+				return
+			}
+		case <-subs.runtime.Done():
+			return
+		}
+	}
 }
 
 func (subs *AmagiSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	subs.primarySubscriber.OnMessage(callback)
-	subs.backupSubscriber.OnMessage(callback)
+	callback = client.Chain(callback, subs.runtime.Middleware...)
+	for _, leg := range subs.legs() {
+		leg.OnMessage(callback)
+	}
 	return subs
 }
 
-func newSub(protocol string) client.JudoClient {
-	var sub client.JudoClient
-	switch protocol {
-	case "amqp":
-		sub = NewAmqpSub()
-	case "nano":
-		sub = NewNanoSub()
-	case "nats":
-		sub = NewNatsSub()
-	case "nats-streaming":
-		sub = NewNatsStreamSub()
-	case "redis":
-		sub = NewRedisSub()
-	case "pubnub":
-		sub = NewPubnubSub()
-	}
-	return sub
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+// It runs once per message, whichever leg delivers it.
+func (subs *AmagiSubscriber) Use(mw ...client.Middleware) {
+	subs.runtime.Middleware = append(subs.runtime.Middleware, mw...)
 }
@@ -0,0 +1,171 @@
+package sub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	nats "github.com/nats-io/go-nats"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAmagiSubscriber(t *testing.T) {
+	fakeConn := &mocks.RawConnection{}
+	connector := func(url string, opts []nats.Option) (message.RawConnection, error) {
+		return fakeConn, nil
+	}
+	fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil)
+	fakeConn.On("Close").Return(nil)
+
+	primaryQueue, backupQueue := make(chan *nats.Msg), make(chan *nats.Msg)
+	primary := &NatsSubscriber{connector: connector, msgQueue: primaryQueue}
+	backup := &NatsSubscriber{connector: connector, msgQueue: backupQueue}
+	subs := &AmagiSubscriber{primarySubscriber: primary, backupSubscriber: backup}
+
+	legConfig := map[string]interface{}{
+		"name":     "dqi50n_agent",
+		"topic":    "dqi50n.out",
+		"endpoint": "localhost:3234",
+	}
+	err := subs.Configure([]interface{}{legConfig, legConfig, map[string]interface{}{"backend": "memory"}})
+	if err != nil {
+		t.Fatal("Error Unexpected " + err.Error())
+	}
+	if primary.deDuplifier == nil || primary.deDuplifier != backup.deDuplifier {
+		t.Fatal("Legs do not share a deduplicator")
+	}
+
+	var mu sync.Mutex
+	delivered := map[string]int{}
+	subs.OnMessage(func(msg message.Message) {
+		mu.Lock()
+		delivered[string(msg.GetMessage())]++
+		mu.Unlock()
+	})
+	_, err = subs.Start()
+	if err != nil {
+		t.Fatal("Error Unexpected " + err.Error())
+	}
+
+	// Both legs receive every message at the same time.
+	const count = 200
+	var wg sync.WaitGroup
+	for _, queue := range []chan *nats.Msg{primaryQueue, backupQueue} {
+		wg.Add(1)
+		go func(queue chan *nats.Msg) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				env := message.NewEnvelope([]byte(fmt.Sprint(i)))
+				env.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+				queue <- &nats.Msg{Data: message.Encode(env)}
+			}
+		}(queue)
+	}
+	wg.Wait()
+
+	// The last messages may still be on their way to the callback.
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == count || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	subs.Close()
+
+	if len(delivered) != count {
+		t.Errorf("Expected %d messages, got %d", count, len(delivered))
+	}
+	for body, n := range delivered {
+		if n != 1 {
+			t.Errorf("Message %s delivered %d times", body, n)
+		}
+	}
+}
+
+func TestAmagiSubscriberWithoutBackup(t *testing.T) {
+	if _, err := NewAmagiSub("", "nats"); err == nil {
+		t.Error("Error Should be thrown without primary protocol")
+	}
+	subs, err := NewAmagiSub("nats", "")
+	if err != nil {
+		t.Fatal("Error Unexpected " + err.Error())
+	}
+	fakeConn := &mocks.RawConnection{}
+	fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil)
+	fakeConn.On("Close").Return(nil)
+	subs.primarySubscriber.(*NatsSubscriber).connector = func(url string, opts []nats.Option) (message.RawConnection, error) {
+		return fakeConn, nil
+	}
+	legConfig := map[string]interface{}{
+		"name":     "dqi50n_agent",
+		"topic":    "dqi50n.out",
+		"endpoint": "localhost:3234",
+	}
+	err = subs.Configure([]interface{}{legConfig, nil, map[string]interface{}{"backend": "memory"}})
+	if err != nil {
+		t.Fatal("Error Unexpected " + err.Error())
+	}
+	subs.OnMessage(func(message.Message) {})
+	if _, err = subs.Start(); err != nil {
+		t.Fatal("Error Unexpected " + err.Error())
+	}
+	if err = subs.Close(); err != nil {
+		t.Error("Error Unexpected " + err.Error())
+	}
+}
+
+func TestAmagiSubscriberErrors(t *testing.T) {
+	legConfig := map[string]interface{}{
+		"name":     "dqi50n_agent",
+		"topic":    "dqi50n.out",
+		"endpoint": "localhost:3234",
+	}
+	cases := []struct {
+		name string
+		err  string
+	}{
+		{"short-config", "Invalid Parameters, expected primary, backup and dedup configs"},
+		{"backup-fails", "nats: invalid subject"},
+	}
+	for _, c := range cases {
+		primaryConn := &mocks.RawConnection{}
+		primaryConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil)
+		primaryConn.On("Close").Return(nil)
+		primary := &NatsSubscriber{connector: func(url string, opts []nats.Option) (message.RawConnection, error) {
+			return primaryConn, nil
+		}}
+		backupConn := &mocks.RawConnection{}
+		backupConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(nil, nats.ErrBadSubject)
+		backupConn.On("Close").Return(nil)
+		backup := &NatsSubscriber{connector: func(url string, opts []nats.Option) (message.RawConnection, error) {
+			return backupConn, nil
+		}}
+		subs := &AmagiSubscriber{primarySubscriber: primary, backupSubscriber: backup}
+
+		var err error
+		switch c.name {
+		case "short-config":
+			err = subs.Configure([]interface{}{legConfig, legConfig})
+		case "backup-fails":
+			err = subs.Configure([]interface{}{legConfig, legConfig, map[string]interface{}{"backend": "memory"}})
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", c.name, err)
+			}
+			subs.OnMessage(func(message.Message) {})
+			_, err = subs.Start()
+		}
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+		}
+		if c.name == "backup-fails" {
+			primaryConn.AssertCalled(t, "Close")
+		}
+	}
+}
@@ -2,14 +2,17 @@ package sub
 
 import (
 	"errors"
-	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/service"
-	gredis "github.com/go-redis/redis"
 	"github.com/streadway/amqp"
 )
 
@@ -34,6 +37,8 @@ var amqpmap = map[string]string{
 	"password":           "Password",
 	"host":               "Host",
 	"port":               "Port",
+	"prefetchCount":      "PrefetchCount",
+	"prefetchSize":       "PrefetchSize",
 }
 
 type amqpConnector func(judoConfig.Config) (jmsg.RawChannel, error)
@@ -43,12 +48,14 @@ type AmqpSubscriber struct {
 	channel   jmsg.RawChannel
 	queue     amqp.Queue
 	msgQueue  <-chan amqp.Delivery
-	amqpConfig
+	AmqpConfig
 	callback    func(jmsg.Message)
-	deDuplifier service.Duplicate
+	deDuplifier service.Deduplicator
+	runtime     service.Runtime
+	mu          sync.Mutex
 }
 
-type amqpConfig struct {
+type AmqpConfig struct {
 	User               string
 	Password           string
 	Host               string
@@ -69,10 +76,16 @@ type amqpConfig struct {
 	QueueNoWait        bool
 	NoLocal            bool
 	Args               amqp.Table
+	PrefetchCount      int
+	PrefetchSize       int
+	service.ReconnectConfig
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
 }
 
-func (c amqpConfig) GetKeys() []string {
-	return []string{
+func (c AmqpConfig) GetKeys() []string {
+	keys := append([]string{
 		"user",
 		"password",
 		"host",
@@ -93,10 +106,15 @@ func (c amqpConfig) GetKeys() []string {
 		"internal",
 		"exchangeNoWait",
 		"args",
-	}
+		"prefetchCount",
+		"prefetchSize",
+	}, service.ReconnectKeys...)
+	keys = append(keys, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c amqpConfig) GetMandatoryKeys() []string {
+func (c AmqpConfig) GetMandatoryKeys() []string {
 	return []string{
 		"user",
 		"password",
@@ -110,8 +128,36 @@ func (c amqpConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c amqpConfig) GetField(key string) string {
-	return amqpmap[key]
+func (c AmqpConfig) GetField(key string) string {
+	if field, ok := amqpmap[key]; ok {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	if field := service.ConcurrencyField(key); field != "" {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	return service.ReconnectField(key)
+}
+
+// prefetch returns the Qos limits. Without a prefetchCount, running several
+// workers prefetches as many messages as there are workers.
+func (c AmqpConfig) prefetch() (int, int) {
+	count := c.PrefetchCount
+	if count == 0 && c.Workers > 1 {
+		count = c.Workers
+	}
+	return count, c.PrefetchSize
+}
+
+func init() {
+	registry.RegisterSubscriber("amqp", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewAmqpSub(), nil
+	})
 }
 
 func NewAmqpSub() *AmqpSubscriber {
@@ -123,87 +169,221 @@ func (sub *AmqpSubscriber) Start() (<-chan error, error) {
 
 	var err error
 	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
-	sub.msgQueue, err = sub.channel.Consume(
-		sub.queue.Name,             // queue
-		sub.amqpConfig.Tag,         // consumer
-		sub.amqpConfig.AutoAck,     // consumer
-		sub.amqpConfig.Exclusive,   // consumer
-		sub.amqpConfig.NoLocal,     // consumer
-		sub.amqpConfig.QueueNoWait, // consumer
-		sub.amqpConfig.Args,        // consumer
-	)
-
+	sub.msgQueue, err = sub.consume()
 	if err != nil {
-		return errorChannel, err
+		return errorChannel, sub.runtime.Redact(err)
 	}
 
-	go func() {
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "amqp", Topic: sub.QueueName}
+	sub.runtime.Open()
+	sub.runtime.Notify(client.Status{State: client.Connected})
+	go sub.receive(errorChannel)
+
+	return errorChannel, nil
+}
+
+func (sub *AmqpSubscriber) consume() (<-chan amqp.Delivery, error) {
+	return sub.channel.Consume(
+		sub.queue.Name,             // queue
+		sub.AmqpConfig.Tag,         // consumer
+		sub.AmqpConfig.AutoAck,     // consumer
+		sub.AmqpConfig.Exclusive,   // consumer
+		sub.AmqpConfig.NoLocal,     // consumer
+		sub.AmqpConfig.QueueNoWait, // consumer
+		sub.AmqpConfig.Args,        // consumer
+	)
+}
+
+func (sub *AmqpSubscriber) receive(ec chan error) {
+	for {
 		for msg := range sub.msgQueue {
-			wrappedMsg := jmsg.AmqpMessage{jmsg.AmqpRawMessage{msg}, sub.channel, make(map[string]string)}
-			messages := strings.Split(string(wrappedMsg.GetMessage()), "|")
-			if len(messages) == 4 {
-				messageString := strings.Replace(string(wrappedMsg.GetMessage()), messages[0]+"|", "", 1)
-				sub.deDuplifier.UniqueID = messages[0]
-				wrappedMsg.SetMessage([]byte(messageString))
+			env := jmsg.Decode(msg.Body)
+			msg.Body = env.Body
+			header := jmsg.AmqpHeader(msg.Headers)
+			for key, val := range env.Headers {
+				header[key] = val
+			}
+			wrappedMsg := jmsg.AmqpMessage{
+				RawMessage: jmsg.AmqpRawMessage{Delivery: msg},
+				Responder:  sub.channel,
+				Properties: make(map[string]string),
+				Header:     header,
+				Logger:     sub.runtime.Log(),
+				Settlement: &jmsg.Settlement{},
+				// Under a retry policy, the few nacks passed on to the
+				// broker must not loop either.
+				RejectRedelivered: sub.runtime.Retry != nil,
 			}
-			if !sub.deDuplifier.IsDuplicate() {
-				wrappedMsg.SetProperty("protocol_type", "subscribe")
-				sub.callback(wrappedMsg)
+			jmsg.SetEnvelopeProperties(wrappedMsg, env)
+			// A redelivery is the same message again, not a duplicate.
+			dedup := sub.deDuplifier
+			if msg.Redelivered {
+				dedup = nil
+				wrappedMsg.SetProperty("redelivered", "true")
 			}
+			if !sub.runtime.Admit(dedup, env.ID, wrappedMsg.Settlement) {
+				// Left unacknowledged, it would hold a prefetch slot until
+				// the channel closes.
+				if !sub.AmqpConfig.AutoAck {
+					wrappedMsg.SendAck()
+				}
+				continue
+			}
+			wrappedMsg.SetProperty("protocol_type", "subscribe")
+			wrappedMsg.SetProperty("queue", sub.queue.Name)
+			sub.runtime.Deliver(sub.Key(wrappedMsg), wrappedMsg, wrappedMsg.Settlement, sub.runtime.Recover(ec, sub.callback))
+		}
+		if !sub.AmqpConfig.Reconnect {
+			sub.runtime.Report(ec, errors.New("Disconnected from server, subscriber closed."))
+			return
+		}
+		if !sub.reconnect() {
+			return
 		}
-		errorChannel <- errors.New("Disconnected from server, subscriber closed.")
-	}()
+	}
+}
 
-	return errorChannel, nil
+// reconnect redials with backoff until consuming resumes. It returns false
+// once the subscriber is closed.
+func (sub *AmqpSubscriber) reconnect() bool {
+	select {
+	case <-sub.runtime.Done():
+		return false
+	default:
+	}
+	backoff := sub.AmqpConfig.Backoff()
+	sub.runtime.Notify(client.Status{State: client.Disconnected})
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff.Duration(attempt)):
+		case <-sub.runtime.Done():
+			return false
+		}
+		sub.runtime.Notify(client.Status{State: client.Reconnecting, Attempt: attempt})
+		err := sub.redial()
+		if err == nil {
+			sub.runtime.Notify(client.Status{State: client.Connected, Attempt: attempt})
+			return true
+		}
+		select {
+		case <-sub.runtime.Done():
+			return false
+		default:
+		}
+		sub.runtime.Notify(client.Status{State: client.Disconnected, Attempt: attempt, Err: err})
+	}
 }
 
-func (sub *AmqpSubscriber) Close() {
+// redial replaces the channel and redeclares the exchange, queue and
+// bindings before consuming again.
+func (sub *AmqpSubscriber) redial() error {
+	channel, err := sub.connector(&sub.AmqpConfig)
+	if err != nil {
+		return err
+	}
+
+	sub.mu.Lock()
+	select {
+	case <-sub.runtime.Done():
+		sub.mu.Unlock()
+		channel.Close()
+		return errors.New("Subscriber closed.")
+	default:
+	}
 	sub.channel.Close()
+	sub.channel = channel
+	sub.mu.Unlock()
+
+	err = sub.setup(sub.AmqpConfig)
+	if err != nil {
+		return err
+	}
+	sub.msgQueue, err = sub.consume()
+	return err
+}
+
+// Status reports connection loss and recovery when reconnect is enabled.
+func (sub *AmqpSubscriber) Status() <-chan client.Status {
+	return sub.runtime.Status()
+}
+
+// Close stops delivering messages, waits for the callbacks in flight and
+// then closes the channel.
+func (sub *AmqpSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.channel.Close()
 }
 
 func (sub *AmqpSubscriber) OnMessage(callback func(jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *AmqpSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
 func (sub *AmqpSubscriber) Configure(configs []interface{}) error {
 
 	// extract connection details from config and call connect
 	var err error
-	config := configs[0].(map[string]interface{})
-	if _, ok := config["routingKeys"]; !ok {
+	config, err := judoConfig.ToMap(configs[0])
+	if err != nil {
+		return err
+	}
+	keys, ok := config["routingKeys"]
+	if !ok {
 		return errors.New("Key Missing : routingKeys")
 	}
-	config["routingKeys"] = strings.Split(config["routingKeys"].(string), ",")
+	if joined, ok := keys.(string); ok {
+		config["routingKeys"] = strings.Split(joined, ",")
+	}
 
-	cfgHelper := judoConfig.ConfigHelper{&sub.amqpConfig}
+	cfgHelper := judoConfig.ConfigHelper{Config: &sub.AmqpConfig}
 	err = cfgHelper.ValidateAndSet(config)
+	if err == nil {
+		err = sub.AmqpConfig.ValidateReconnect()
+	}
 	if err != nil {
 		return err
 	}
+	if sub.runtime.Retry, err = sub.AmqpConfig.RetryPolicy(); err != nil {
+		return err
+	}
+	sub.runtime.SetSecrets(sub.AmqpConfig.Password)
 
-	sub.channel, err = sub.connector(&sub.amqpConfig)
+	sub.channel, err = sub.connector(&sub.AmqpConfig)
 	if err != nil {
-		return err
+		return sub.runtime.Redact(err)
 	}
 
-	err = sub.setup(sub.amqpConfig)
+	err = sub.runtime.Redact(sub.setup(sub.AmqpConfig))
 
-	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+	if len(configs) == 2 && err == nil {
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.AmqpConfig.QueueName)
 	}
 
 	return err
 }
 
-func (sub *AmqpSubscriber) setup(c amqpConfig) error {
+func (sub *AmqpSubscriber) setup(c AmqpConfig) error {
 
 	var err error
+	if count, size := c.prefetch(); count > 0 || size > 0 {
+		err = sub.channel.Qos(count, size, false)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = sub.channel.ExchangeDeclare(
 		c.ExchangeName,
 		c.ExchangeType,
@@ -250,19 +430,31 @@ func (sub *AmqpSubscriber) setup(c amqpConfig) error {
 
 func amqpConnect(cfg judoConfig.Config) (jmsg.RawChannel, error) {
 
-	var err error
-	connCfg := cfg.(*amqpConfig)
-	connectTo := fmt.Sprintf("amqp://%s:%s@%s:%s/", connCfg.User, connCfg.Password, connCfg.Host, connCfg.Port)
-	connection, err := amqp.Dial(connectTo)
-
+	connCfg := cfg.(*AmqpConfig)
+	tlsCfg, err := connCfg.ClientTLS(connCfg.Host)
 	if err != nil {
 		return jmsg.AmqpRawChannel{}, err
 	}
+	return jmsg.DialAmqp(connCfg.User, connCfg.Password, connCfg.Host, connCfg.Port, tlsCfg)
+}
 
-	channel, err := connection.Channel()
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *AmqpSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
 
-	if err != nil {
-		return jmsg.AmqpRawChannel{}, err
-	}
-	return jmsg.AmqpRawChannel{channel}, nil
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *AmqpSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *AmqpSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *AmqpSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
 }
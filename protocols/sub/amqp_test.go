@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/amagimedia/judo/v3/client"
 	"github.com/amagimedia/judo/v3/config"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
@@ -296,9 +297,83 @@ func TestAmqpSubscriber(t *testing.T) {
 			"consume-err",
 			errors.New("Unable to consume from queue"),
 		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"user":         "guest",
+					"password":     "MadFds@123",
+					"host":         "localhost",
+					"port":         "5672",
+					"exchangeName": "blip_localhost",
+					"exchangeType": "topic",
+					"queueDurable": true,
+					"queueNoWait":  true,
+					"args":         nil,
+					"queueName":    "amqp2",
+					"routingKeys":  "blip.da",
+					"tag":          "test",
+					"autoAck":      true,
+				},
+			},
+			"consume-redact",
+			errors.New("Exception (403) Reason: \"access refused for guest:MadFds@123\""),
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"user":              "guest",
+					"password":          "MadFds@123",
+					"host":              "localhost",
+					"port":              "5672",
+					"exchangeName":      "blip_localhost",
+					"exchangeType":      "topic",
+					"queueDurable":      true,
+					"queueNoWait":       true,
+					"args":              nil,
+					"queueName":         "amqp2",
+					"routingKeys":       "blip.da",
+					"tag":               "test",
+					"autoAck":           true,
+					"reconnect":         true,
+					"reconnectInterval": 10,
+				},
+			},
+			"reconnect",
+			errors.New("Cannot Create connection, Server not found"),
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"user":         "guest",
+					"password":     "MadFds@123",
+					"host":         "localhost",
+					"port":         "5672",
+					"exchangeName": "blip_localhost",
+					"exchangeType": "topic",
+					"queueDurable": true,
+					"queueNoWait":  true,
+					"args":         nil,
+					"queueName":    "amqp2",
+					"routingKeys":  "blip.da",
+					"tag":          "test",
+					"workers":      4.0,
+				},
+			},
+			"prefetch",
+			errors.New("Error in Qos"),
+		},
 	}
+
 	for _, c := range cases {
 		switch c.retVal {
+		case "prefetch":
+			// Without prefetchCount, four workers prefetch four messages.
+			fakeSubscriber = &AmqpSubscriber{connector: connector}
+			fakeChannel.On("Qos", 4, 0, false).Return(c.retType).Once()
+			err := fakeSubscriber.Configure(c.config)
+			if err == nil || err.Error() != c.retType.Error() {
+				t.Error("Qos not applied", err)
+			}
 		case "success":
 			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, amqp.Table(nil)).Return(nil).Once()
 			fakeChannel.On("QueueDeclare", "amqp2", true, false, false, true, amqp.Table(nil)).Return(amqp.Queue{}, nil).Once()
@@ -372,6 +447,7 @@ func TestAmqpSubscriber(t *testing.T) {
 				t.Error("Error in Configure", err.Error())
 			}
 			fakeChannel.On("Consume", "", "test", true, false, false, true, amqp.Table(nil)).Return(make(<-chan amqp.Delivery), nil).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err != nil {
 				t.Error("Error in Starting Consumer", err.Error())
@@ -447,7 +523,155 @@ func TestAmqpSubscriber(t *testing.T) {
 			if err.Error() != c.retType.Error() {
 				t.Error("Did not throw error when required.")
 			}
+		case "consume-redact":
+			fakeSubscriber.connector = connector
+			fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, amqp.Table(nil)).Return(nil).Once()
+			fakeChannel.On("QueueDeclare", "amqp2", true, false, false, true, amqp.Table(nil)).Return(amqp.Queue{}, nil).Once()
+			fakeChannel.On("QueueBind", "", "blip.da", "blip_localhost", true, amqp.Table(nil)).Return(nil).Once()
+			err := fakeSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error in Configure", err.Error())
+			}
+			fakeChannel.On("Consume", "", "test", true, false, false, true, amqp.Table(nil)).Return(make(<-chan amqp.Delivery), c.retType).Once()
+			_, err = fakeSubscriber.Start()
+			if err == nil || err.Error() != `Exception (403) Reason: "access refused for guest:xxxxx"` {
+				t.Error("Start error not redacted", err)
+			}
+		case "reconnect":
+			first, second := &mocks.RawChannel{}, &mocks.RawChannel{}
+			dials := 0
+			rSubscriber := &AmqpSubscriber{connector: func(config.Config) (message.RawChannel, error) {
+				dials++
+				switch dials {
+				case 1:
+					return first, nil
+				case 2:
+					return nil, c.retType
+				}
+				return second, nil
+			}}
+			rc1, rc2 := make(chan amqp.Delivery), make(chan amqp.Delivery)
+			for _, ch := range []*mocks.RawChannel{first, second} {
+				ch.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, amqp.Table(nil)).Return(nil).Once()
+				ch.On("QueueDeclare", "amqp2", true, false, false, true, amqp.Table(nil)).Return(amqp.Queue{}, nil).Once()
+				ch.On("QueueBind", "", "blip.da", "blip_localhost", true, amqp.Table(nil)).Return(nil).Once()
+				ch.On("Close").Return(nil).Once()
+			}
+			first.On("Consume", "", "test", true, false, false, true, amqp.Table(nil)).Return((<-chan amqp.Delivery)(rc1), nil).Once()
+			second.On("Consume", "", "test", true, false, false, true, amqp.Table(nil)).Return((<-chan amqp.Delivery)(rc2), nil).Once()
+
+			err := rSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error in Configure", err.Error())
+			}
+			received := make(chan string)
+			rSubscriber.OnMessage(func(msg message.Message) {
+				received <- string(msg.GetMessage())
+			})
+			ec, err := rSubscriber.Start()
+			if err != nil {
+				t.Error("Error in Start", err.Error())
+			}
+			close(rc1)
+
+			expected := []client.Status{
+				{State: client.Connected, Attempt: 0, Err: nil},
+				{State: client.Disconnected, Attempt: 0, Err: nil},
+				{State: client.Reconnecting, Attempt: 1, Err: nil},
+				{State: client.Disconnected, Attempt: 1, Err: c.retType},
+				{State: client.Reconnecting, Attempt: 2, Err: nil},
+				{State: client.Connected, Attempt: 2, Err: nil},
+			}
+			for _, want := range expected {
+				select {
+				case got := <-rSubscriber.Status():
+					if got.State != want.State || got.Attempt != want.Attempt || (want.Err == nil) != (got.Err == nil) {
+						t.Errorf("Unexpected status, expected %v got %v", want, got)
+					}
+				case err := <-ec:
+					t.Fatal("Disconnect reported as error while reconnecting", err)
+				case <-time.After(time.Second):
+					t.Fatal("Timed out waiting for status", want.State)
+				}
+			}
+
+			go func() {
+				rc2 <- amqp.Delivery{Body: []byte("resumed")}
+			}()
+			if msg := <-received; msg != "resumed" {
+				t.Error("Consuming did not resume after reconnect", msg)
+			}
+			rSubscriber.Close()
+			first.AssertExpectations(t)
+			second.AssertExpectations(t)
 		}
 	}
 
 }
+
+// tagAcker records the delivery tags that are acked.
+type tagAcker chan uint64
+
+func (a tagAcker) Ack(tag uint64, multiple bool) error {
+	a <- tag
+	return nil
+}
+
+func (a tagAcker) Nack(tag uint64, multiple, requeue bool) error { return nil }
+
+func (a tagAcker) Reject(tag uint64, requeue bool) error { return nil }
+
+func TestAmqpDuplicates(t *testing.T) {
+	fakeChannel := &mocks.RawChannel{}
+	fakeChannel.On("ExchangeDeclare", "blip_localhost", "topic", false, false, false, false, amqp.Table(nil)).Return(nil)
+	fakeChannel.On("QueueDeclare", "amqp2", false, false, false, false, amqp.Table(nil)).Return(amqp.Queue{}, nil)
+	fakeChannel.On("QueueBind", "", "blip.da", "blip_localhost", false, amqp.Table(nil)).Return(nil)
+	fakeChannel.On("Close").Return(nil)
+	rc := make(chan amqp.Delivery)
+	fakeChannel.On("Consume", "", "test", false, false, false, false, amqp.Table(nil)).Return((<-chan amqp.Delivery)(rc), nil)
+
+	fakeSubscriber := &AmqpSubscriber{connector: func(config.Config) (message.RawChannel, error) {
+		return fakeChannel, nil
+	}}
+	err := fakeSubscriber.Configure([]interface{}{map[string]interface{}{
+		"user":         "guest",
+		"password":     "guest",
+		"host":         "localhost",
+		"port":         "5672",
+		"exchangeName": "blip_localhost",
+		"exchangeType": "topic",
+		"queueName":    "amqp2",
+		"routingKeys":  "blip.da",
+		"tag":          "test",
+	}})
+	if err != nil {
+		t.Fatal("Error in Configure", err)
+	}
+	fakeSubscriber.SetDeduplicator(seenAll{})
+	received := make(chan string, 2)
+	fakeSubscriber.OnMessage(func(msg message.Message) {
+		received <- string(msg.GetMessage())
+		msg.SendAck()
+	})
+	if _, err := fakeSubscriber.Start(); err != nil {
+		t.Fatal("Error in Start", err)
+	}
+	defer fakeSubscriber.Close()
+
+	acker := make(tagAcker, 2)
+	rc <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 1, Body: []byte("copy")}
+	rc <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 2, Body: []byte("redelivered"), Redelivered: true}
+	for _, want := range []uint64{1, 2} {
+		select {
+		case tag := <-acker:
+			if tag != want {
+				t.Errorf("Expected tag %d acked, got %d", want, tag)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Tag %d not acked", want)
+		}
+	}
+	if msg := <-received; msg != "redelivered" || len(received) != 0 {
+		t.Error("Unexpected delivery", msg)
+	}
+}
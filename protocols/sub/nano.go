@@ -1,16 +1,17 @@
 package sub
 
 import (
-	"strings"
-
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/service"
 	mangoSub "github.com/go-mangos/mangos/protocol/sub"
 	"github.com/go-mangos/mangos/transport/ipc"
 	"github.com/go-mangos/mangos/transport/tcp"
-	gredis "github.com/go-redis/redis"
+	"github.com/go-mangos/mangos/transport/tlstcp"
 	mangos "nanomsg.org/go-mangos"
 )
 
@@ -26,28 +27,34 @@ var nanomap = map[string]string{
 type NanoSubscriber struct {
 	connector  nanoConnector
 	connection jmsg.RawSocket //mangos.Socket
-	nanoConfig
+	NanoConfig
 	callback    func(jmsg.Message)
-	deDuplifier service.Duplicate
+	deDuplifier service.Deduplicator
+	runtime     service.Runtime
 }
 
-type nanoConfig struct {
+type NanoConfig struct {
 	Name      string
 	Topic     string
 	Endpoint  string
 	Separator string
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
 }
 
-func (c nanoConfig) GetKeys() []string {
-	return []string{
+func (c NanoConfig) GetKeys() []string {
+	keys := append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"separator",
-	}
+	}, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c nanoConfig) GetMandatoryKeys() []string {
+func (c NanoConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -55,8 +62,23 @@ func (c nanoConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c nanoConfig) GetField(key string) string {
-	return nanomap[key]
+func (c NanoConfig) GetField(key string) string {
+	if field, ok := nanomap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	return service.ConcurrencyField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("nano", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewNanoSub(), nil
+	})
 }
 
 func NewNanoSub() *NanoSubscriber {
@@ -67,35 +89,46 @@ func NewNanoSub() *NanoSubscriber {
 func (sub *NanoSubscriber) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&sub.nanoConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &sub.NanoConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
+	if sub.runtime.Retry, err = sub.NanoConfig.RetryPolicy(); err != nil {
+		return err
+	}
 	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.NanoConfig.Name)
 	}
 	return err
 }
 
-func (sub *NanoSubscriber) Close() {
-	sub.connection.Close()
+// Close waits for the callbacks in flight and then closes the socket.
+func (sub *NanoSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	if sub.connection == nil {
+		return nil
+	}
+	return sub.connection.Close()
 }
 
 func (sub *NanoSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *NanoSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
 func (sub *NanoSubscriber) Start() (<-chan error, error) {
 
 	var err error
 	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
 	sub.connection, err = sub.connector()
 	if err != nil {
@@ -104,17 +137,29 @@ func (sub *NanoSubscriber) Start() (<-chan error, error) {
 
 	sub.connection.AddTransport(ipc.NewTransport())
 	sub.connection.AddTransport(tcp.NewTransport())
-	err = sub.connection.Dial(sub.nanoConfig.Endpoint)
+	sub.connection.AddTransport(tlstcp.NewTransport())
+	tlsCfg, err := sub.NanoConfig.ClientTLS(sub.NanoConfig.Endpoint)
+	if err != nil {
+		return errorChannel, err
+	}
+	if tlsCfg != nil {
+		err = sub.connection.DialOptions(sub.NanoConfig.Endpoint, map[string]interface{}{mangos.OptionTLSConfig: tlsCfg})
+	} else {
+		err = sub.connection.Dial(sub.NanoConfig.Endpoint)
+	}
 
 	if err != nil {
 		return errorChannel, err
 	}
 
-	err = sub.connection.SetOption(mangos.OptionSubscribe, []byte(sub.nanoConfig.Topic))
+	err = sub.connection.SetOption(mangos.OptionSubscribe, []byte(sub.NanoConfig.Topic))
 	if err != nil {
 		return errorChannel, err
 	}
 
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "nano", Topic: sub.NanoConfig.Topic}
+	sub.runtime.Open()
 	go sub.receive(errorChannel)
 
 	return errorChannel, err
@@ -124,18 +169,22 @@ func (sub *NanoSubscriber) receive(ec chan error) {
 	for {
 		msg, err := sub.connection.Recv()
 		if err != nil {
-			ec <- err
+			sub.runtime.Report(ec, err)
 			return
 		}
-		message := jmsg.NanoMessage{jmsg.NanoRawMessage{msg}, sub.connection, make(map[string]string)}
-		messages := strings.Split(string(message.GetMessage()), "|")
-		if len(messages) == 4 {
-			messageString := strings.Replace(string(message.GetMessage()), messages[0]+"|", "", 1)
-			sub.deDuplifier.UniqueID = messages[0]
-			message.SetMessage([]byte(messageString))
+		env := jmsg.Decode(msg)
+		message := jmsg.NanoMessage{
+			RawMessage: jmsg.NanoRawMessage{Raw: env.Body},
+			Responder:  sub.connection,
+			Properties: make(map[string]string),
+			Header:     env.Headers,
+			Settlement: &jmsg.Settlement{},
 		}
-		if !sub.deDuplifier.IsDuplicate() {
-			sub.callback(message)
+		jmsg.SetEnvelopeProperties(message, env)
+		if sub.runtime.Admit(sub.deDuplifier, env.ID, message.Settlement) {
+			if !sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(ec, sub.callback)) {
+				return
+			}
 		}
 	}
 }
@@ -148,3 +197,24 @@ func nanoConnect() (jmsg.RawSocket, error) {
 
 	return jmsg.NanoRawSocket{socket}, nil
 }
+
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *NanoSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
+
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *NanoSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *NanoSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *NanoSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
+}
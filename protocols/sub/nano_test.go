@@ -1,10 +1,12 @@
 package sub
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
+	"github.com/amagimedia/judo/v3/client"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
 	"github.com/stretchr/testify/mock"
@@ -109,6 +111,42 @@ func TestNanoSubscriber(t *testing.T) {
 			"set-err",
 			errors.New("Invalid Option for socket"),
 		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":      "dqi50n_agent",
+					"topic":     "dqi50n.out",
+					"separator": "|",
+					"endpoint":  "ipc:///tmp/dqi50n.out",
+				},
+			},
+			"close-waits",
+			nil,
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":      "dqi50n_agent",
+					"topic":     "dqi50n.out",
+					"separator": "|",
+					"endpoint":  "ipc:///tmp/dqi50n.out",
+				},
+			},
+			"run-cancel",
+			nil,
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":      "dqi50n_agent",
+					"topic":     "dqi50n.out",
+					"separator": "|",
+					"endpoint":  "ipc:///tmp/dqi50n.out",
+				},
+			},
+			"headers",
+			nil,
+		},
 	}
 	for _, c := range cases {
 		switch c.retVal {
@@ -129,28 +167,34 @@ func TestNanoSubscriber(t *testing.T) {
 				t.Error("Invalid Error thrown", err.Error())
 			}
 		case "success-start":
-			err := fakeSubscriber.Configure(c.config)
+			// The receive loop keeps reading the socket until it sees the
+			// subscriber closed, so later cases get a subscriber of their own.
+			fSocket := &mocks.RawSocket{}
+			fSubscriber := &NanoSubscriber{connector: func() (message.RawSocket, error) {
+				return fSocket, nil
+			}}
+			err := fSubscriber.Configure(c.config)
 			if err != nil {
 				t.Error("Failed in verifying start method.")
 			}
 			called := false
-			fakeSubscriber.OnMessage(func(message.Message) {
+			fSubscriber.OnMessage(func(message.Message) {
 				called = true
 			})
-			fakeSocket.On("AddTransport", mock.Anything).Return(nil)
-			fakeSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
-			fakeSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
-			fakeSocket.On("Recv").Return([]byte("a|b"), nil)
-			_, err = fakeSubscriber.Start()
+			fSocket.On("AddTransport", mock.Anything).Return(nil)
+			fSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
+			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
+			fSocket.On("Recv").Return([]byte("a|b"), nil)
+			_, err = fSubscriber.Start()
 			if err != nil {
 				t.Error("Failed in verifying start method.")
 			}
 			time.Sleep(time.Millisecond * 100)
+			fSocket.On("Close").Return(nil)
+			fSubscriber.Close()
 			if !called {
 				t.Error("Failed in verifying start method.")
 			}
-			fakeSocket.On("Close").Return(nil)
-			fakeSubscriber.Close()
 		case "dial-err":
 			err := fakeSubscriber.Configure(c.config)
 			if err != nil {
@@ -158,6 +202,7 @@ func TestNanoSubscriber(t *testing.T) {
 			}
 			fakeSocket.On("AddTransport", mock.Anything).Return(nil)
 			fakeSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(c.retType).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("Start did not fail when expected")
@@ -178,6 +223,7 @@ func TestNanoSubscriber(t *testing.T) {
 			fSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
 			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
 			fSocket.On("Recv").Return([]byte(""), c.retType).Once()
+			fSubscriber.OnMessage(func(message.Message) {})
 			ec, err := fSubscriber.Start()
 			err = <-ec
 			if err.Error() != c.retType.Error() {
@@ -195,6 +241,124 @@ func TestNanoSubscriber(t *testing.T) {
 			if err.Error() != c.retType.Error() {
 				t.Error("Start did not fail when expected")
 			}
+		case "close-waits":
+			fSocket := &mocks.RawSocket{}
+			fSubscriber := &NanoSubscriber{connector: func() (message.RawSocket, error) {
+				return fSocket, nil
+			}}
+			err := fSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Configure failed when not expected.")
+			}
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			calls := 0
+			fSubscriber.OnMessage(func(message.Message) {
+				calls++
+				if calls == 1 {
+					close(entered)
+					<-release
+				}
+			})
+			fSocket.On("AddTransport", mock.Anything).Return(nil)
+			fSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
+			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
+			fSocket.On("Recv").Return([]byte("a|b"), nil)
+			fSocket.On("Close").Return(nil).Once()
+			_, err = fSubscriber.Start()
+			if err != nil {
+				t.Error("Failed in verifying start method.")
+			}
+			<-entered
+
+			closed := make(chan error)
+			go func() {
+				closed <- fSubscriber.Close()
+			}()
+			select {
+			case <-closed:
+				t.Error("Close returned while a callback was in flight")
+			case <-time.After(time.Millisecond * 50):
+			}
+			close(release)
+			if err := <-closed; err != nil {
+				t.Error("Close failed when not expected", err.Error())
+			}
+			if calls != 1 {
+				t.Error("Callback invoked after Close", calls)
+			}
+			fSocket.AssertCalled(t, "Close")
+		case "run-cancel":
+			fSocket := &mocks.RawSocket{}
+			fSubscriber := &NanoSubscriber{connector: func() (message.RawSocket, error) {
+				return fSocket, nil
+			}}
+			err := fSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Configure failed when not expected.")
+			}
+			received := make(chan struct{}, 1)
+			fSubscriber.OnMessage(func(message.Message) {
+				select {
+				case received <- struct{}{}:
+				default:
+				}
+			})
+			fSocket.On("AddTransport", mock.Anything).Return(nil)
+			fSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
+			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
+			fSocket.On("Recv").Return([]byte("a|b"), nil)
+			fSocket.On("Close").Return(nil).Once()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- client.Run(ctx, fSubscriber)
+			}()
+			<-received
+			cancel()
+			if err := <-done; err != nil {
+				t.Error("Run failed on cancel", err.Error())
+			}
+			fSocket.AssertCalled(t, "Close")
+		case "headers":
+			fSocket := &mocks.RawSocket{}
+			fSubscriber := &NanoSubscriber{connector: func() (message.RawSocket, error) {
+				return fSocket, nil
+			}}
+			err := fSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Configure failed when not expected.")
+			}
+			received := make(chan message.Message, 1)
+			fSubscriber.OnMessage(func(msg message.Message) {
+				select {
+				case received <- msg:
+				default:
+				}
+			})
+			fSocket.On("AddTransport", mock.Anything).Return(nil)
+			fSocket.On("Dial", "ipc:///tmp/dqi50n.out").Return(nil).Once()
+			fSocket.On("SetOption", mock.Anything, []byte("dqi50n.out")).Return(nil).Once()
+			env := message.NewEnvelope([]byte("dqi50n.out|MSG"))
+			env.Headers = map[string]string{"trace-id": "abc"}
+			fSocket.On("Recv").Return(message.Encode(env), nil)
+			fSocket.On("Close").Return(nil).Once()
+			_, err = fSubscriber.Start()
+			if err != nil {
+				t.Error("Failed in verifying start method.")
+			}
+			msg := <-received
+			if string(msg.GetMessage()) != "dqi50n.out|MSG" {
+				t.Error("Envelope not unwrapped", string(msg.GetMessage()))
+			}
+			if val, _ := msg.GetHeader("trace-id"); val != "abc" {
+				t.Error("Header not received", msg.GetHeaders())
+			}
+			if val, _ := msg.GetProperty("message_id"); val != env.ID {
+				t.Error("Envelope id not exposed", val)
+			}
+			fSubscriber.Close()
 		}
 	}
 
@@ -2,58 +2,76 @@ package sub
 
 import (
 	"errors"
-	"fmt"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/service"
-	gredis "github.com/go-redis/redis"
 	nats "github.com/nats-io/go-nats"
 )
 
 var natsmap = map[string]string{
-	"name":     "Name",
-	"topic":    "Topic",
-	"endpoint": "Endpoint",
-	"user":     "User",
-	"password": "Password",
-	"token":    "Token",
+	"name":          "Name",
+	"topic":         "Topic",
+	"endpoint":      "Endpoint",
+	"user":          "User",
+	"password":      "Password",
+	"token":         "Token",
+	"maxReconnects": "MaxReconnects",
+	"reconnectWait": "ReconnectWait",
 }
 
-type natsConnector func(string) (jmsg.RawConnection, error)
+type natsConnector func(string, []nats.Option) (jmsg.RawConnection, error)
 
 type NatsSubscriber struct {
 	connector  natsConnector
 	connection jmsg.RawConnection
-	msgQueue   <-chan *nats.Msg
-	natsConfig
-	callback    func(jmsg.Message)
-	deDuplifier service.Duplicate
+	msgQueue   chan *nats.Msg
+	NatsConfig
+	callback     func(jmsg.Message)
+	deDuplifier  service.Deduplicator
+	runtime      service.Runtime
+	mu           sync.Mutex
+	errorChannel chan error
 }
 
-type natsConfig struct {
-	Name     string
-	Topic    string
-	Endpoint string
-	User     string
-	Password string
-	Token    string
+// MaxReconnects is left nil to keep the client default of 60 attempts; zero
+// disables reconnecting and -1 retries forever.
+type NatsConfig struct {
+	Name          string
+	Topic         string
+	Endpoint      string
+	User          string
+	Password      string
+	Token         string
+	MaxReconnects *int
+	ReconnectWait time.Duration
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
 }
 
-func (c natsConfig) GetKeys() []string {
-	return []string{
+func (c NatsConfig) GetKeys() []string {
+	keys := append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"user",
 		"password",
 		"token",
-	}
+		"maxReconnects",
+		"reconnectWait",
+	}, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c natsConfig) GetMandatoryKeys() []string {
+func (c NatsConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -61,85 +79,203 @@ func (c natsConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c natsConfig) GetField(key string) string {
-	return natsmap[key]
+func (c NatsConfig) GetField(key string) string {
+	if field, ok := natsmap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	return service.ConcurrencyField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("nats", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewNatsSub(), nil
+	})
 }
 
 func NewNatsSub() *NatsSubscriber {
-	sub := &NatsSubscriber{connector: natsConnect, msgQueue: make(<-chan *nats.Msg)}
+	sub := &NatsSubscriber{connector: natsConnect, msgQueue: make(chan *nats.Msg)}
 	return sub
 }
 
 func (sub *NatsSubscriber) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&sub.natsConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &sub.NatsConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
 
-	url := sub.natsConfig.Endpoint
-	if sub.natsConfig.User != "" && sub.natsConfig.Password != "" {
-		url = fmt.Sprintf("%s:%s@%s", sub.natsConfig.User, sub.natsConfig.Password, sub.natsConfig.Endpoint)
-	} else if sub.natsConfig.Token != "" {
-		url = fmt.Sprintf("%s@%s", sub.natsConfig.Token, sub.natsConfig.Endpoint)
+	if sub.runtime.Retry, err = sub.NatsConfig.RetryPolicy(); err != nil {
+		return err
+	}
+	sub.runtime.SetSecrets(sub.NatsConfig.Password, sub.NatsConfig.Token)
+	opts := sub.options()
+	// The client names every server of the cluster after its own URL.
+	tlsCfg, err := sub.NatsConfig.ClientTLS("")
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, nats.Secure(tlsCfg))
 	}
 
-	sub.connection, err = sub.connector(url)
-	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+	sub.connection, err = sub.connector(sub.NatsConfig.Endpoint, opts)
+	err = sub.runtime.Redact(err)
+	if len(configs) == 2 && err == nil {
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.NatsConfig.Name)
 	}
 
 	return err
 }
 
 func (sub *NatsSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *NatsSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
+// options hooks connection state changes into the status channel. The
+// client resubscribes on its own after reconnecting, so only a connection
+// that is closed for good is reported as an error. Credentials are passed
+// as options rather than in the URL.
+func (sub *NatsSubscriber) options() []nats.Option {
+	opts := []nats.Option{
+		nats.DisconnectHandler(func(*nats.Conn) {
+			sub.runtime.Notify(client.Status{State: client.Disconnected})
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			sub.runtime.Notify(client.Status{State: client.Connected})
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			sub.disconnected()
+		}),
+	}
+	if sub.NatsConfig.MaxReconnects != nil {
+		opts = append(opts, nats.MaxReconnects(*sub.NatsConfig.MaxReconnects))
+	}
+	if sub.NatsConfig.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(sub.NatsConfig.ReconnectWait))
+	}
+	if sub.NatsConfig.User != "" && sub.NatsConfig.Password != "" {
+		opts = append(opts, nats.UserInfo(sub.NatsConfig.User, sub.NatsConfig.Password))
+	} else if sub.NatsConfig.Token != "" {
+		opts = append(opts, nats.Token(sub.NatsConfig.Token))
+	}
+	return opts
+}
+
+// Status reports connection loss and recovery.
+func (sub *NatsSubscriber) Status() <-chan client.Status {
+	return sub.runtime.Status()
+}
+
 func (sub *NatsSubscriber) Start() (<-chan error, error) {
 
 	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
-	_, err := sub.connection.ChanSubscribe(sub.natsConfig.Topic, sub.msgQueue)
+	_, err := sub.connection.ChanSubscribe(sub.NatsConfig.Topic, sub.msgQueue)
 
 	if err != nil {
-		return errorChannel, err
+		return errorChannel, sub.runtime.Redact(err)
 	}
 
+	sub.mu.Lock()
+	sub.errorChannel = errorChannel
+	sub.mu.Unlock()
+
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "nats", Topic: sub.NatsConfig.Topic}
+	sub.runtime.Open()
 	go sub.receive(errorChannel)
 
 	return errorChannel, err
 }
 
-func (sub *NatsSubscriber) Close() {
-	sub.connection.Close()
+// Close waits for the callbacks in flight and then closes the connection.
+func (sub *NatsSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	if sub.connection != nil {
+		sub.connection.Close()
+	}
+	return nil
 }
 
 func (sub *NatsSubscriber) receive(ec chan error) {
-	for msg := range sub.msgQueue {
-		message := jmsg.NatsMessage{jmsg.NatsRawMessage{msg}, sub.connection, make(map[string]string)}
-		messages := strings.Split(string(message.GetMessage()), "|")
-		if len(messages) == 4 {
-			messageString := strings.Replace(string(message.GetMessage()), messages[0]+"|", "", 1)
-			sub.deDuplifier.UniqueID = messages[0]
-			message.SetMessage([]byte(messageString))
+	for {
+		var msg *nats.Msg
+		var ok bool
+		select {
+		case msg, ok = <-sub.msgQueue:
+		case <-sub.runtime.Done():
+			return
+		}
+		if !ok {
+			sub.disconnected()
+			return
+		}
+		env := jmsg.Decode(msg.Data)
+		msg.Data = env.Body
+		message := jmsg.NatsMessage{
+			RawMessage: jmsg.NatsRawMessage{Msg: msg},
+			Responder:  sub.connection,
+			Properties: make(map[string]string),
+			Header:     env.Headers,
+			Settlement: &jmsg.Settlement{},
 		}
-		if !sub.deDuplifier.IsDuplicate() {
-			sub.callback(message)
+		jmsg.SetEnvelopeProperties(message, env)
+		if sub.runtime.Admit(sub.deDuplifier, env.ID, message.Settlement) {
+			if !sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(ec, sub.callback)) {
+				return
+			}
 		}
 	}
-	ec <- errors.New("Disconnected, from server for " + sub.natsConfig.Name)
 }
 
-func natsConnect(url string) (jmsg.RawConnection, error) {
-	connection, err := nats.Connect("nats://" + url)
-	return &jmsg.NatsRawConnection{*connection}, err
+func (sub *NatsSubscriber) disconnected() {
+	sub.mu.Lock()
+	ec := sub.errorChannel
+	sub.mu.Unlock()
+	if ec != nil {
+		sub.runtime.Report(ec, errors.New("Disconnected, from server for "+sub.NatsConfig.Name))
+	}
+}
+
+func natsConnect(url string, opts []nats.Option) (jmsg.RawConnection, error) {
+	connection, err := nats.Connect("nats://"+url, opts...)
+	return &jmsg.NatsRawConnection{connection}, err
+}
+
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *NatsSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
+
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *NatsSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *NatsSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *NatsSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
 }
@@ -1,25 +1,32 @@
 package sub
 
 import (
-	"fmt"
-	"strings"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/service"
-	gredis "github.com/go-redis/redis"
+	nats "github.com/nats-io/go-nats"
 	natsStream "github.com/nats-io/go-nats-streaming"
 )
 
 var natsSmap = map[string]string{
-	"name":     "Name",
-	"topic":    "Topic",
-	"endpoint": "Endpoint",
-	"cluster":  "Cluster",
-	"user":     "User",
-	"password": "Password",
-	"token":    "Token",
+	"name":         "Name",
+	"topic":        "Topic",
+	"endpoint":     "Endpoint",
+	"cluster":      "Cluster",
+	"user":         "User",
+	"password":     "Password",
+	"token":        "Token",
+	"pingInterval": "PingInterval",
+	"pingMaxOut":   "PingMaxOut",
 }
 
 type natsStreamConnector func(string, judoConfig.Config, func(natsStream.Conn, error)) (jmsg.RawConnection, error)
@@ -27,24 +34,35 @@ type natsStreamConnector func(string, judoConfig.Config, func(natsStream.Conn, e
 type NatsStreamSubscriber struct {
 	connection jmsg.RawConnection
 	connector  natsStreamConnector
-	natsStreamConfig
+	NatsStreamConfig
+	url          string
 	errorChannel chan error
 	callback     func(jmsg.Message)
-	deDuplifier  service.Duplicate
+	deDuplifier  service.Deduplicator
+	runtime      service.Runtime
+	mu           sync.Mutex
 }
 
-type natsStreamConfig struct {
-	Name     string
-	Topic    string
-	Endpoint string
-	Cluster  string
-	User     string
-	Password string
-	Token    string
+// PingInterval is rounded up to whole seconds. A connection that misses
+// PingMaxOut pings in a row is considered lost.
+type NatsStreamConfig struct {
+	Name         string
+	Topic        string
+	Endpoint     string
+	Cluster      string
+	User         string
+	Password     string
+	Token        string
+	PingInterval time.Duration
+	PingMaxOut   int
+	service.ReconnectConfig
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
 }
 
-func (c natsStreamConfig) GetKeys() []string {
-	return []string{
+func (c NatsStreamConfig) GetKeys() []string {
+	keys := append([]string{
 		"name",
 		"topic",
 		"endpoint",
@@ -52,10 +70,15 @@ func (c natsStreamConfig) GetKeys() []string {
 		"user",
 		"password",
 		"token",
-	}
+		"pingInterval",
+		"pingMaxOut",
+	}, service.ReconnectKeys...)
+	keys = append(keys, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c natsStreamConfig) GetMandatoryKeys() []string {
+func (c NatsStreamConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -64,8 +87,26 @@ func (c natsStreamConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c natsStreamConfig) GetField(key string) string {
-	return natsSmap[key]
+func (c NatsStreamConfig) GetField(key string) string {
+	if field, ok := natsSmap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	if field := service.ConcurrencyField(key); field != "" {
+		return field
+	}
+	return service.ReconnectField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("nats-streaming", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewNatsStreamSub(), nil
+	})
 }
 
 func NewNatsStreamSub() *NatsStreamSubscriber {
@@ -76,79 +117,243 @@ func NewNatsStreamSub() *NatsStreamSubscriber {
 func (sub *NatsStreamSubscriber) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&sub.natsStreamConfig}
-	err = configHelper.ValidateAndSet(config)
-
+	configHelper := judoConfig.ConfigHelper{Config: &sub.NatsStreamConfig}
+	err = configHelper.Load(configs[0])
+	if err == nil {
+		err = sub.NatsStreamConfig.ValidateReconnect()
+	}
 	if err != nil {
 		return err
 	}
 
-	url := sub.natsStreamConfig.Endpoint
-	if sub.natsStreamConfig.User != "" && sub.natsStreamConfig.Password != "" {
-		url = fmt.Sprintf("%s:%s@%s", sub.natsStreamConfig.User, sub.natsStreamConfig.Password, sub.natsStreamConfig.Endpoint)
-	} else if sub.natsStreamConfig.Token != "" {
-		url = fmt.Sprintf("%s@%s", sub.natsStreamConfig.Token, sub.natsStreamConfig.Endpoint)
+	sub.url = sub.NatsStreamConfig.Endpoint
+	if sub.runtime.Retry, err = sub.NatsStreamConfig.RetryPolicy(); err != nil {
+		return err
 	}
-
-	sub.connection, err = sub.connector(url, sub.natsStreamConfig, sub.errHandler)
-	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+	sub.runtime.SetSecrets(sub.NatsStreamConfig.Password, sub.NatsStreamConfig.Token)
+	sub.connection, err = sub.connector(sub.url, sub.NatsStreamConfig, sub.errHandler)
+	err = sub.runtime.Redact(err)
+	if len(configs) == 2 && err == nil {
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.NatsStreamConfig.Name)
 	}
 
 	return err
 }
 
 func (sub *NatsStreamSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *NatsStreamSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
 func (sub *NatsStreamSubscriber) Start() (<-chan error, error) {
 
-	_, err := sub.connection.Subscribe(
-		sub.natsStreamConfig.Topic,
+	if sub.callback == nil {
+		return make(chan error), client.ErrNoCallback
+	}
+
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "nats-streaming", Topic: sub.NatsStreamConfig.Topic}
+	sub.runtime.Open()
+	sub.mu.Lock()
+	sub.errorChannel = make(chan error)
+	sub.mu.Unlock()
+
+	return sub.errorChannel, sub.runtime.Redact(sub.subscribe())
+}
+
+// subscribe uses the subscriber name as durable name, so that a new
+// subscription resumes after the last acknowledged message.
+func (sub *NatsStreamSubscriber) subscribe() error {
+	_, err := sub.conn().Subscribe(
+		sub.NatsStreamConfig.Topic,
 		sub.receive,
-		natsStream.DurableName(sub.natsStreamConfig.Name),
+		natsStream.DurableName(sub.NatsStreamConfig.Name),
 		natsStream.SetManualAckMode(),
 	)
+	return err
+}
 
-	return sub.errorChannel, err
+func (sub *NatsStreamSubscriber) conn() jmsg.RawConnection {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.connection
 }
 
-func (sub *NatsStreamSubscriber) Close() {
-	sub.connection.Close()
+// Status reports connection loss and recovery when reconnect is enabled.
+func (sub *NatsStreamSubscriber) Status() <-chan client.Status {
+	return sub.runtime.Status()
+}
+
+// Close waits for the callbacks in flight and then closes the connection.
+func (sub *NatsStreamSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.connection != nil {
+		sub.connection.Close()
+	}
+	return nil
 }
 
 func (sub *NatsStreamSubscriber) receive(msg *natsStream.Msg) {
 
-	message := jmsg.NatsStreamMessage{jmsg.NatsStreamRawMessage{msg}, sub.connection, make(map[string]string)}
-	messages := strings.Split(string(message.GetMessage()), "|")
-	if len(messages) == 4 {
-		messageString := strings.Replace(string(message.GetMessage()), messages[0]+"|", "", 1)
-		sub.deDuplifier.UniqueID = messages[0]
-		message.SetMessage([]byte(messageString))
+	env := jmsg.Decode(msg.Data)
+	msg.Data = env.Body
+	message := jmsg.NatsStreamMessage{
+		RawMessage: jmsg.NatsStreamRawMessage{Msg: msg},
+		Responder:  sub.conn(),
+		Properties: make(map[string]string),
+		Header:     env.Headers,
+		Settlement: &jmsg.Settlement{},
+	}
+	jmsg.SetEnvelopeProperties(message, env)
+	// A redelivery is the same message again, not a duplicate.
+	dedup := sub.deDuplifier
+	if msg.Redelivered {
+		dedup = nil
 	}
-	if !sub.deDuplifier.IsDuplicate() {
-		sub.callback(message)
+	if !sub.runtime.Admit(dedup, env.ID, message.Settlement) {
+		// Left unacknowledged, it would be redelivered after every AckWait.
+		message.SendAck()
+		return
 	}
+	sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(sub.errChannel(), sub.callback))
 
 }
 
+// errHandler is called by the streaming client once the connection is lost.
+// The session cannot be resumed, so it is either reported or replaced.
 func (sub *NatsStreamSubscriber) errHandler(c natsStream.Conn, reason error) {
-	sub.errorChannel <- reason
+	if sub.NatsStreamConfig.Reconnect {
+		go sub.reconnect(reason)
+		return
+	}
+	if ec := sub.errChannel(); ec != nil {
+		sub.runtime.Report(ec, reason)
+	}
+}
+
+func (sub *NatsStreamSubscriber) errChannel() chan error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.errorChannel
+}
+
+func (sub *NatsStreamSubscriber) reconnect(reason error) {
+	backoff := sub.NatsStreamConfig.Backoff()
+	sub.runtime.Notify(client.Status{State: client.Disconnected, Err: reason})
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff.Duration(attempt)):
+		case <-sub.runtime.Done():
+			return
+		}
+		sub.runtime.Notify(client.Status{State: client.Reconnecting, Attempt: attempt})
+		err := sub.redial()
+		if err == nil {
+			sub.runtime.Notify(client.Status{State: client.Connected, Attempt: attempt})
+			return
+		}
+		select {
+		case <-sub.runtime.Done():
+			return
+		default:
+		}
+		sub.runtime.Notify(client.Status{State: client.Disconnected, Attempt: attempt, Err: err})
+	}
+}
+
+// redial opens a new session and subscribes again under the same durable
+// name.
+func (sub *NatsStreamSubscriber) redial() error {
+	connection, err := sub.connector(sub.url, sub.NatsStreamConfig, sub.errHandler)
+	if err != nil {
+		return err
+	}
+
+	sub.mu.Lock()
+	select {
+	case <-sub.runtime.Done():
+		sub.mu.Unlock()
+		connection.Close()
+		return errors.New("Subscriber closed.")
+	default:
+	}
+	sub.connection.Close()
+	sub.connection = connection
+	sub.mu.Unlock()
+
+	return sub.subscribe()
 }
 
 func natsStreamConnect(url string, c judoConfig.Config, handler func(natsStream.Conn, error)) (jmsg.RawConnection, error) {
-	cfg := c.(natsStreamConfig)
-	connection, err := natsStream.Connect(cfg.Cluster,
-		cfg.Name,
-		natsStream.NatsURL("nats://"+url),
+	cfg := c.(NatsStreamConfig)
+	tlsCfg, err := cfg.ClientTLS("")
+	if err != nil {
+		return jmsg.NatsStreamRawConnection{}, err
+	}
+
+	// NATS Streaming can neither dial TLS nor take credentials other than in
+	// the URL, so it is handed a NATS connection set up like the one it
+	// would open.
+	natsOpts := []nats.Option{nats.Name(cfg.Name), nats.MaxReconnects(-1), nats.ReconnectBufSize(-1)}
+	if cfg.User != "" && cfg.Password != "" {
+		natsOpts = append(natsOpts, nats.UserInfo(cfg.User, cfg.Password))
+	} else if cfg.Token != "" {
+		natsOpts = append(natsOpts, nats.Token(cfg.Token))
+	}
+	if tlsCfg != nil {
+		natsOpts = append(natsOpts, nats.Secure(tlsCfg))
+	}
+	nc, err := nats.Connect("nats://"+url, natsOpts...)
+	if err != nil {
+		return jmsg.NatsStreamRawConnection{}, redact.Error(err, cfg.Password, cfg.Token)
+	}
+
+	opts := []natsStream.Option{
+		natsStream.NatsConn(nc),
 		natsStream.SetConnectionLostHandler(handler),
-	)
-	return jmsg.NatsStreamRawConnection{connection}, err
+	}
+	if cfg.PingInterval > 0 || cfg.PingMaxOut > 0 {
+		interval, maxOut := int((cfg.PingInterval+time.Second-1)/time.Second), cfg.PingMaxOut
+		if interval < 1 {
+			interval = natsStream.DefaultPingInterval
+		}
+		if maxOut < 1 {
+			maxOut = natsStream.DefaultPingMaxOut
+		}
+		opts = append(opts, natsStream.Pings(interval, maxOut))
+	}
+	connection, err := natsStream.Connect(cfg.Cluster, cfg.Name, opts...)
+	if err != nil {
+		nc.Close()
+		return jmsg.NatsStreamRawConnection{}, redact.Error(err, cfg.Password, cfg.Token)
+	}
+	return jmsg.NatsStreamRawConnection{Conn: connection, Nats: nc}, nil
+}
+
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *NatsStreamSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
+
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *NatsStreamSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *NatsStreamSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *NatsStreamSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/amagimedia/judo/v3/client"
 	"github.com/amagimedia/judo/v3/config"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
@@ -148,7 +149,22 @@ func TestNatsStreamSubscriber(t *testing.T) {
 			"err-conn",
 			errors.New("Disconnected, from server for dqi50n_agent"),
 		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":              "dqi50n_agent",
+					"cluster":           "test",
+					"topic":             "dqi50n.out",
+					"endpoint":          "localhost:3234",
+					"reconnect":         true,
+					"reconnectInterval": 10,
+				},
+			},
+			"reconnect",
+			errors.New("stan: connection lost"),
+		},
 	}
+
 	for _, c := range cases {
 		switch c.retVal {
 		case "success-cfg-usr":
@@ -182,6 +198,7 @@ func TestNatsStreamSubscriber(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("Subscribe", "dqi50n.out", mock.Anything, mock.AnythingOfType("stan.SubscriptionOption"), mock.AnythingOfType("stan.SubscriptionOption")).Return(&mocks.Subscription{}, nil).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err != nil {
 				t.Error("UnExpected Error")
@@ -193,13 +210,13 @@ func TestNatsStreamSubscriber(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("Subscribe", "dqi50n.out", mock.Anything, mock.AnythingOfType("stan.SubscriptionOption"), mock.AnythingOfType("stan.SubscriptionOption")).Return(&mocks.Subscription{}, c.retType).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("UnExpected Type of Error")
 			}
 		case "err-conn":
-			ec := make(chan error)
-			fakeSubscriber = &NatsStreamSubscriber{connector: connector, errorChannel: ec}
+			fakeSubscriber = &NatsStreamSubscriber{connector: connector}
 			called := false
 			fakeSubscriber.OnMessage(func(message.Message) {
 				called = true
@@ -209,7 +226,7 @@ func TestNatsStreamSubscriber(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("Subscribe", "dqi50n.out", mock.Anything, mock.AnythingOfType("stan.SubscriptionOption"), mock.AnythingOfType("stan.SubscriptionOption")).Return(&mocks.Subscription{}, nil).Once()
-			_, err = fakeSubscriber.Start()
+			ec, err := fakeSubscriber.Start()
 			go func() {
 				fakeSubscriber.receive(&stan.Msg{})
 				time.Sleep(time.Millisecond * 100)
@@ -225,6 +242,56 @@ func TestNatsStreamSubscriber(t *testing.T) {
 			}
 			fakeConn.On("Close").Return(nil)
 			fakeSubscriber.Close()
+		case "reconnect":
+			first, second := &mocks.RawConnection{}, &mocks.RawConnection{}
+			dials := 0
+			rSubscriber := &NatsStreamSubscriber{connector: func(url string, c config.Config, h func(stan.Conn, error)) (message.RawConnection, error) {
+				dials++
+				switch dials {
+				case 1:
+					return first, nil
+				case 2:
+					return nil, errors.New("Cannot Create connection, Server not found")
+				}
+				return second, nil
+			}}
+			err := rSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error Unexpected" + err.Error())
+			}
+			for _, conn := range []*mocks.RawConnection{first, second} {
+				conn.On("Subscribe", "dqi50n.out", mock.Anything, mock.AnythingOfType("stan.SubscriptionOption"), mock.AnythingOfType("stan.SubscriptionOption")).Return(&mocks.Subscription{}, nil).Once()
+				conn.On("Close").Return(nil).Once()
+			}
+			rSubscriber.OnMessage(func(message.Message) {})
+			ec, err := rSubscriber.Start()
+			if err != nil {
+				t.Error("UnExpected Error")
+			}
+			rSubscriber.errHandler(&mocks.Conn{}, c.retType)
+
+			expected := []client.Status{
+				{State: client.Disconnected, Attempt: 0, Err: c.retType},
+				{State: client.Reconnecting, Attempt: 1, Err: nil},
+				{State: client.Disconnected, Attempt: 1, Err: errors.New("Cannot Create connection, Server not found")},
+				{State: client.Reconnecting, Attempt: 2, Err: nil},
+				{State: client.Connected, Attempt: 2, Err: nil},
+			}
+			for _, want := range expected {
+				select {
+				case got := <-rSubscriber.Status():
+					if got.State != want.State || got.Attempt != want.Attempt || (want.Err == nil) != (got.Err == nil) {
+						t.Errorf("Unexpected status, expected %v got %v", want, got)
+					}
+				case err := <-ec:
+					t.Fatal("Connection loss reported as error while reconnecting", err)
+				case <-time.After(time.Second):
+					t.Fatal("Timed out waiting for status", want.State)
+				}
+			}
+			rSubscriber.Close()
+			first.AssertExpectations(t)
+			second.AssertExpectations(t)
 		}
 	}
 }
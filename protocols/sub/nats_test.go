@@ -5,8 +5,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/amagimedia/judo/v3/client"
 	"github.com/amagimedia/judo/v3/message"
 	"github.com/amagimedia/judo/v3/message/mocks"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/service"
 	nats "github.com/nats-io/go-nats"
 	"github.com/stretchr/testify/mock"
 )
@@ -14,15 +17,16 @@ import (
 func TestNatsSubscriber(t *testing.T) {
 	fakeConn := &mocks.RawConnection{}
 
-	connector := func(url string) (message.RawConnection, error) {
+	connector := func(url string, opts []nats.Option) (message.RawConnection, error) {
 		return fakeConn, nil
 	}
 
-	_ = func(url string) (message.RawConnection, error) {
+	_ = func(url string, opts []nats.Option) (message.RawConnection, error) {
 		return fakeConn, errors.New("Cannot Create connection, Server not found")
 	}
 
 	fakeSubscriber := &NatsSubscriber{connector: connector}
+	maxReconnects := 5
 
 	cases := []struct {
 		config  []interface{}
@@ -108,6 +112,62 @@ func TestNatsSubscriber(t *testing.T) {
 			"err-conn",
 			errors.New("Disconnected, from server for dqi50n_agent"),
 		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":     "dqi50n_agent",
+					"topic":    "dqi50n.out",
+					"endpoint": "localhost:3234",
+				},
+			},
+			"panic",
+			errors.New("Recovered from panic in callback: boom"),
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":     "dqi50n_agent",
+					"topic":    "dqi50n.out",
+					"endpoint": "localhost:3234",
+				},
+				map[string]interface{}{
+					"backend": "memory",
+				},
+			},
+			"metrics",
+			nil,
+		},
+		{
+			[]interface{}{
+				NatsConfig{Name: "dqi50n_agent", Topic: "dqi50n.out", Endpoint: "localhost:3234", MaxReconnects: &maxReconnects},
+				service.DedupConfig{Backend: "memory", TTL: time.Minute},
+			},
+			"success-cfg-typed",
+			nil,
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":          "dqi50n_agent",
+					"topic":         "dqi50n.out",
+					"endpoint":      "localhost:3234",
+					"maxReconnects": 0,
+				},
+			},
+			"no-reconnect",
+			nil,
+		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":     "dqi50n_agent",
+					"topic":    "dqi50n.out",
+					"endpoint": "localhost:3234",
+				},
+			},
+			"no-callback",
+			client.ErrNoCallback,
+		},
 	}
 	for _, c := range cases {
 		switch c.retVal {
@@ -123,6 +183,32 @@ func TestNatsSubscriber(t *testing.T) {
 			if err != nil {
 				t.Error("Unexpected config failure")
 			}
+		case "success-cfg-typed":
+			fakeSubscriber = &NatsSubscriber{connector: connector}
+			err := fakeSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Unexpected config failure", err)
+			}
+			if fakeSubscriber.NatsConfig.Topic != "dqi50n.out" || *fakeSubscriber.MaxReconnects != 5 {
+				t.Error("Typed config not applied", fakeSubscriber.NatsConfig)
+			}
+			if _, ok := fakeSubscriber.deDuplifier.(*service.MemoryDedup); !ok {
+				t.Error("Typed dedup config not applied")
+			}
+		case "no-reconnect":
+			options := nats.GetDefaultOptions()
+			fakeSubscriber = &NatsSubscriber{connector: func(url string, opts []nats.Option) (message.RawConnection, error) {
+				for _, opt := range opts {
+					opt(&options)
+				}
+				return fakeConn, nil
+			}}
+			if err := fakeSubscriber.Configure(c.config); err != nil {
+				t.Error("Unexpected config failure", err)
+			}
+			if options.MaxReconnect != 0 {
+				t.Error("Reconnects not disabled", options.MaxReconnect)
+			}
 		case "success-cfg-noauth":
 			fakeSubscriber = &NatsSubscriber{connector: connector}
 			err := fakeSubscriber.Configure(c.config)
@@ -142,10 +228,25 @@ func TestNatsSubscriber(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err != nil {
 				t.Error("UnExpected Error")
 			}
+		case "no-callback":
+			conn := &mocks.RawConnection{}
+			fakeSubscriber = &NatsSubscriber{connector: func(string, []nats.Option) (message.RawConnection, error) {
+				return conn, nil
+			}}
+			err := fakeSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error Unexpected" + err.Error())
+			}
+			_, err = fakeSubscriber.Start()
+			if err != c.retType {
+				t.Error("Started without a callback", err)
+			}
+			conn.AssertNotCalled(t, "ChanSubscribe", "dqi50n.out", mock.Anything)
 		case "err-start":
 			fakeSubscriber = &NatsSubscriber{connector: connector}
 			err := fakeSubscriber.Configure(c.config)
@@ -153,14 +254,90 @@ func TestNatsSubscriber(t *testing.T) {
 				t.Error("Error Unexpected" + err.Error())
 			}
 			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, c.retType).Once()
+			fakeSubscriber.OnMessage(func(message.Message) {})
 			_, err = fakeSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("UnExpected Type of Error")
 			}
+		case "panic":
+			ch := make(chan *nats.Msg)
+			fakeSubscriber = &NatsSubscriber{connector: connector, msgQueue: ch}
+			received := make(chan string, 2)
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				received <- string(msg.GetMessage())
+				if string(msg.GetMessage()) == "first" {
+					panic("boom")
+				}
+			})
+			err := fakeSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error Unexpected" + err.Error())
+			}
+			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil).Once()
+			fakeConn.On("Publish", "", mock.MatchedBy(func(data []byte) bool {
+				_, nack := message.ParseReply(data)
+				return nack
+			})).Return(nil).Once()
+			ec, _ := fakeSubscriber.Start()
+			ch <- &nats.Msg{Data: []byte("first")}
+			ch <- &nats.Msg{Data: []byte("second")}
+			select {
+			case err = <-ec:
+				if _, ok := err.(*client.PanicError); !ok || err.Error() != c.retType.Error() {
+					t.Error("Unexpected Type of Error", err)
+				}
+			case <-time.After(time.Second):
+				t.Error("Panic not reported")
+			}
+			for _, want := range []string{"first", "second"} {
+				select {
+				case got := <-received:
+					if got != want {
+						t.Errorf("Expected %s, got %s", want, got)
+					}
+				case <-time.After(time.Second):
+					t.Error("Subscriber stopped after a panic")
+				}
+			}
+			fakeConn.On("Close").Return(nil)
+			fakeSubscriber.Close()
+		case "metrics":
+			ch := make(chan *nats.Msg)
+			fakeSubscriber = &NatsSubscriber{connector: connector, msgQueue: ch}
+			received := make(chan struct{}, 2)
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				msg.SendAck()
+				received <- struct{}{}
+			})
+			err := fakeSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Error Unexpected" + err.Error())
+			}
+			reg := metrics.NewRegistry()
+			fakeSubscriber.SetMetrics(reg)
+			fakeConn.On("ChanSubscribe", "dqi50n.out", mock.Anything).Return(&nats.Subscription{}, nil).Once()
+			fakeConn.On("Publish", "", []byte("OK")).Return(nil).Twice()
+			fakeSubscriber.Start()
+			first := message.Encode(message.NewEnvelope([]byte("first")))
+			ch <- &nats.Msg{Data: first}
+			ch <- &nats.Msg{Data: first}
+			ch <- &nats.Msg{Data: message.Encode(message.NewEnvelope([]byte("second")))}
+			for i := 0; i < 2; i++ {
+				select {
+				case <-received:
+				case <-time.After(time.Second):
+					t.Error("Message not delivered")
+				}
+			}
+			fakeConn.On("Close").Return(nil)
+			fakeSubscriber.Close()
+			labels := metrics.Labels{Protocol: "nats", Topic: "dqi50n.out"}
+			if reg.Counter(metrics.Received, labels) != 3 || reg.Counter(metrics.Duplicates, labels) != 1 || reg.Counter(metrics.Acked, labels) != 2 {
+				t.Error("Unexpected metrics for the subscriber")
+			}
 		case "err-conn":
 			ch := make(chan *nats.Msg)
-			rcv := func() <-chan *nats.Msg { return ch }
-			fakeSubscriber = &NatsSubscriber{connector: connector, msgQueue: rcv()}
+			fakeSubscriber = &NatsSubscriber{connector: connector, msgQueue: ch}
 			called := false
 			fakeSubscriber.OnMessage(func(message.Message) {
 				called = true
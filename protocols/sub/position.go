@@ -0,0 +1,89 @@
+package sub
+
+import (
+	"math"
+	"sync"
+)
+
+// positions follows the sequence numbers of the messages in flight, so that
+// the position Redis and PubNub subscribers persist never passes a message
+// that some worker has not finished yet.
+type positions struct {
+	mu       sync.Mutex
+	inflight map[int64]int
+	finished []int64
+	safe     int64
+
+	saving sync.Mutex
+	saved  int64
+}
+
+// resume starts from pos, the position loaded on start, unless the
+// subscriber already got further.
+func (p *positions) resume(pos int64) {
+	p.saving.Lock()
+	defer p.saving.Unlock()
+	if pos > p.saved {
+		p.saved = pos
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pos > p.safe {
+		p.safe = pos
+	}
+}
+
+// start marks the message at pos as in flight.
+func (p *positions) start(pos int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inflight == nil {
+		p.inflight = make(map[int64]int)
+	}
+	p.inflight[pos]++
+}
+
+// finish marks the message at pos as done.
+func (p *positions) finish(pos int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inflight[pos]--; p.inflight[pos] <= 0 {
+		delete(p.inflight, pos)
+	}
+	p.finished = append(p.finished, pos)
+
+	lowest := int64(math.MaxInt64)
+	for q := range p.inflight {
+		if q < lowest {
+			lowest = q
+		}
+	}
+	pending := p.finished[:0]
+	for _, q := range p.finished {
+		if q >= lowest {
+			pending = append(pending, q)
+		} else if q > p.safe {
+			p.safe = q
+		}
+	}
+	p.finished = pending
+}
+
+// save calls write with the highest position up to which every message is
+// done, unless it was already saved. Saves run one at a time, so that the
+// saved position never goes back.
+func (p *positions) save(write func(int64) error) error {
+	p.saving.Lock()
+	defer p.saving.Unlock()
+	p.mu.Lock()
+	pos := p.safe
+	p.mu.Unlock()
+	if pos <= p.saved {
+		return nil
+	}
+	if err := write(pos); err != nil {
+		return err
+	}
+	p.saved = pos
+	return nil
+}
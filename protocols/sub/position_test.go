@@ -0,0 +1,43 @@
+package sub
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	var saved []int64
+	write := func(pos int64) error {
+		saved = append(saved, pos)
+		return nil
+	}
+	p := &positions{}
+	p.resume(10)
+	for _, pos := range []int64{11, 12, 13} {
+		p.start(pos)
+	}
+
+	// 12 and 13 finish first, while 11 still runs on another worker.
+	p.finish(13)
+	p.finish(12)
+	p.save(write)
+	if len(saved) != 0 {
+		t.Error("Position saved past a message in flight", saved)
+	}
+	p.finish(11)
+	p.save(write)
+	p.save(write)
+	if len(saved) != 1 || saved[0] != 13 {
+		t.Error("Expected 13 saved once, got", saved)
+	}
+
+	p.start(14)
+	p.finish(14)
+	if err := p.save(func(int64) error { return errors.New("disk full") }); err == nil {
+		t.Error("Write error not returned")
+	}
+	p.save(write)
+	if saved[len(saved)-1] != 14 {
+		t.Error("Failed save not retried", saved)
+	}
+}
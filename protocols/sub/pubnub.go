@@ -11,13 +11,15 @@ import (
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/service"
-	gredis "github.com/go-redis/redis"
 	pubnub "github.com/pubnub/go"
 )
 
-type pubnubConnector func(pubnubConfig) (jmsg.RawPubnubClient, error)
+type pubnubConnector func(PubnubConfig) (jmsg.RawPubnubClient, error)
 
 var pubnubmap = map[string]string{
 	"name":          "Name",
@@ -31,14 +33,15 @@ var pubnubmap = map[string]string{
 type PubnubSubscriber struct {
 	connector  pubnubConnector
 	connection jmsg.RawPubnubClient //pubnub.Client
-	pubnubConfig
-	callback        func(jmsg.Message)
-	processChannel  chan *jmsg.PubnubMessage
-	lastMessageTime int64
-	deDuplifier     service.Duplicate
+	PubnubConfig
+	callback       func(jmsg.Message)
+	processChannel chan *jmsg.PubnubMessage
+	positions      positions
+	deDuplifier    service.Deduplicator
+	runtime        service.Runtime
 }
 
-type pubnubConfig struct {
+type PubnubConfig struct {
 	Name         string
 	Topic        string
 	SubscribeKey string
@@ -46,20 +49,23 @@ type pubnubConfig struct {
 	SecretKey    string
 	Persistence  bool
 	FileName     string
+	service.ConcurrencyConfig
+	service.RetryConfig
 }
 
-func (c pubnubConfig) GetKeys() []string {
-	return []string{
+func (c PubnubConfig) GetKeys() []string {
+	keys := append([]string{
 		"name",
 		"topic",
 		"secret_key",
 		"subscribe_key",
 		"publish_key",
 		"persistence",
-	}
+	}, service.ConcurrencyKeys...)
+	return append(keys, service.RetryKeys...)
 }
 
-func (c pubnubConfig) GetMandatoryKeys() []string {
+func (c PubnubConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -69,8 +75,20 @@ func (c pubnubConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c pubnubConfig) GetField(key string) string {
-	return pubnubmap[key]
+func (c PubnubConfig) GetField(key string) string {
+	if field, ok := pubnubmap[key]; ok {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	return service.ConcurrencyField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("pubnub", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewPubnubSub(), nil
+	})
 }
 
 func NewPubnubSub() *PubnubSubscriber {
@@ -81,50 +99,69 @@ func NewPubnubSub() *PubnubSubscriber {
 func (sub *PubnubSubscriber) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&sub.pubnubConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &sub.PubnubConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
-	err = validatePubnubConfig(sub.pubnubConfig)
+	err = validatePubnubConfig(sub.PubnubConfig)
 	if err != nil {
 		return err
 	}
-	sub.pubnubConfig.FileName = strings.Replace(sub.pubnubConfig.Topic, "/", "", -1)
+	sub.PubnubConfig.FileName = strings.Replace(sub.PubnubConfig.Topic, "/", "", -1)
+	if sub.runtime.Retry, err = sub.PubnubConfig.RetryPolicy(); err != nil {
+		return err
+	}
+	sub.runtime.SetSecrets(sub.PubnubConfig.SecretKey)
 	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.PubnubConfig.Name)
 	}
 
 	return err
 }
 
-func validatePubnubConfig(cfg pubnubConfig) error {
+func validatePubnubConfig(cfg PubnubConfig) error {
 	if cfg.SubscribeKey == "" {
 		return errors.New("Subscribe Key Missing")
 	}
 	return nil
 }
 
-func (sub *PubnubSubscriber) Close() {
-	sub.connection.Destroy(sub.pubnubConfig.Topic)
+// Close waits for the callbacks in flight and then unsubscribes.
+func (sub *PubnubSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	sub.unsubscribe()
+	return nil
+}
+
+func (sub *PubnubSubscriber) unsubscribe() {
+	if sub.connection != nil {
+		sub.connection.Destroy(sub.PubnubConfig.Topic)
+	}
 }
 
 func (sub *PubnubSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *PubnubSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
 func (sub *PubnubSubscriber) Start() (<-chan error, error) {
 	var err error
 	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
 	sub.processChannel = make(chan *jmsg.PubnubMessage)
 
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "pubnub", Topic: sub.PubnubConfig.Topic}
+	sub.runtime.Open()
 	go sub.receive(errorChannel)
 
 	go sub.handleMessage(errorChannel)
@@ -145,6 +182,7 @@ func (sub *PubnubSubscriber) subscribeLoop() bool {
 				connected = true
 			case pubnub.PNReconnectedCategory:
 			case pubnub.PNUnknownCategory:
+				sub.runtime.Log().Info("Resubscribing after unknown status", "category", status.Category)
 				return true
 			case pubnub.PNDisconnectedCategory:
 				fallthrough
@@ -161,8 +199,11 @@ func (sub *PubnubSubscriber) subscribeLoop() bool {
 			case pubnub.PNRequestMessageCountExceededCategory:
 				fallthrough
 			default:
+				sub.runtime.Log().Warn("Subscription stopped", "category", status.Category)
 				return false
 			}
+		case <-sub.runtime.Done():
+			return false
 		case message, ok := <-listener.Message:
 			if !connected {
 				continue
@@ -170,9 +211,9 @@ func (sub *PubnubSubscriber) subscribeLoop() bool {
 			if !ok {
 				return false
 			}
-			sub.processChannel <- sub.calcTimestamp(message.Timetoken, message.Message)
-			err := sub.setLastTime()
-			if err != nil {
+			select {
+			case sub.processChannel <- sub.calcTimestamp(message.Timetoken, message.Message):
+			case <-sub.runtime.Done():
 				return false
 			}
 		}
@@ -189,65 +230,80 @@ func (sub *PubnubSubscriber) receive(ec chan error) {
 	// Other errors will cause exit
 	for {
 
-		loadErr := sub.loadLastTime()
-		sub.connection, err = sub.connector(sub.pubnubConfig)
+		lastTime, loadErr := sub.loadLastTime()
+		if loadErr == nil {
+			sub.positions.resume(lastTime)
+		}
+		sub.connection, err = sub.connector(sub.PubnubConfig)
 		if err != nil {
-			ec <- err
+			sub.runtime.Report(ec, err)
 			return
 		}
 
-		if sub.pubnubConfig.Persistence && loadErr == nil {
-			go sub.getMissingMessages()
+		if sub.PubnubConfig.Persistence && loadErr == nil {
+			go sub.getMissingMessages(lastTime)
+		} else if sub.PubnubConfig.Persistence {
+			sub.runtime.Log().Warn("Unable to load last message time, not fetching missed messages", "err", loadErr)
 		}
 
-		sub.connection.Subscribe(sub.pubnubConfig.Topic)
+		sub.connection.Subscribe(sub.PubnubConfig.Topic)
 		status := sub.subscribeLoop()
+		sub.unsubscribe()
 		if !status {
-			sub.Close()
 			break
 		}
-		sub.Close()
 
 	}
 
-	ec <- fmt.Errorf("Subscriber listener closed. Exiting")
+	sub.runtime.Report(ec, fmt.Errorf("Subscriber listener closed. Exiting"))
 	return
 }
 
 func (sub *PubnubSubscriber) handleMessage(ec chan error) {
 	for message := range sub.processChannel {
-		messages := strings.Split(string(message.GetMessage()), "|")
-		if len(messages) == 4 {
-			messageString := strings.Replace(string(message.GetMessage()), messages[0]+"|", "", 1)
-			sub.deDuplifier.UniqueID = messages[0]
-			message.SetMessage([]byte(messageString))
-		}
-		if !sub.deDuplifier.IsDuplicate() {
-			message.SetProperty("channel", sub.pubnubConfig.Topic)
-			sub.callback(message)
-			if val, ok := message.GetProperty("ack"); ok && val == "OK" {
-				err := sub.setLastTime()
-				if err != nil {
-					break
-				}
+		id, _ := message.GetProperty("message_id")
+		if sub.runtime.Admit(sub.deDuplifier, id, message.Settlement) {
+			message.SetProperty("channel", sub.PubnubConfig.Topic)
+			sub.positions.start(message.RawMessage.GetTimetoken())
+			message.Settlement.OnDone(sub.settled(message))
+			if !sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(ec, sub.callback)) {
+				return
 			}
 		}
 	}
 	sub.Close()
 }
 
-func (sub *PubnubSubscriber) getMissingMessages() {
+// settled returns the function run once the subscriber is done with
+// message, which persists the position when message was acknowledged. With
+// several workers, that is the timetoken of the newest message such that all
+// the older ones are done. A failure to persist closes the subscriber.
+func (sub *PubnubSubscriber) settled(message *jmsg.PubnubMessage) func() {
+	return func() {
+		sub.positions.finish(message.RawMessage.GetTimetoken())
+		if val, ok := message.GetProperty("ack"); ok && val == "OK" {
+			if err := sub.positions.save(sub.setLastTime); err != nil {
+				sub.runtime.Log().Error("Unable to persist last message time, closing subscriber", "err", err)
+				go sub.Close()
+			}
+		}
+	}
+}
+
+func (sub *PubnubSubscriber) getMissingMessages(lastTime int64) {
 	for true {
-		messages, err := sub.connection.FetchHistory(sub.pubnubConfig.Topic, true, sub.lastMessageTime, true, 100)
+		messages, err := sub.connection.FetchHistory(sub.PubnubConfig.Topic, true, lastTime, true, 100)
 		if err != nil {
+			sub.runtime.Log().Error("Unable to fetch missed messages", "err", err)
 			return
 		}
 		for _, m := range messages {
-			sub.processChannel <- sub.calcTimestamp(m.Timetoken, m.Message)
-			err := sub.setLastTime()
-			if err != nil {
-				break
+			select {
+			case sub.processChannel <- sub.calcTimestamp(m.Timetoken, m.Message):
+			case <-sub.runtime.Done():
+				return
 			}
+			lastTime = m.Timetoken
 		}
 
 		if len(messages) != 100 {
@@ -257,35 +313,44 @@ func (sub *PubnubSubscriber) getMissingMessages() {
 }
 
 func (sub *PubnubSubscriber) calcTimestamp(timetoken int64, msg interface{}) *jmsg.PubnubMessage {
-	sub.lastMessageTime = timetoken
-	return &jmsg.PubnubMessage{jmsg.PubnubRawMessage{&pubnub.PNMessage{Message: msg, Timetoken: timetoken}}, sub.connection, make(map[string]string)}
+	message := &jmsg.PubnubMessage{
+		RawMessage: jmsg.PubnubRawMessage{Message: &pubnub.PNMessage{Message: msg, Timetoken: timetoken}},
+		Responder:  sub.connection,
+		Properties: make(map[string]string),
+		Header:     jmsg.PubnubHeader(msg),
+		Settlement: &jmsg.Settlement{},
+	}
+	env := jmsg.Decode(message.GetMessage())
+	if env.ID != "" || len(env.Headers) != 0 {
+		message.SetMessage(env.Body)
+	}
+	for key, val := range env.Headers {
+		message.SetHeader(key, val)
+	}
+	jmsg.SetEnvelopeProperties(message, env)
+	return message
 }
 
-func (sub *PubnubSubscriber) loadLastTime() error {
+func (sub *PubnubSubscriber) loadLastTime() (int64, error) {
 	persistencePath := sub.getPersistenceFilePath()
 	if persistencePath == "" {
-		return fmt.Errorf("Unable to find path to write persistence data.")
+		return 0, fmt.Errorf("Unable to find path to write persistence data.")
 	}
 
 	data, err := ioutil.ReadFile(persistencePath)
 	if err != nil {
-		return err
-	}
-	t, err := strconv.ParseInt(string(data), 10, 64)
-	if err != nil {
-		return err
+		return 0, err
 	}
-	sub.lastMessageTime = t
-	return nil
+	return strconv.ParseInt(string(data), 10, 64)
 }
 
-func (sub *PubnubSubscriber) setLastTime() error {
+func (sub *PubnubSubscriber) setLastTime(lastTime int64) error {
 	persistencePath := sub.getPersistenceFilePath()
 	if persistencePath == "" {
 		return fmt.Errorf("Unable to find path to write persistence data.")
 	}
 
-	err := ioutil.WriteFile(persistencePath, []byte(strconv.FormatInt(sub.lastMessageTime, 10)), 0755)
+	err := ioutil.WriteFile(persistencePath, []byte(strconv.FormatInt(lastTime, 10)), 0755)
 	if err != nil {
 		return err
 	}
@@ -293,7 +358,7 @@ func (sub *PubnubSubscriber) setLastTime() error {
 }
 
 func (sub *PubnubSubscriber) getPersistenceFilePath() string {
-	filename := ".agent_msg_time." + sub.pubnubConfig.FileName
+	filename := ".agent_msg_time." + sub.PubnubConfig.FileName
 	folder := "/tmp/pubnub/"
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
 		os.Mkdir(folder, 0770)
@@ -301,7 +366,7 @@ func (sub *PubnubSubscriber) getPersistenceFilePath() string {
 	return folder + filename
 }
 
-func pubnubConnect(cfg pubnubConfig) (jmsg.RawPubnubClient, error) {
+func pubnubConnect(cfg PubnubConfig) (jmsg.RawPubnubClient, error) {
 	config := pubnub.NewConfig()
 	config.SubscribeKey = cfg.SubscribeKey
 	config.PublishKey = cfg.PublishKey
@@ -314,3 +379,24 @@ func pubnubConnect(cfg pubnubConfig) (jmsg.RawPubnubClient, error) {
 
 	return jmsg.PubnubRawClient{Client: pubnub.NewPubNub(config), Listener: pubnub.NewListener()}, nil
 }
+
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *PubnubSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
+
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *PubnubSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *PubnubSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *PubnubSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
+}
@@ -14,7 +14,7 @@ import (
 func TestPubnubSubscriber(t *testing.T) {
 	fakeClient := &mocks.PubnubRawClient{}
 
-	connector := func(cfg pubnubConfig) (message.RawPubnubClient, error) {
+	connector := func(cfg PubnubConfig) (message.RawPubnubClient, error) {
 		return fakeClient, nil
 	}
 
@@ -153,7 +153,7 @@ func TestPubnubSubscriber(t *testing.T) {
 			fakeClient.On("Destroy", mock.AnythingOfType("string")).Return(nil)
 			fakeSubscriber.Close()
 		case "dial-err":
-			connector := func(cfg pubnubConfig) (message.RawPubnubClient, error) {
+			connector := func(cfg PubnubConfig) (message.RawPubnubClient, error) {
 				return fakeClient, c.retType
 			}
 			fSubscriber := &PubnubSubscriber{connector: connector}
@@ -168,6 +168,7 @@ func TestPubnubSubscriber(t *testing.T) {
 			fakeClient.On("Subscribe", mock.AnythingOfType("string")).Return(nil)
 			fakeClient.On("FetchHistory", mock.AnythingOfType("string"), mock.AnythingOfType("bool"), mock.AnythingOfType("int64"), mock.AnythingOfType("bool"), mock.AnythingOfType("int")).Return(make([]*pubnub.PNMessage, 0))
 			fakeClient.On("Destroy", mock.AnythingOfType("string")).Return(nil)
+			fSubscriber.OnMessage(func(message.Message) {})
 			ec, err := fSubscriber.Start()
 			er := <-ec
 			if er == nil || er.Error() != c.retType.Error() {
@@ -177,7 +178,7 @@ func TestPubnubSubscriber(t *testing.T) {
 			fClient := &mocks.PubnubRawClient{}
 			ch := make(chan *pubnub.PNMessage)
 			st := make(chan *pubnub.PNStatus)
-			cr := func(cfg pubnubConfig) (message.RawPubnubClient, error) {
+			cr := func(cfg PubnubConfig) (message.RawPubnubClient, error) {
 				return fClient, nil
 			}
 
@@ -1,7 +1,6 @@
 package sub
 
 import (
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,20 +9,22 @@ import (
 
 	"github.com/amagimedia/judo/v3/client"
 	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
 	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/registry"
 	"github.com/amagimedia/judo/v3/scripts"
 	"github.com/amagimedia/judo/v3/service"
 	gredis "github.com/go-redis/redis"
 )
 
-type redisConnector func(redisConfig) (jmsg.RawClient, error)
+type redisConnector func(RedisConfig) (jmsg.RawClient, error)
 
 var redismap = map[string]string{
 	"name":        "Name",
 	"topic":       "Topic",
 	"endpoint":    "Endpoint",
 	"password":    "Password",
-	"tls":         "Tls",
 	"separator":   "Separator",
 	"persistence": "Persistence",
 }
@@ -31,37 +32,41 @@ var redismap = map[string]string{
 type RedisSubscriber struct {
 	connector  redisConnector
 	connection jmsg.RawClient //gredis.Client
-	redisConfig
-	callback        func(jmsg.Message)
-	processChannel  chan *jmsg.RedisMessage
-	lastMessageTime int64
-	deDuplifier     service.Duplicate
+	RedisConfig
+	callback       func(jmsg.Message)
+	processChannel chan *jmsg.RedisMessage
+	positions      positions
+	deDuplifier    service.Deduplicator
+	runtime        service.Runtime
 }
 
-type redisConfig struct {
+type RedisConfig struct {
 	Name        string
 	Topic       string
 	Endpoint    string
 	Password    string
-	Tls         bool
 	Separator   string
 	Persistence bool
 	FileName    string
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
 }
 
-func (c redisConfig) GetKeys() []string {
-	return []string{
+func (c RedisConfig) GetKeys() []string {
+	keys := append([]string{
 		"name",
 		"topic",
 		"endpoint",
 		"password",
-		"tls",
 		"separator",
 		"persistence",
-	}
+	}, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
 }
 
-func (c redisConfig) GetMandatoryKeys() []string {
+func (c RedisConfig) GetMandatoryKeys() []string {
 	return []string{
 		"name",
 		"topic",
@@ -70,8 +75,23 @@ func (c redisConfig) GetMandatoryKeys() []string {
 	}
 }
 
-func (c redisConfig) GetField(key string) string {
-	return redismap[key]
+func (c RedisConfig) GetField(key string) string {
+	if field, ok := redismap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	return service.ConcurrencyField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("redis", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewRedisSub(), nil
+	})
 }
 
 func NewRedisSub() *RedisSubscriber {
@@ -82,61 +102,81 @@ func NewRedisSub() *RedisSubscriber {
 func (sub *RedisSubscriber) Configure(configs []interface{}) error {
 
 	var err error
-	config := configs[0].(map[string]interface{})
-	configHelper := judoConfig.ConfigHelper{&sub.redisConfig}
-	err = configHelper.ValidateAndSet(config)
+	configHelper := judoConfig.ConfigHelper{Config: &sub.RedisConfig}
+	err = configHelper.Load(configs[0])
 	if err != nil {
 		return err
 	}
-	sub.redisConfig.FileName = strings.Replace(sub.redisConfig.Topic, "/", "", -1)
+	sub.RedisConfig.FileName = strings.Replace(sub.RedisConfig.Topic, "/", "", -1)
+	if sub.runtime.Retry, err = sub.RedisConfig.RetryPolicy(); err != nil {
+		return err
+	}
+	sub.runtime.SetSecrets(sub.RedisConfig.Password)
 	if len(configs) == 2 {
-		redisConfig := configs[1].(map[string]interface{})
-		sub.deDuplifier.RedisConn = gredis.NewClient(&gredis.Options{
-			Addr:     redisConfig["endpoint"].(string),
-			Password: redisConfig["password"].(string),
-		})
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.RedisConfig.Name)
 	}
 
 	return err
 }
 
-func (sub *RedisSubscriber) Close() {
-	sub.connection.Close()
+// Close waits for the callbacks in flight and then closes the connection.
+func (sub *RedisSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	if sub.connection == nil {
+		return nil
+	}
+	return sub.connection.Close()
 }
 
 func (sub *RedisSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
-	sub.callback = callback
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
 	return sub
 }
 
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *RedisSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
 func (sub *RedisSubscriber) Start() (<-chan error, error) {
 
 	var err error
 	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
 
 	sub.processChannel = make(chan *jmsg.RedisMessage)
 
-	loadErr := sub.loadLastTime()
+	lastTime, loadErr := sub.loadLastTime()
+	if loadErr == nil {
+		sub.positions.resume(lastTime)
+	}
 
-	sub.connection, err = sub.connector(sub.redisConfig)
+	sub.connection, err = sub.connector(sub.RedisConfig)
 	if err != nil {
-		return errorChannel, err
+		return errorChannel, sub.runtime.Redact(err)
 	}
 
 	for _, script := range scripts.SHAtoCode {
 		res := sub.connection.ScriptLoad(script)
 		if res.Err() != nil {
-			return errorChannel, res.Err()
+			return errorChannel, sub.runtime.Redact(res.Err())
 		}
 	}
 
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "redis", Topic: sub.RedisConfig.Topic}
+	sub.runtime.Open()
 	go sub.handleMessage(errorChannel)
 
 	go sub.receive(errorChannel)
 
 	// If persistence is true then retrieve older messages on restart.
-	if sub.redisConfig.Persistence && loadErr == nil {
-		go sub.getMissingMessages()
+	if sub.RedisConfig.Persistence && loadErr == nil {
+		go sub.getMissingMessages(lastTime)
+	} else if sub.RedisConfig.Persistence {
+		sub.runtime.Log().Warn("Unable to load last message time, not fetching missed messages", "err", loadErr)
 	}
 
 	return errorChannel, err
@@ -145,78 +185,102 @@ func (sub *RedisSubscriber) Start() (<-chan error, error) {
 func (sub *RedisSubscriber) receive(ec chan error) {
 	recvChannel := sub.connection.Channel()
 	for msg := range recvChannel {
-		sub.processChannel <- sub.calcTimestamp(msg.Channel, msg.Pattern, msg.Payload)
+		select {
+		case sub.processChannel <- sub.calcTimestamp(msg.Channel, msg.Pattern, msg.Payload):
+		case <-sub.runtime.Done():
+			return
+		}
 	}
-	ec <- fmt.Errorf("Receive channel closed, Subscription ended.")
+	sub.runtime.Report(ec, fmt.Errorf("Receive channel closed, Subscription ended."))
 	sub.Close()
 }
 
 func (sub *RedisSubscriber) handleMessage(ec chan error) {
 	for message := range sub.processChannel {
-		messages := strings.Split(string(message.GetMessage()), "|")
-		if len(messages) == 4 {
-			messageString := strings.Replace(string(message.GetMessage()), messages[0]+"|", "", 1)
-			message.SetMessage([]byte(messageString))
-			sub.deDuplifier.UniqueID = messages[0]
-		}
-		if !sub.deDuplifier.IsDuplicate() {
-			sub.callback(message)
-			if val, ok := message.GetProperty("ack"); ok && val == "OK" {
-				err := sub.setLastTime()
-				if err != nil {
-					break
-				}
+		id, _ := message.GetProperty("message_id")
+		if sub.runtime.Admit(sub.deDuplifier, id, message.Settlement) {
+			sub.positions.start(message.RawMessage.GetTimetoken())
+			message.Settlement.OnDone(sub.settled(message))
+			if !sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(ec, sub.callback)) {
+				return
 			}
 		}
 	}
 	sub.Close()
 }
 
-func (sub *RedisSubscriber) getMissingMessages() {
-	resp := sub.connection.EvalSha(scripts.XSUBSCRIBESHA, []string{"{" + sub.redisConfig.Topic + "}.list"}, sub.redisConfig.Topic, sub.lastMessageTime)
-	if resp.Err() != nil {
-		return
+// settled returns the function run once the subscriber is done with
+// message, which persists the position when message was acknowledged. With
+// several workers, that is the position of the newest message such that all
+// the older ones are done. A failure to persist closes the subscriber.
+func (sub *RedisSubscriber) settled(message *jmsg.RedisMessage) func() {
+	return func() {
+		sub.positions.finish(message.RawMessage.GetTimetoken())
+		if val, ok := message.GetProperty("ack"); ok && val == "OK" {
+			if err := sub.positions.save(sub.setLastTime); err != nil {
+				sub.runtime.Log().Error("Unable to persist last message time, closing subscriber", "err", err)
+				go sub.Close()
+			}
+		}
 	}
+}
+
+func (sub *RedisSubscriber) getMissingMessages(lastTime int64) {
+	resp := sub.connection.EvalSha(scripts.XSUBSCRIBESHA, []string{"{" + sub.RedisConfig.Topic + "}.list"}, sub.RedisConfig.Topic, lastTime)
 	result, err := resp.Result()
 	if err != nil {
+		sub.runtime.Log().Error("Unable to fetch missed messages", "err", err)
 		return
 	}
 	for _, msg := range result.([]interface{}) {
-		sub.processChannel <- sub.calcTimestamp("", "", msg.(string))
+		select {
+		case sub.processChannel <- sub.calcTimestamp("", "", msg.(string)):
+		case <-sub.runtime.Done():
+			return
+		}
 	}
 }
 
 func (sub *RedisSubscriber) calcTimestamp(channel, pattern, msg string) *jmsg.RedisMessage {
-	msgStrings := strings.Split(msg, "|")
-	sub.lastMessageTime, _ = strconv.ParseInt(msgStrings[0], 10, 64)
-	return &jmsg.RedisMessage{jmsg.RedisRawMessage{&gredis.Message{channel, pattern, strings.Join(msgStrings[1:], "|")}}, sub.connection, make(map[string]string)}
+	// The publish script prefixes every message with "ts|", the sequence
+	// number persistence resumes from.
+	msgStrings := strings.SplitN(msg, "|", 2)
+	timestamp, _ := strconv.ParseInt(msgStrings[0], 10, 64)
+	env := jmsg.Decode([]byte(msgStrings[len(msgStrings)-1]))
+	message := &jmsg.RedisMessage{
+		RawMessage: jmsg.RedisRawMessage{
+			Message:   &gredis.Message{Channel: channel, Pattern: pattern, Payload: string(env.Body)},
+			Timetoken: timestamp,
+		},
+		Responder:  sub.connection,
+		Properties: make(map[string]string),
+		Header:     env.Headers,
+		Settlement: &jmsg.Settlement{},
+	}
+	jmsg.SetEnvelopeProperties(message, env)
+	return message
 }
 
-func (sub *RedisSubscriber) loadLastTime() error {
+func (sub *RedisSubscriber) loadLastTime() (int64, error) {
 	persistencePath := sub.getPersistenceFilePath()
 	if persistencePath == "" {
-		return fmt.Errorf("Unable to find path to write persistence data.")
+		return 0, fmt.Errorf("Unable to find path to write persistence data.")
 	}
 
 	data, err := ioutil.ReadFile(persistencePath)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	t, err := strconv.ParseInt(string(data), 10, 64)
-	if err != nil {
-		return err
-	}
-	sub.lastMessageTime = t
-	return nil
+	return strconv.ParseInt(string(data), 10, 64)
 }
 
-func (sub *RedisSubscriber) setLastTime() error {
+func (sub *RedisSubscriber) setLastTime(lastTime int64) error {
 	persistencePath := sub.getPersistenceFilePath()
 	if persistencePath == "" {
 		return fmt.Errorf("Unable to find path to write persistence data.")
 	}
 
-	err := ioutil.WriteFile(persistencePath, []byte(strconv.FormatInt(sub.lastMessageTime, 10)), 0755)
+	err := ioutil.WriteFile(persistencePath, []byte(strconv.FormatInt(lastTime, 10)), 0755)
 	if err != nil {
 		return err
 	}
@@ -224,7 +288,7 @@ func (sub *RedisSubscriber) setLastTime() error {
 }
 
 func (sub *RedisSubscriber) getPersistenceFilePath() string {
-	filename := ".agent_msg_time." + sub.redisConfig.FileName
+	filename := ".agent_msg_time." + sub.RedisConfig.FileName
 	folder := "/tmp/pubnub/"
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
 		os.Mkdir(folder, 0770)
@@ -232,24 +296,37 @@ func (sub *RedisSubscriber) getPersistenceFilePath() string {
 	return folder + filename
 }
 
-func redisConnect(cfg redisConfig) (jmsg.RawClient, error) {
-	var redisClient *gredis.Client
-	if cfg.Tls {
+func redisConnect(cfg RedisConfig) (jmsg.RawClient, error) {
+	tlsCfg, err := cfg.ClientTLS(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	redisClient := gredis.NewClient(&gredis.Options{
+		Addr:      cfg.Endpoint,
+		Password:  cfg.Password,
+		TLSConfig: tlsCfg,
+	})
 
-		redisClient = gredis.NewClient(&gredis.Options{
-			Addr:      cfg.Endpoint,
-			Password:  cfg.Password,
-			TLSConfig: &tls.Config{},
-		})
+	return jmsg.RedisRawClient{redisClient, redisClient.Subscribe(cfg.Topic)}, nil
+}
 
-	} else {
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *RedisSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
 
-		redisClient = gredis.NewClient(&gredis.Options{
-			Addr:     cfg.Endpoint,
-			Password: cfg.Password,
-		})
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *RedisSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
 
-	}
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *RedisSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
 
-	return jmsg.RedisRawClient{redisClient, redisClient.Subscribe(cfg.Topic)}, nil
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *RedisSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
 }
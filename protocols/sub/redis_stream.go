@@ -0,0 +1,456 @@
+package sub
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amagimedia/judo/v3/client"
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	"github.com/amagimedia/judo/v3/logger"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/redact"
+	"github.com/amagimedia/judo/v3/registry"
+	"github.com/amagimedia/judo/v3/service"
+	gredis "github.com/go-redis/redis"
+)
+
+const (
+	// DefaultClaimIdle is how long an entry stays pending before it is
+	// claimed by another consumer.
+	DefaultClaimIdle = 30 * time.Second
+	// streamCount bounds the entries fetched by a single read or claim.
+	streamCount = 100
+	// streamBlock bounds how long a read waits for new entries.
+	streamBlock = time.Second
+)
+
+type redisStreamConnector func(RedisStreamConfig) (jmsg.StreamRawClient, error)
+
+var redisStreammap = map[string]string{
+	"name":      "Name",
+	"topic":     "Topic",
+	"endpoint":  "Endpoint",
+	"password":  "Password",
+	"consumer":  "Consumer",
+	"claimIdle": "ClaimIdle",
+}
+
+// RedisStreamSubscriber reads a Redis stream through a consumer group named
+// after the subscriber. Subscribers sharing the name share the messages, and
+// each message stays pending until acknowledged.
+type RedisStreamSubscriber struct {
+	connector  redisStreamConnector
+	connection jmsg.StreamRawClient
+	RedisStreamConfig
+	callback    func(jmsg.Message)
+	deDuplifier service.Deduplicator
+	runtime     service.Runtime
+
+	// inflight holds the IDs of the entries delivered and not done yet,
+	// which reclaim leaves alone however long they have been pending.
+	mu       sync.Mutex
+	inflight map[string]bool
+}
+
+// Topic is the key of the stream and Name the consumer group. Consumer names
+// this member of the group, by default after the host and the process, and
+// should be kept across restarts to resume the messages it left pending.
+// Messages pending for longer than ClaimIdle, whether their consumer died or
+// nacked them, are claimed and delivered again.
+type RedisStreamConfig struct {
+	Name      string
+	Topic     string
+	Endpoint  string
+	Password  string
+	Consumer  string
+	ClaimIdle time.Duration
+	service.ReconnectConfig
+	service.ConcurrencyConfig
+	service.RetryConfig
+	judoConfig.TLSConfig
+}
+
+func (c RedisStreamConfig) GetKeys() []string {
+	keys := append([]string{
+		"name",
+		"topic",
+		"endpoint",
+		"password",
+		"consumer",
+		"claimIdle",
+	}, service.ReconnectKeys...)
+	keys = append(keys, service.ConcurrencyKeys...)
+	keys = append(keys, service.RetryKeys...)
+	return append(keys, judoConfig.TLSKeys...)
+}
+
+func (c RedisStreamConfig) GetMandatoryKeys() []string {
+	return []string{
+		"name",
+		"topic",
+		"endpoint",
+	}
+}
+
+func (c RedisStreamConfig) GetField(key string) string {
+	if field, ok := redisStreammap[key]; ok {
+		return field
+	}
+	if field := judoConfig.TLSField(key); field != "" {
+		return field
+	}
+	if field := service.RetryField(key); field != "" {
+		return field
+	}
+	if field := service.ConcurrencyField(key); field != "" {
+		return field
+	}
+	return service.ReconnectField(key)
+}
+
+func init() {
+	registry.RegisterSubscriber("redis-stream", "sub", func(registry.Legs) (client.JudoClient, error) {
+		return NewRedisStreamSub(), nil
+	})
+}
+
+func NewRedisStreamSub() *RedisStreamSubscriber {
+	sub := &RedisStreamSubscriber{connector: redisStreamConnect}
+	return sub
+}
+
+func (sub *RedisStreamSubscriber) Configure(configs []interface{}) error {
+
+	var err error
+	configHelper := judoConfig.ConfigHelper{Config: &sub.RedisStreamConfig}
+	err = configHelper.Load(configs[0])
+	if err == nil {
+		err = sub.RedisStreamConfig.ValidateReconnect()
+	}
+	if err != nil {
+		return err
+	}
+	if sub.RedisStreamConfig.Consumer == "" {
+		host, _ := os.Hostname()
+		sub.RedisStreamConfig.Consumer = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if sub.RedisStreamConfig.ClaimIdle <= 0 {
+		sub.RedisStreamConfig.ClaimIdle = DefaultClaimIdle
+	}
+	if sub.runtime.Retry, err = sub.RedisStreamConfig.RetryPolicy(); err != nil {
+		return err
+	}
+	sub.runtime.SetSecrets(sub.RedisStreamConfig.Password)
+	if len(configs) == 2 {
+		sub.deDuplifier, err = service.NewDeduplicator(configs[1], sub.RedisStreamConfig.Name)
+	}
+
+	return err
+}
+
+func (sub *RedisStreamSubscriber) OnMessage(callback func(msg jmsg.Message)) client.JudoClient {
+	sub.callback = client.Chain(callback, sub.runtime.Middleware...)
+	return sub
+}
+
+// Use applies mw to the callbacks later passed to OnMessage, see client.Use.
+func (sub *RedisStreamSubscriber) Use(mw ...client.Middleware) {
+	sub.runtime.Middleware = append(sub.runtime.Middleware, mw...)
+}
+
+// Start creates the consumer group, and the stream, when missing. A new
+// group only delivers the messages added after it was created.
+func (sub *RedisStreamSubscriber) Start() (<-chan error, error) {
+
+	var err error
+	errorChannel := make(chan error)
+	if sub.callback == nil {
+		return errorChannel, client.ErrNoCallback
+	}
+
+	sub.connection, err = sub.connector(sub.RedisStreamConfig)
+	if err != nil {
+		return errorChannel, sub.runtime.Redact(err)
+	}
+	err = sub.connection.XGroupCreate(sub.RedisStreamConfig.Topic, sub.RedisStreamConfig.Name)
+	if err != nil {
+		return errorChannel, sub.runtime.Redact(err)
+	}
+
+	sub.runtime.Workers = sub.Workers
+	sub.runtime.Labels = metrics.Labels{Protocol: "redis-stream", Topic: sub.RedisStreamConfig.Topic}
+	sub.runtime.Open()
+	go sub.receive(errorChannel)
+
+	return errorChannel, nil
+}
+
+// Status reports read failures and recovery when reconnect is enabled.
+func (sub *RedisStreamSubscriber) Status() <-chan client.Status {
+	return sub.runtime.Status()
+}
+
+// Close waits for the callbacks in flight and then closes the connection.
+// Unacknowledged messages stay pending in the group.
+func (sub *RedisStreamSubscriber) Close() error {
+	sub.runtime.Shutdown()
+	if sub.connection == nil {
+		return nil
+	}
+	return sub.connection.Close()
+}
+
+// receive first reads back the messages this consumer was handed but did not
+// acknowledge before a restart, then waits for new ones, claiming the pending
+// ones every ClaimIdle. A failed read is reported, or retried when reconnect
+// is enabled.
+func (sub *RedisStreamSubscriber) receive(ec chan error) {
+	id := "0"
+	backoff := sub.RedisStreamConfig.Backoff()
+	idle := sub.RedisStreamConfig.ClaimIdle
+	claimed := time.Now()
+	for attempt := 0; ; {
+		if attempt == 0 && time.Since(claimed) >= idle {
+			claimed = time.Now()
+			if !sub.reclaim(ec, idle) {
+				return
+			}
+		}
+		streams, err := sub.connection.XReadGroup(&gredis.XReadGroupArgs{
+			Group:    sub.RedisStreamConfig.Name,
+			Consumer: sub.RedisStreamConfig.Consumer,
+			Streams:  []string{sub.RedisStreamConfig.Topic, id},
+			Count:    streamCount,
+			Block:    streamBlock,
+		})
+		select {
+		case <-sub.runtime.Done():
+			return
+		default:
+		}
+		if err == gredis.Nil {
+			continue
+		}
+		if err != nil && !sub.RedisStreamConfig.Reconnect {
+			sub.runtime.Report(ec, err)
+			sub.Close()
+			return
+		}
+		if err != nil {
+			sub.runtime.Notify(client.Status{State: client.Disconnected, Attempt: attempt, Err: err})
+			attempt++
+			select {
+			case <-time.After(backoff.Duration(attempt)):
+			case <-sub.runtime.Done():
+				return
+			}
+			sub.runtime.Notify(client.Status{State: client.Reconnecting, Attempt: attempt})
+			// The group is gone if Redis restarted without persistence.
+			sub.connection.XGroupCreate(sub.RedisStreamConfig.Topic, sub.RedisStreamConfig.Name)
+			continue
+		}
+		if attempt > 0 {
+			sub.runtime.Notify(client.Status{State: client.Connected, Attempt: attempt})
+			attempt = 0
+		}
+
+		var messages []gredis.XMessage
+		if len(streams) > 0 {
+			messages = streams[0].Messages
+		}
+		if id != ">" && len(messages) == 0 {
+			id = ">"
+			continue
+		}
+		for _, message := range messages {
+			if !sub.deliver(ec, message, id == ">") {
+				return
+			}
+		}
+		if id != ">" {
+			id = messages[len(messages)-1].ID
+		}
+	}
+}
+
+// reclaim claims the messages that have been pending for idle, so that the
+// messages of a consumer that died, and the ones nacked, are delivered again.
+// A message that outlives idle in a callback of another consumer may thus be
+// delivered twice.
+//
+// go-redis v6 has no XAUTOCLAIM, so reclaim pages through XPENDING and claims
+// each page with XCLAIM instead. Where XAUTOCLAIM returns a cursor, the next
+// page here starts right after the last ID listed.
+func (sub *RedisStreamSubscriber) reclaim(ec chan error, idle time.Duration) bool {
+	for start := "-"; start != ""; {
+		messages, next, err := sub.claim(start, idle)
+		if err != nil {
+			sub.runtime.Log().Warn("Unable to claim pending messages", "err", err)
+			return true
+		}
+		for _, message := range messages {
+			if !sub.deliver(ec, message, false) {
+				return false
+			}
+		}
+		start = next
+	}
+	return true
+}
+
+// claim claims the entries, from start on, of a page of the pending ones. It
+// returns where the next page starts, or "" after the last page. Entries
+// still in flight in this consumer are left pending.
+//
+// Consumers reclaiming at once may list the same entry. XCLAIM checks MinIdle
+// again and a claim resets the idle time, so only the first claim returns the
+// entry and the others skip it.
+func (sub *RedisStreamSubscriber) claim(start string, idle time.Duration) ([]gredis.XMessage, string, error) {
+	pending, err := sub.connection.XPendingExt(&gredis.XPendingExtArgs{
+		Stream: sub.RedisStreamConfig.Topic,
+		Group:  sub.RedisStreamConfig.Name,
+		Start:  start,
+		End:    "+",
+		Count:  streamCount,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(pending) == streamCount {
+		next = nextStreamID(pending[len(pending)-1].Id)
+	}
+	var ids []string
+	for _, entry := range pending {
+		if entry.Idle >= idle && !sub.holds(entry.Id) {
+			ids = append(ids, entry.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, next, nil
+	}
+	messages, err := sub.connection.XClaim(&gredis.XClaimArgs{
+		Stream:   sub.RedisStreamConfig.Topic,
+		Group:    sub.RedisStreamConfig.Name,
+		Consumer: sub.RedisStreamConfig.Consumer,
+		MinIdle:  idle,
+		Messages: ids,
+	})
+	return messages, next, err
+}
+
+// nextStreamID returns the ID that follows id, or "" when id is malformed.
+func nextStreamID(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return ""
+	}
+	return parts[0] + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// hold marks the entry id as in flight until release.
+func (sub *RedisStreamSubscriber) hold(id string) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.inflight == nil {
+		sub.inflight = make(map[string]bool)
+	}
+	sub.inflight[id] = true
+}
+
+func (sub *RedisStreamSubscriber) release(id string) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	delete(sub.inflight, id)
+}
+
+func (sub *RedisStreamSubscriber) holds(id string) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.inflight[id]
+}
+
+// deliver dispatches message and reports whether the subscriber still runs.
+// Only fresh entries go through the deduplicator: a pending entry, read back
+// or claimed, was admitted when first read and is delivered again. Duplicates,
+// and entries deleted from the stream while pending, are acknowledged so that
+// they are not claimed over and over.
+func (sub *RedisStreamSubscriber) deliver(ec chan error, entry gredis.XMessage, fresh bool) bool {
+	data, ok := entry.Values[jmsg.RedisStreamField].(string)
+	env := jmsg.Decode([]byte(data))
+	entry.Values = map[string]interface{}{jmsg.RedisStreamField: string(env.Body)}
+	message := &jmsg.RedisStreamMessage{
+		RawMessage: jmsg.RedisStreamRawMessage{
+			Message: &entry,
+			Stream:  sub.RedisStreamConfig.Topic,
+			Group:   sub.RedisStreamConfig.Name,
+			Client:  sub.connection,
+		},
+		Properties: make(map[string]string),
+		Header:     env.Headers,
+		Settlement: &jmsg.Settlement{},
+	}
+	jmsg.SetEnvelopeProperties(message, env)
+	dedup := sub.deDuplifier
+	if !fresh {
+		dedup = nil
+	}
+	if !ok || !sub.runtime.Admit(dedup, env.ID, message.Settlement) {
+		message.SendAck()
+		return true
+	}
+	sub.hold(entry.ID)
+	message.Settlement.OnDone(func() { sub.release(entry.ID) })
+	if !sub.runtime.Deliver(sub.Key(message), message, message.Settlement, sub.runtime.Recover(ec, sub.callback)) {
+		sub.release(entry.ID)
+		return false
+	}
+	return true
+}
+
+func redisStreamConnect(cfg RedisStreamConfig) (jmsg.StreamRawClient, error) {
+	tlsCfg, err := cfg.ClientTLS(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	redisClient := gredis.NewClient(&gredis.Options{
+		Addr:      cfg.Endpoint,
+		Password:  cfg.Password,
+		TLSConfig: tlsCfg,
+	})
+	if err := redisClient.Ping().Err(); err != nil {
+		redisClient.Close()
+		return nil, redact.Error(err, cfg.Password)
+	}
+
+	return jmsg.RedisStreamRawClient{Client: redisClient}, nil
+}
+
+// SetDeduplicator replaces the deduplicator built from the second config.
+func (sub *RedisStreamSubscriber) SetDeduplicator(d service.Deduplicator) {
+	sub.deDuplifier = d
+}
+
+// SetRetryPolicy retries the messages the callback nacks according to p.
+func (sub *RedisStreamSubscriber) SetRetryPolicy(p *service.RetryPolicy) {
+	sub.runtime.Retry = p
+}
+
+// SetMetrics records the message counts, handler latencies and reconnects of
+// the subscriber in m.
+func (sub *RedisStreamSubscriber) SetMetrics(m metrics.Recorder) {
+	sub.runtime.Metrics = m
+}
+
+// SetLogger routes the internal events and errors of the subscriber to l.
+func (sub *RedisStreamSubscriber) SetLogger(l logger.Logger) {
+	sub.runtime.Logger = l
+}
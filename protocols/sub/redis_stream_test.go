@@ -0,0 +1,285 @@
+package sub
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amagimedia/judo/v3/client"
+	"github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	gredis "github.com/go-redis/redis"
+	"github.com/stretchr/testify/mock"
+)
+
+// reading matches the reads of new messages, or of pending ones.
+func reading(pending bool) interface{} {
+	return mock.MatchedBy(func(a *gredis.XReadGroupArgs) bool {
+		return (a.Streams[1] != ">") == pending
+	})
+}
+
+func streamEntry(id, data string) gredis.XMessage {
+	return gredis.XMessage{ID: id, Values: map[string]interface{}{message.RedisStreamField: data}}
+}
+
+func TestRedisStreamSubscriber(t *testing.T) {
+	config := map[string]interface{}{
+		"name":     "workers",
+		"topic":    "jobs",
+		"endpoint": ":6379",
+	}
+	idle := func() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	envelope := message.NewEnvelope([]byte("hello"))
+	envelope.Headers = map[string]string{"trace": "abc"}
+
+	cases := []struct {
+		name   string
+		config map[string]interface{}
+		err    error
+	}{
+		{"success-cfg", config, nil},
+		{"error-cfg", map[string]interface{}{"name": "workers", "topic": "jobs"}, errors.New("Key Missing : endpoint")},
+		{"dial-err", config, errors.New("dial tcp :6379: connection refused")},
+		{"group-err", config, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"success-start", config, nil},
+		{"recv-err", config, errors.New("redis: connection pool timeout")},
+		{"reconnect", map[string]interface{}{"name": "workers", "topic": "jobs", "endpoint": ":6379", "reconnect": true, "reconnectInterval": 10}, errors.New("EOF")},
+		{"reclaim", map[string]interface{}{"name": "workers", "topic": "jobs", "endpoint": ":6379", "consumer": "b", "claimIdle": 50}, nil},
+		{"duplicate", config, nil},
+		{"reclaim-page", map[string]interface{}{"name": "workers", "topic": "jobs", "endpoint": ":6379", "consumer": "b", "claimIdle": 50}, nil},
+		{"reclaim-inflight", map[string]interface{}{"name": "workers", "topic": "jobs", "endpoint": ":6379", "claimIdle": 10, "workers": float64(2)}, nil},
+	}
+	for _, c := range cases {
+		fakeClient := &mocks.StreamRawClient{}
+		fakeSubscriber := &RedisStreamSubscriber{connector: func(RedisStreamConfig) (message.StreamRawClient, error) {
+			return fakeClient, nil
+		}}
+		err := fakeSubscriber.Configure([]interface{}{c.config})
+		if c.name == "error-cfg" {
+			if err == nil || err.Error() != c.err.Error() {
+				t.Errorf("%s: invalid error thrown %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unable to configure %v", c.name, err)
+			continue
+		}
+		fakeClient.On("Close").Return(nil)
+		fakeClient.On("XGroupCreate", "jobs", "workers").Return(nil)
+		fakeClient.On("XPendingExt", mock.Anything).Return(nil, nil)
+		fakeClient.On("XAck", "jobs", "workers", mock.Anything).Return(nil)
+
+		switch c.name {
+		case "success-cfg":
+			if fakeSubscriber.Consumer == "" || fakeSubscriber.ClaimIdle != DefaultClaimIdle {
+				t.Errorf("%s: defaults not set %+v", c.name, fakeSubscriber.RedisStreamConfig)
+			}
+			_ = NewRedisStreamSub()
+		case "dial-err":
+			fakeSubscriber.connector = func(RedisStreamConfig) (message.StreamRawClient, error) {
+				return nil, c.err
+			}
+			fakeSubscriber.OnMessage(func(message.Message) {})
+			if _, err := fakeSubscriber.Start(); err == nil || err.Error() != c.err.Error() {
+				t.Errorf("%s: Start did not fail when expected %v", c.name, err)
+			}
+		case "group-err":
+			fakeClient = &mocks.StreamRawClient{}
+			fakeClient.On("XGroupCreate", "jobs", "workers").Return(c.err)
+			fakeSubscriber.OnMessage(func(message.Message) {})
+			if _, err := fakeSubscriber.Start(); err == nil || err.Error() != c.err.Error() {
+				t.Errorf("%s: Start did not fail when expected %v", c.name, err)
+			}
+		case "success-start":
+			received := make(chan message.Message, 2)
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				msg.SendAck()
+				received <- msg
+			})
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs", Messages: []gredis.XMessage{streamEntry("1-0", "left pending")}}}, nil).Once()
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return([]gredis.XStream{{Stream: "jobs", Messages: []gredis.XMessage{streamEntry("2-0", string(message.Encode(envelope)))}}}, nil).Once()
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			if _, err := fakeSubscriber.Start(); err != nil {
+				t.Errorf("%s: unable to start %v", c.name, err)
+			}
+			for _, want := range []string{"left pending", "hello"} {
+				select {
+				case msg := <-received:
+					if string(msg.GetMessage()) != want {
+						t.Errorf("%s: expected %q, got %q", c.name, want, msg.GetMessage())
+					}
+					if want == "hello" {
+						if trace, _ := msg.GetHeader("trace"); trace != "abc" {
+							t.Errorf("%s: header lost %v", c.name, msg.GetHeaders())
+						}
+						if id, _ := msg.GetProperty("message_id"); id != envelope.ID {
+							t.Errorf("%s: unexpected message_id %q", c.name, id)
+						}
+					}
+				case <-time.After(time.Second):
+					t.Errorf("%s: %q not delivered", c.name, want)
+				}
+			}
+			fakeSubscriber.Close()
+			fakeClient.AssertCalled(t, "XAck", "jobs", "workers", "1-0")
+			fakeClient.AssertCalled(t, "XAck", "jobs", "workers", "2-0")
+		case "recv-err":
+			fakeClient.On("XReadGroup", mock.Anything).Return(nil, c.err)
+			fakeSubscriber.OnMessage(func(message.Message) {})
+			ec, err := fakeSubscriber.Start()
+			if err != nil {
+				t.Errorf("%s: unable to start %v", c.name, err)
+			}
+			select {
+			case err := <-ec:
+				if err.Error() != c.err.Error() {
+					t.Errorf("%s: unexpected error %v", c.name, err)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: error not reported", c.name)
+			}
+		case "reconnect":
+			fakeClient.On("XReadGroup", reading(true)).Return(nil, c.err).Once()
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			status := fakeSubscriber.Status()
+			fakeSubscriber.OnMessage(func(message.Message) {})
+			if _, err := fakeSubscriber.Start(); err != nil {
+				t.Errorf("%s: unable to start %v", c.name, err)
+			}
+			for _, want := range []client.State{client.Disconnected, client.Reconnecting, client.Connected} {
+				select {
+				case s := <-status:
+					if s.State != want {
+						t.Errorf("%s: expected %v, got %+v", c.name, want, s)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("%s: %v not notified", c.name, want)
+				}
+			}
+			fakeSubscriber.Close()
+		case "reclaim":
+			// A claimed entry was admitted when it was first read.
+			fakeSubscriber.SetDeduplicator(seenAll{})
+			received := make(chan message.Message, 1)
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				received <- msg
+			})
+			fakeClient = &mocks.StreamRawClient{}
+			fakeClient.On("Close").Return(nil)
+			fakeClient.On("XGroupCreate", "jobs", "workers").Return(nil)
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			fakeClient.On("XPendingExt", mock.Anything).Return([]gredis.XPendingExt{
+				{Id: "3-0", Consumer: "a", Idle: time.Minute},
+				{Id: "4-0", Consumer: "a", Idle: time.Millisecond},
+			}, nil).Once()
+			fakeClient.On("XPendingExt", mock.Anything).Return(nil, nil)
+			fakeClient.On("XClaim", mock.MatchedBy(func(a *gredis.XClaimArgs) bool {
+				// MinIdle keeps an entry just claimed by another consumer.
+				return a.Consumer == "b" && a.MinIdle == 50*time.Millisecond && len(a.Messages) == 1 && a.Messages[0] == "3-0"
+			})).Return([]gredis.XMessage{streamEntry("3-0", "orphan")}, nil)
+			if _, err := fakeSubscriber.Start(); err != nil {
+				t.Errorf("%s: unable to start %v", c.name, err)
+			}
+			select {
+			case msg := <-received:
+				if string(msg.GetMessage()) != "orphan" {
+					t.Errorf("%s: unexpected message %q", c.name, msg.GetMessage())
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: pending message not claimed", c.name)
+			}
+			fakeSubscriber.Close()
+		case "duplicate":
+			fakeSubscriber.SetDeduplicator(seenAll{})
+			called := false
+			fakeSubscriber.OnMessage(func(message.Message) {
+				called = true
+			})
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return([]gredis.XStream{{Stream: "jobs", Messages: []gredis.XMessage{streamEntry("5-0", string(message.Encode(envelope)))}}}, nil).Once()
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			fakeSubscriber.Start()
+			time.Sleep(50 * time.Millisecond)
+			fakeSubscriber.Close()
+			if called {
+				t.Errorf("%s: duplicate delivered", c.name)
+			}
+			fakeClient.AssertCalled(t, "XAck", "jobs", "workers", "5-0")
+		case "reclaim-page":
+			received := make(chan message.Message, 1)
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				received <- msg
+			})
+			var page []gredis.XPendingExt
+			for i := 1; i <= 100; i++ {
+				page = append(page, gredis.XPendingExt{Id: fmt.Sprintf("%d-0", i), Consumer: "b", Idle: time.Millisecond})
+			}
+			starting := func(start string) interface{} {
+				return mock.MatchedBy(func(a *gredis.XPendingExtArgs) bool { return a.Start == start })
+			}
+			fakeClient = &mocks.StreamRawClient{}
+			fakeClient.On("Close").Return(nil)
+			fakeClient.On("XGroupCreate", "jobs", "workers").Return(nil)
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			fakeClient.On("XPendingExt", starting("-")).Return(page, nil)
+			fakeClient.On("XPendingExt", starting("100-1")).Return([]gredis.XPendingExt{
+				{Id: "101-0", Consumer: "a", Idle: time.Minute},
+			}, nil).Once()
+			fakeClient.On("XPendingExt", starting("100-1")).Return(nil, nil)
+			fakeClient.On("XClaim", mock.MatchedBy(func(a *gredis.XClaimArgs) bool {
+				return len(a.Messages) == 1 && a.Messages[0] == "101-0"
+			})).Return([]gredis.XMessage{streamEntry("101-0", "later")}, nil)
+			if _, err := fakeSubscriber.Start(); err != nil {
+				t.Errorf("%s: unable to start %v", c.name, err)
+			}
+			select {
+			case msg := <-received:
+				if string(msg.GetMessage()) != "later" {
+					t.Errorf("%s: unexpected message %q", c.name, msg.GetMessage())
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: pending message past the first page not claimed", c.name)
+			}
+			fakeSubscriber.Close()
+		case "reclaim-inflight":
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			fakeSubscriber.OnMessage(func(msg message.Message) {
+				close(entered)
+				<-release
+				msg.SendAck()
+			})
+			fakeClient = &mocks.StreamRawClient{}
+			fakeClient.On("Close").Return(nil)
+			fakeClient.On("XGroupCreate", "jobs", "workers").Return(nil)
+			fakeClient.On("XAck", "jobs", "workers", mock.Anything).Return(nil)
+			fakeClient.On("XReadGroup", reading(true)).Return([]gredis.XStream{{Stream: "jobs"}}, nil)
+			fakeClient.On("XReadGroup", reading(false)).Return([]gredis.XStream{{Stream: "jobs", Messages: []gredis.XMessage{streamEntry("6-0", "slow")}}}, nil).Once()
+			fakeClient.On("XReadGroup", reading(false)).Return(nil, gredis.Nil).Run(func(mock.Arguments) { idle() })
+			fakeClient.On("XPendingExt", mock.Anything).Return([]gredis.XPendingExt{
+				{Id: "6-0", Consumer: "b", Idle: time.Minute},
+			}, nil)
+			fakeClient.On("XClaim", mock.Anything).Return(nil, nil)
+			fakeSubscriber.Start()
+			<-entered
+			time.Sleep(50 * time.Millisecond)
+			fakeClient.AssertNotCalled(t, "XClaim", mock.Anything)
+			close(release)
+			fakeSubscriber.Close()
+		}
+	}
+}
+
+// seenAll reports every message as a duplicate.
+type seenAll struct{}
+
+func (seenAll) Seen(string) bool { return true }
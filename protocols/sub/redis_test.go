@@ -2,6 +2,9 @@ package sub
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
@@ -14,7 +17,7 @@ import (
 func TestRedisSubscriber(t *testing.T) {
 	fakeClient := &mocks.RawClient{}
 
-	connector := func(cfg redisConfig) (message.RawClient, error) {
+	connector := func(cfg RedisConfig) (message.RawClient, error) {
 		return fakeClient, nil
 	}
 
@@ -104,6 +107,19 @@ func TestRedisSubscriber(t *testing.T) {
 			"recv-err",
 			errors.New("Receive channel closed, Subscription ended."),
 		},
+		{
+			[]interface{}{
+				map[string]interface{}{
+					"name":        "dqi50n_agent",
+					"topic":       "dqi50n.out",
+					"endpoint":    ":6379",
+					"persistence": true,
+					"fileName":    "judo_evalsha_test",
+				},
+			},
+			"evalsha-err",
+			errors.New("NOSCRIPT No matching script"),
+		},
 	}
 	for _, c := range cases {
 		switch c.retVal {
@@ -149,7 +165,7 @@ func TestRedisSubscriber(t *testing.T) {
 			fakeClient.On("Close").Return(nil)
 			fakeSubscriber.Close()
 		case "dial-err":
-			connector := func(cfg redisConfig) (message.RawClient, error) {
+			connector := func(cfg RedisConfig) (message.RawClient, error) {
 				return fakeClient, c.retType
 			}
 			fSubscriber := &RedisSubscriber{connector: connector}
@@ -166,6 +182,7 @@ func TestRedisSubscriber(t *testing.T) {
 			fakeClient.On("EvalSha", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(gredis.NewCmdResult(make([]interface{}, 0), nil))
 			fakeClient.On("ScriptLoad", mock.AnythingOfType("string")).Return(&gredis.StringCmd{})
 			fakeClient.On("Close").Return(nil)
+			fSubscriber.OnMessage(func(message.Message) {})
 			_, err = fSubscriber.Start()
 			if err.Error() != c.retType.Error() {
 				t.Error("Start did not fail when expected")
@@ -173,7 +190,7 @@ func TestRedisSubscriber(t *testing.T) {
 		case "recv-err":
 			fClient := &mocks.RawClient{}
 			ch := make(chan *gredis.Message)
-			cr := func(cfg redisConfig) (message.RawClient, error) {
+			cr := func(cfg RedisConfig) (message.RawClient, error) {
 				return fClient, nil
 			}
 
@@ -199,7 +216,52 @@ func TestRedisSubscriber(t *testing.T) {
 			if err.Error() != c.retType.Error() {
 				t.Error("Start did not fail when expected")
 			}
+		case "evalsha-err":
+			fClient := &mocks.RawClient{}
+			fSubscriber := &RedisSubscriber{connector: func(cfg RedisConfig) (message.RawClient, error) {
+				return fClient, nil
+			}}
+			err := fSubscriber.Configure(c.config)
+			if err != nil {
+				t.Error("Configure failed when not expected.")
+			}
+			persisted := fSubscriber.getPersistenceFilePath()
+			ioutil.WriteFile(persisted, []byte("1"), 0644)
+			defer os.Remove(persisted)
+			logs := chanLogger(make(chan string, 4))
+			fSubscriber.SetLogger(logs)
+			fSubscriber.OnMessage(func(message.Message) {})
+			fClient.On("Subscribe", mock.AnythingOfType("string")).Return(&gredis.PubSub{})
+			fClient.On("Channel").Return(make(<-chan *gredis.Message))
+			fClient.On("EvalSha", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(gredis.NewCmdResult(nil, c.retType))
+			fClient.On("ScriptLoad", mock.AnythingOfType("string")).Return(&gredis.StringCmd{})
+			fClient.On("Close").Return(nil)
+			fSubscriber.Start()
+			select {
+			case line := <-logs:
+				if line != "Unable to fetch missed messages protocol=redis topic=dqi50n.out err="+c.retType.Error() {
+					t.Error("Unexpected log", line)
+				}
+			case <-time.After(time.Second):
+				t.Error("EvalSha error not logged")
+			}
+			fSubscriber.Close()
 		}
 	}
 
 }
+
+// chanLogger sends every log line, without the level, to the channel.
+type chanLogger chan string
+
+func (l chanLogger) Debug(msg string, args ...interface{}) { l.log(msg, args) }
+func (l chanLogger) Info(msg string, args ...interface{})  { l.log(msg, args) }
+func (l chanLogger) Warn(msg string, args ...interface{})  { l.log(msg, args) }
+func (l chanLogger) Error(msg string, args ...interface{}) { l.log(msg, args) }
+
+func (l chanLogger) log(msg string, args []interface{}) {
+	for i := 0; i+1 < len(args); i += 2 {
+		msg += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+	}
+	l <- msg
+}
@@ -0,0 +1,97 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"time"
+)
+
+// PublishFunc publishes msg with headers, which may be nil.
+type PublishFunc func(subject string, msg []byte, headers map[string]string) error
+
+// Middleware wraps a PublishFunc with behaviour shared by every publish,
+// such as logging, metrics or tracing.
+type Middleware func(PublishFunc) PublishFunc
+
+// Chain wraps p in mw. The first middleware is the outermost.
+func Chain(p PublishFunc, mw ...Middleware) PublishFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		p = mw[i](p)
+	}
+	return p
+}
+
+// Use returns pub with mw applied to Publish and PublishWithHeaders. When pub
+// is a Requester the result is one too, and requests go through mw as well.
+// Requests carry no headers, so the headers mw sets on them are dropped.
+// Unwrap gives back pub.
+func Use(pub JudoPub, mw ...Middleware) JudoPub {
+	wrapped := &middlewarePub{pub, mw, Chain(func(subject string, msg []byte, headers map[string]string) error {
+		if len(headers) == 0 {
+			return pub.Publish(subject, msg)
+		}
+		return PublishWithHeaders(pub, subject, msg, headers)
+	}, mw...)}
+	if req, ok := pub.(Requester); ok {
+		return &middlewareRequester{wrapped, req}
+	}
+	return wrapped
+}
+
+type middlewarePub struct {
+	JudoPub
+	mw      []Middleware
+	publish PublishFunc
+}
+
+func (p *middlewarePub) Publish(subject string, msg []byte) error {
+	return p.publish(subject, msg, nil)
+}
+
+func (p *middlewarePub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	return p.publish(subject, msg, headers)
+}
+
+// Unwrap returns the publisher middleware was applied to.
+func (p *middlewarePub) Unwrap() JudoPub {
+	return p.JudoPub
+}
+
+type middlewareRequester struct {
+	*middlewarePub
+	req Requester
+}
+
+func (p *middlewareRequester) Request(ctx context.Context, subject string, msg []byte, timeout time.Duration) ([]byte, error) {
+	var reply []byte
+	err := Chain(func(subject string, msg []byte, headers map[string]string) error {
+		var err error
+		reply, err = p.req.Request(ctx, subject, msg, timeout)
+		return err
+	}, p.mw...)(subject, msg, nil)
+	return reply, err
+}
+
+// Compress gzips every payload and sets the content-encoding header, which
+// client.Decompress looks for.
+func Compress() Middleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(subject string, msg []byte, headers map[string]string) error {
+			var buf bytes.Buffer
+			writer := gzip.NewWriter(&buf)
+			if _, err := writer.Write(msg); err != nil {
+				return err
+			}
+			if err := writer.Close(); err != nil {
+				return err
+			}
+			withEncoding := make(map[string]string, len(headers)+1)
+			for key, val := range headers {
+				withEncoding[key] = val
+			}
+			withEncoding["content-encoding"] = "gzip"
+			return next(subject, buf.Bytes(), withEncoding)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type fakePub struct {
+	msg     []byte
+	headers map[string]string
+}
+
+func (p *fakePub) Connect([]interface{}) error { return nil }
+func (p *fakePub) Close() error                { return nil }
+func (p *fakePub) Publish(subject string, msg []byte) error {
+	p.msg, p.headers = msg, nil
+	return nil
+}
+
+type fakeHeaderPub struct {
+	fakePub
+}
+
+func (p *fakeHeaderPub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	p.msg, p.headers = msg, headers
+	return nil
+}
+
+type fakeRequester struct {
+	fakePub
+}
+
+func (p *fakeRequester) Request(ctx context.Context, subject string, msg []byte, timeout time.Duration) ([]byte, error) {
+	return []byte("reply"), nil
+}
+
+func TestMiddleware(t *testing.T) {
+	addHeader := func(next PublishFunc) PublishFunc {
+		return func(subject string, msg []byte, headers map[string]string) error {
+			return next(subject, msg, map[string]string{"trace": "1"})
+		}
+	}
+
+	cases := []string{"plain", "headers", "requester", "compress"}
+	for _, c := range cases {
+		switch c {
+		case "plain":
+			inner := &fakePub{}
+			var calls int
+			pub := Use(inner, func(next PublishFunc) PublishFunc {
+				return func(subject string, msg []byte, headers map[string]string) error {
+					calls++
+					return next(subject, msg, headers)
+				}
+			})
+			pub.Publish("s", []byte("a"))
+			PublishWithHeaders(pub, "s", []byte("b"), nil)
+			if calls != 2 || string(inner.msg) != "b" {
+				t.Errorf("%s: middleware not applied, %d calls", c, calls)
+			}
+			if _, ok := pub.(Requester); ok {
+				t.Errorf("%s: publisher turned into a requester", c)
+			}
+		case "headers":
+			inner := &fakeHeaderPub{}
+			Use(inner, addHeader).Publish("s", []byte("a"))
+			if inner.headers["trace"] != "1" {
+				t.Errorf("%s: headers added by middleware were lost", c)
+			}
+		case "requester":
+			var subjects []string
+			pub := Use(&fakeRequester{}, addHeader, func(next PublishFunc) PublishFunc {
+				return func(subject string, msg []byte, headers map[string]string) error {
+					subjects = append(subjects, subject)
+					return next(subject, msg, headers)
+				}
+			})
+			req, ok := pub.(Requester)
+			if !ok {
+				t.Fatalf("%s: requester hidden by middleware", c)
+			}
+			reply, err := req.Request(context.Background(), "s", []byte("a"), time.Second)
+			if err != nil || string(reply) != "reply" {
+				t.Errorf("%s: unexpected reply %q %v", c, reply, err)
+			}
+			if len(subjects) != 1 || subjects[0] != "s" {
+				t.Errorf("%s: request skipped the middleware", c)
+			}
+		case "compress":
+			inner := &fakeHeaderPub{}
+			Use(inner, Compress()).Publish("s", []byte("hello"))
+			if inner.headers["content-encoding"] != "gzip" {
+				t.Fatalf("%s: content-encoding not set", c)
+			}
+			reader, err := gzip.NewReader(bytes.NewReader(inner.msg))
+			if err != nil {
+				t.Fatalf("%s: %v", c, err)
+			}
+			body, _ := ioutil.ReadAll(reader)
+			if string(body) != "hello" {
+				t.Errorf("%s: expected hello, got %q", c, body)
+			}
+		}
+	}
+}
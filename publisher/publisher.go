@@ -1,7 +1,98 @@
 package publisher
 
+import (
+	"context"
+	"time"
+)
+
 type JudoPub interface {
 	Connect([]interface{}) error
 	Publish(string, []byte) error
 	Close() error
 }
+
+// HeaderPublisher is implemented by publishers that can send headers along
+// with the payload. Transports without native headers wrap the payload in an
+// envelope that judo subscribers unwrap.
+type HeaderPublisher interface {
+	PublishWithHeaders(subject string, msg []byte, headers map[string]string) error
+}
+
+// PublishWithHeaders publishes through pub with headers when it supports
+// them, and drops the headers otherwise.
+func PublishWithHeaders(pub JudoPub, subject string, msg []byte, headers map[string]string) error {
+	if hp, ok := pub.(HeaderPublisher); ok {
+		return hp.PublishWithHeaders(subject, msg, headers)
+	}
+	return pub.Publish(subject, msg)
+}
+
+// ContextPublisher is implemented by publishers that can abandon a publish
+// when its context is done.
+type ContextPublisher interface {
+	PublishContext(ctx context.Context, subject string, msg []byte) error
+}
+
+// PublishContext publishes msg through pub and returns ctx.Err() once ctx is
+// done. Unless pub is a ContextPublisher, the publish carries on in the
+// background, so the message may still be sent after that.
+func PublishContext(ctx context.Context, pub JudoPub, subject string, msg []byte) error {
+	if cp, ok := pub.(ContextPublisher); ok {
+		return cp.PublishContext(ctx, subject, msg)
+	}
+	return within(ctx, func() error {
+		return pub.Publish(subject, msg)
+	})
+}
+
+// CloseContext closes pub and returns ctx.Err() once ctx is done, leaving
+// the close to complete in the background.
+func CloseContext(ctx context.Context, pub JudoPub) error {
+	return within(ctx, pub.Close)
+}
+
+func within(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- fn()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Requester is implemented by request/reply publishers. Request returns the
+// body the replier passed to SendAck, or a *NackError carrying the body passed
+// to SendNack. Any other error is a transport failure or a timeout.
+type Requester interface {
+	Request(ctx context.Context, subject string, msg []byte, timeout time.Duration) ([]byte, error)
+}
+
+type NackError struct {
+	Reply []byte
+}
+
+func (e *NackError) Error() string {
+	return "Request rejected by replier: " + string(e.Reply)
+}
+
+// RequestTimeout returns how long a request may wait for its reply: timeout,
+// or fallback when timeout is not positive, shortened to the deadline of ctx
+// if that comes first.
+func RequestTimeout(ctx context.Context, timeout, fallback time.Duration) time.Duration {
+	if timeout <= 0 {
+		timeout = fallback
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
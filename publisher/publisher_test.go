@@ -0,0 +1,72 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowPub blocks every call until release is closed.
+type slowPub struct {
+	fakePub
+	release chan struct{}
+}
+
+func (p *slowPub) Publish(subject string, msg []byte) error {
+	<-p.release
+	return p.fakePub.Publish(subject, msg)
+}
+
+func (p *slowPub) Close() error {
+	<-p.release
+	return nil
+}
+
+type fakeContextPub struct {
+	fakePub
+	ctx context.Context
+}
+
+func (p *fakeContextPub) PublishContext(ctx context.Context, subject string, msg []byte) error {
+	p.ctx = ctx
+	return p.Publish(subject, msg)
+}
+
+func TestPublishContext(t *testing.T) {
+	cases := []string{"publish", "cancel", "native", "close"}
+	for _, c := range cases {
+		switch c {
+		case "publish":
+			pub := &fakePub{}
+			if err := PublishContext(context.Background(), pub, "s", []byte("a")); err != nil || string(pub.msg) != "a" {
+				t.Errorf("%s: message not published %v", c, err)
+			}
+		case "cancel":
+			pub := &slowPub{release: make(chan struct{})}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			err := PublishContext(ctx, pub, "s", []byte("a"))
+			cancel()
+			close(pub.release)
+			if err != context.DeadlineExceeded {
+				t.Errorf("%s: expected deadline exceeded, got %v", c, err)
+			}
+		case "native":
+			pub := &fakeContextPub{}
+			ctx := context.WithValue(context.Background(), c, c)
+			if err := PublishContext(ctx, pub, "s", []byte("a")); err != nil || pub.ctx != ctx {
+				t.Errorf("%s: context not passed on %v", c, err)
+			}
+		case "close":
+			pub := &slowPub{release: make(chan struct{})}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := CloseContext(ctx, pub); err != context.Canceled {
+				t.Errorf("%s: expected canceled, got %v", c, err)
+			}
+			close(pub.release)
+			if err := CloseContext(context.Background(), pub); err != nil {
+				t.Errorf("%s: unable to close %v", c, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/amagimedia/judo/v3/client"
+	"github.com/amagimedia/judo/v3/publisher"
+)
+
+// Legs names the primary and backup protocols of composite transports such
+// as amagi. Factories of plain transports ignore it.
+type Legs struct {
+	Primary string
+	Backup  string
+}
+
+type SubscriberFactory func(Legs) (client.JudoClient, error)
+
+type PublisherFactory func(Legs) (publisher.JudoPub, error)
+
+// UnknownProtocolError is returned when no factory is registered for a
+// protocol/method pair.
+type UnknownProtocolError struct {
+	Kind     string
+	Protocol string
+	Method   string
+}
+
+func (e *UnknownProtocolError) Error() string {
+	return fmt.Sprintf("Invalid Parameters, no %s registered for protocol: %s, method: %s", e.Kind, e.Protocol, e.Method)
+}
+
+type entry struct {
+	protocol string
+	method   string
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[entry]SubscriberFactory)
+	publishers  = make(map[entry]PublisherFactory)
+)
+
+// RegisterSubscriber makes a subscriber or replier available under the given
+// protocol and method. It panics if the pair is already registered or the
+// factory is nil, so that conflicting transports are caught at init time.
+func RegisterSubscriber(protocol, method string, factory SubscriberFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("registry: nil subscriber factory for " + protocol + "-" + method)
+	}
+	key := entry{protocol, method}
+	if _, dup := subscribers[key]; dup {
+		panic("registry: subscriber registered twice for " + protocol + "-" + method)
+	}
+	subscribers[key] = factory
+}
+
+// RegisterPublisher makes a publisher available under the given protocol and
+// method. It panics on duplicates and nil factories like RegisterSubscriber.
+func RegisterPublisher(protocol, method string, factory PublisherFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("registry: nil publisher factory for " + protocol + "-" + method)
+	}
+	key := entry{protocol, method}
+	if _, dup := publishers[key]; dup {
+		panic("registry: publisher registered twice for " + protocol + "-" + method)
+	}
+	publishers[key] = factory
+}
+
+func NewSubscriber(protocol, method string, legs Legs) (client.JudoClient, error) {
+	mu.RLock()
+	factory, ok := subscribers[entry{protocol, method}]
+	mu.RUnlock()
+	if !ok {
+		return nil, &UnknownProtocolError{"subscriber", protocol, method}
+	}
+	return factory(legs)
+}
+
+func NewPublisher(protocol, method string, legs Legs) (publisher.JudoPub, error) {
+	mu.RLock()
+	factory, ok := publishers[entry{protocol, method}]
+	mu.RUnlock()
+	if !ok {
+		return nil, &UnknownProtocolError{"publisher", protocol, method}
+	}
+	return factory(legs)
+}
+
+// Subscribers lists the registered subscriber pairs as "protocol-method".
+func Subscribers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(subscribers))
+	for key := range subscribers {
+		names = append(names, key.protocol+"-"+key.method)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Publishers lists the registered publisher pairs as "protocol-method".
+func Publishers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(publishers))
+	for key := range publishers {
+		names = append(names, key.protocol+"-"+key.method)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amagimedia/judo/v3/client"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+)
+
+type fakeSub struct {
+	legs Legs
+}
+
+func (f *fakeSub) Configure([]interface{}) error                      { return nil }
+func (f *fakeSub) OnMessage(func(msg jmsg.Message)) client.JudoClient { return f }
+func (f *fakeSub) Start() (<-chan error, error)                       { return nil, nil }
+func (f *fakeSub) Close() error                                       { return nil }
+
+type fakePub struct{}
+
+func (f *fakePub) Connect([]interface{}) error  { return nil }
+func (f *fakePub) Publish(string, []byte) error { return nil }
+func (f *fakePub) Close() error                 { return nil }
+
+func TestRegistry(t *testing.T) {
+	mu.Lock()
+	delete(subscribers, entry{"inhouse", "sub"})
+	delete(publishers, entry{"inhouse", "publish"})
+	mu.Unlock()
+
+	RegisterSubscriber("inhouse", "sub", func(legs Legs) (client.JudoClient, error) {
+		return &fakeSub{legs}, nil
+	})
+	RegisterPublisher("inhouse", "publish", func(Legs) (publisher.JudoPub, error) {
+		return &fakePub{}, nil
+	})
+
+	sub, err := NewSubscriber("inhouse", "sub", Legs{"a", "b"})
+	if err != nil {
+		t.Error("Unable to create registered subscriber", err.Error())
+	}
+	if fs, ok := sub.(*fakeSub); !ok || fs.legs.Primary != "a" || fs.legs.Backup != "b" {
+		t.Error("Legs not passed to subscriber factory")
+	}
+
+	pub, err := NewPublisher("inhouse", "publish", Legs{})
+	if err != nil || pub == nil {
+		t.Error("Unable to create registered publisher")
+	}
+
+	var unknown *UnknownProtocolError
+	_, err = NewSubscriber("inhouse", "reply", Legs{})
+	if !errors.As(err, &unknown) || unknown.Kind != "subscriber" || unknown.Method != "reply" {
+		t.Error("Did not return UnknownProtocolError for unknown subscriber", err)
+	}
+	_, err = NewPublisher("oooo", "publish", Legs{})
+	if !errors.As(err, &unknown) || unknown.Kind != "publisher" || unknown.Protocol != "oooo" {
+		t.Error("Did not return UnknownProtocolError for unknown publisher", err)
+	}
+
+	found := false
+	for _, name := range Subscribers() {
+		if name == "inhouse-sub" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Registered subscriber not listed")
+	}
+	if len(Publishers()) != 1 || Publishers()[0] != "inhouse-publish" {
+		t.Error("Registered publisher not listed", Publishers())
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	mu.Lock()
+	delete(subscribers, entry{"dup", "sub"})
+	mu.Unlock()
+
+	factory := func(Legs) (client.JudoClient, error) { return &fakeSub{}, nil }
+	RegisterSubscriber("dup", "sub", factory)
+	defer func() {
+		if recover() == nil {
+			t.Error("Duplicate registration did not panic")
+		}
+	}()
+	RegisterSubscriber("dup", "sub", factory)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays between reconnect attempts.
+// Jitter is the fraction, between 0 and 1, by which a delay is randomly
+// shortened or lengthened so that clients do not reconnect in lockstep.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// Duration returns the delay before the given attempt, counting from 1.
+func (b Backoff) Duration(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if jitter := math.Min(b.Jitter, 1); jitter > 0 {
+		d += d * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// ReconnectConfig holds the reconnect keys shared by the subscribers that
+// recover from connection loss. Zero intervals fall back to 1s initial and
+// 30s max, and a zero multiplier to 2.
+type ReconnectConfig struct {
+	Reconnect            bool
+	ReconnectInterval    time.Duration
+	ReconnectMaxInterval time.Duration
+	ReconnectMultiplier  float64
+	ReconnectJitter      float64
+}
+
+// ReconnectKeys lists the config keys of ReconnectConfig, to be appended to
+// the keys of the embedding config.
+var ReconnectKeys = []string{
+	"reconnect",
+	"reconnectInterval",
+	"reconnectMaxInterval",
+	"reconnectMultiplier",
+	"reconnectJitter",
+}
+
+var reconnectmap = map[string]string{
+	"reconnect":            "Reconnect",
+	"reconnectInterval":    "ReconnectInterval",
+	"reconnectMaxInterval": "ReconnectMaxInterval",
+	"reconnectMultiplier":  "ReconnectMultiplier",
+	"reconnectJitter":      "ReconnectJitter",
+}
+
+// ReconnectField maps a reconnect key to its field name.
+func ReconnectField(key string) string {
+	return reconnectmap[key]
+}
+
+// ValidateReconnect checks the values of the reconnect keys that the config
+// loader cannot: reconnectJitter must lie between 0 and 1, or delays could
+// turn negative.
+func (c ReconnectConfig) ValidateReconnect() error {
+	if c.ReconnectJitter < 0 || c.ReconnectJitter > 1 {
+		return errors.New("Invalid Value for config reconnectJitter, expected between 0 and 1")
+	}
+	return nil
+}
+
+func (c ReconnectConfig) Backoff() Backoff {
+	return backoff(c.ReconnectInterval, c.ReconnectMaxInterval, c.ReconnectMultiplier, c.ReconnectJitter)
+}
+
+// backoff builds a Backoff, falling back to 1s initial, 30s max and a
+// multiplier of 2 for zero values.
+func backoff(interval, max time.Duration, multiplier, jitter float64) Backoff {
+	b := Backoff{
+		Initial:    time.Second,
+		Max:        30 * time.Second,
+		Multiplier: 2,
+		Jitter:     jitter,
+	}
+	if interval > 0 {
+		b.Initial = interval
+	}
+	if max > 0 {
+		b.Max = max
+	}
+	if multiplier >= 1 {
+		b.Multiplier = multiplier
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for _, c := range cases {
+		if got := b.Duration(c.attempt); got != c.want {
+			t.Errorf("attempt %d: expected %s, got %s", c.attempt, c.want, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := b.Duration(2)
+		if got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %s", got)
+		}
+	}
+
+	b.Jitter = 3
+	for i := 0; i < 100; i++ {
+		if got := b.Duration(2); got < 0 {
+			t.Fatalf("negative delay: %s", got)
+		}
+	}
+	for jitter, valid := range map[float64]bool{-0.1: false, 0: true, 0.5: true, 1: true, 1.5: false} {
+		err := ReconnectConfig{ReconnectJitter: jitter}.ValidateReconnect()
+		if (err == nil) != valid {
+			t.Errorf("jitter %v: unexpected validation result %v", jitter, err)
+		}
+	}
+
+	defaults := ReconnectConfig{}.Backoff()
+	if defaults.Initial != time.Second || defaults.Max != 30*time.Second || defaults.Multiplier != 2 {
+		t.Error("Unexpected defaults", defaults)
+	}
+	custom := ReconnectConfig{ReconnectInterval: 500 * time.Millisecond, ReconnectMaxInterval: 4 * time.Second, ReconnectMultiplier: 3}.Backoff()
+	if custom.Initial != 500*time.Millisecond || custom.Max != 4*time.Second || custom.Multiplier != 3 {
+		t.Error("Config not applied", custom)
+	}
+}
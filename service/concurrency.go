@@ -0,0 +1,44 @@
+package service
+
+import (
+	jmsg "github.com/amagimedia/judo/v3/message"
+)
+
+// ConcurrencyConfig holds the keys that let a subscriber run callbacks in
+// parallel. Workers is the number of callbacks run at once, one by default.
+// OrderingKey names a header, or failing that a property, whose value keeps
+// messages in order: messages sharing a value are handled one at a time.
+type ConcurrencyConfig struct {
+	Workers     int
+	OrderingKey string
+}
+
+// ConcurrencyKeys lists the config keys of ConcurrencyConfig, to be appended
+// to the keys of the embedding config.
+var ConcurrencyKeys = []string{
+	"workers",
+	"orderingKey",
+}
+
+var concurrencymap = map[string]string{
+	"workers":     "Workers",
+	"orderingKey": "OrderingKey",
+}
+
+// ConcurrencyField maps a concurrency key to its field name.
+func ConcurrencyField(key string) string {
+	return concurrencymap[key]
+}
+
+// Key returns the ordering key of msg, or "" when it may be handled in any
+// order.
+func (c ConcurrencyConfig) Key(msg jmsg.Message) string {
+	if c.OrderingKey == "" {
+		return ""
+	}
+	if val, ok := msg.GetHeader(c.OrderingKey); ok {
+		return val
+	}
+	val, _ := msg.GetProperty(c.OrderingKey)
+	return val
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	judoConfig "github.com/amagimedia/judo/v3/config"
+	gredis "github.com/go-redis/redis"
+)
+
+const (
+	// DefaultDedupTTL is how long an ID is remembered when the ttl key is not
+	// set.
+	DefaultDedupTTL = 5 * time.Minute
+	// DefaultDedupSize bounds the in-memory backend when the size key is not
+	// set.
+	DefaultDedupSize = 10000
+)
+
+// Deduplicator decides whether a message has been delivered before.
+type Deduplicator interface {
+	// Seen records id and reports whether it had already been recorded
+	// within the window. An empty id is never seen.
+	Seen(id string) bool
+}
+
+// Forgetter is implemented by deduplicators that can drop an ID before it
+// expires, so that a message that failed can be delivered again.
+type Forgetter interface {
+	Forget(id string)
+}
+
+// Window controls how the TTL of a remembered ID is counted.
+type Window int
+
+const (
+	// FixedWindow forgets an ID TTL after it was first seen.
+	FixedWindow Window = iota
+	// SlidingWindow restarts the TTL every time an ID is seen again, so an
+	// ID that keeps arriving stays suppressed.
+	SlidingWindow
+)
+
+// RedisSetter is the subset of the Redis client used by RedisDedup.
+type RedisSetter interface {
+	SetNX(key string, value interface{}, expiration time.Duration) *gredis.BoolCmd
+	Eval(script string, keys []string, args ...interface{}) *gredis.Cmd
+	Del(keys ...string) *gredis.IntCmd
+}
+
+// slidingScript sets the key or, when it already exists, restarts its TTL.
+// It returns 1 for a duplicate.
+const slidingScript = `
+if redis.call("SET", KEYS[1], 1, "NX", "PX", ARGV[1]) then
+	return 0
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return 1
+`
+
+// RedisDedup remembers IDs as Redis keys that expire on their own. Every
+// decision is a single SET NX, or a script for the sliding window, so
+// concurrent subscribers sharing a Redis agree on which of them delivers a
+// message.
+type RedisDedup struct {
+	Client    RedisSetter
+	Namespace string
+	TTL       time.Duration
+	Window    Window
+}
+
+func (r *RedisDedup) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	key := r.Namespace + ":" + id
+	if r.Window == SlidingWindow {
+		seen, err := r.Client.Eval(slidingScript, []string{key}, int64(r.TTL/time.Millisecond)).Int64()
+		// Delivering twice beats dropping a message while Redis is away.
+		return err == nil && seen == 1
+	}
+	set, err := r.Client.SetNX(key, 1, r.TTL).Result()
+	return err == nil && !set
+}
+
+// Forget deletes the key of id. A failure leaves the ID to expire.
+func (r *RedisDedup) Forget(id string) {
+	if id != "" {
+		r.Client.Del(r.Namespace + ":" + id)
+	}
+}
+
+type memoryEntry struct {
+	id      string
+	expires time.Time
+}
+
+// MemoryDedup remembers up to Size IDs in process, evicting the least
+// recently seen one when full.
+type MemoryDedup struct {
+	TTL    time.Duration
+	Size   int
+	Window Window
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+// NewMemoryDedup returns an in-memory Deduplicator holding at most size IDs.
+func NewMemoryDedup(ttl time.Duration, size int, window Window) *MemoryDedup {
+	return &MemoryDedup{TTL: ttl, Size: size, Window: window}
+}
+
+func (m *MemoryDedup) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]*list.Element)
+		m.order = list.New()
+	}
+	if m.now == nil {
+		m.now = time.Now
+	}
+	now := m.now()
+
+	if elem, ok := m.entries[id]; ok {
+		entry := elem.Value.(*memoryEntry)
+		m.order.MoveToFront(elem)
+		if now.Before(entry.expires) {
+			if m.Window == SlidingWindow {
+				entry.expires = now.Add(m.TTL)
+			}
+			return true
+		}
+		entry.expires = now.Add(m.TTL)
+		return false
+	}
+
+	m.entries[id] = m.order.PushFront(&memoryEntry{id, now.Add(m.TTL)})
+	for m.Size > 0 && m.order.Len() > m.Size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).id)
+	}
+	return false
+}
+
+func (m *MemoryDedup) Forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[id]; ok {
+		m.order.Remove(elem)
+		delete(m.entries, id)
+	}
+}
+
+var dedupmap = map[string]string{
+	"backend":   "Backend",
+	"endpoint":  "Endpoint",
+	"password":  "Password",
+	"namespace": "Namespace",
+	"ttl":       "TTL",
+	"window":    "Window",
+	"size":      "Size",
+}
+
+// DedupConfig is the optional second config of a subscriber.
+type DedupConfig struct {
+	Backend   string
+	Endpoint  string
+	Password  string
+	Namespace string
+	TTL       time.Duration
+	Window    string
+	Size      int
+	judoConfig.TLSConfig
+}
+
+func (c *DedupConfig) GetKeys() []string {
+	return append([]string{
+		"backend",
+		"endpoint",
+		"password",
+		"namespace",
+		"ttl",
+		"window",
+		"size",
+	}, judoConfig.TLSKeys...)
+}
+
+func (c *DedupConfig) GetMandatoryKeys() []string {
+	return []string{}
+}
+
+func (c *DedupConfig) GetField(key string) string {
+	if field, ok := dedupmap[key]; ok {
+		return field
+	}
+	return judoConfig.TLSField(key)
+}
+
+// NewDeduplicator builds a Deduplicator from a subscriber's dedup config,
+// given as a map or a DedupConfig. namespace is used when the config does
+// not name one, so that subscribers sharing a Redis do not suppress each
+// other's messages.
+func NewDeduplicator(cfg interface{}, namespace string) (Deduplicator, error) {
+	c := &DedupConfig{Backend: "redis", Namespace: namespace, TTL: DefaultDedupTTL, Window: "fixed", Size: DefaultDedupSize}
+	cfgHelper := judoConfig.ConfigHelper{Config: c}
+	err := cfgHelper.Load(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var window Window
+	switch c.Window {
+	case "fixed":
+		window = FixedWindow
+	case "sliding":
+		window = SlidingWindow
+	default:
+		return nil, errors.New("Invalid dedup window : " + c.Window)
+	}
+	ttl := c.TTL
+
+	switch c.Backend {
+	case "memory":
+		return NewMemoryDedup(ttl, c.Size, window), nil
+	case "redis":
+		if c.Endpoint == "" {
+			return nil, errors.New("Key Missing : endpoint")
+		}
+		tlsCfg, err := c.ClientTLS(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &RedisDedup{
+			Client: gredis.NewClient(&gredis.Options{
+				Addr:      c.Endpoint,
+				Password:  c.Password,
+				TLSConfig: tlsCfg,
+			}),
+			Namespace: c.Namespace,
+			TTL:       ttl,
+			Window:    window,
+		}, nil
+	}
+	return nil, errors.New("Invalid dedup backend : " + c.Backend)
+}
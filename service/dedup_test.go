@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gredis "github.com/go-redis/redis"
+)
+
+// fakeRedis keeps keys with their expiry on a fake clock. Like Redis it runs
+// one command or script at a time.
+type fakeRedis struct {
+	mu   sync.Mutex
+	now  time.Time
+	keys map[string]time.Time
+	err  error
+}
+
+func (f *fakeRedis) setNX(key string, expiration time.Duration) bool {
+	if expires, ok := f.keys[key]; ok && f.now.Before(expires) {
+		return false
+	}
+	f.keys[key] = f.now.Add(expiration)
+	return true
+}
+
+func (f *fakeRedis) SetNX(key string, value interface{}, expiration time.Duration) *gredis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return gredis.NewBoolResult(false, f.err)
+	}
+	return gredis.NewBoolResult(f.setNX(key, expiration), nil)
+}
+
+// Eval runs slidingScript.
+func (f *fakeRedis) Eval(script string, keys []string, args ...interface{}) *gredis.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return gredis.NewCmdResult(nil, f.err)
+	}
+	ttl := time.Duration(args[0].(int64)) * time.Millisecond
+	if f.setNX(keys[0], ttl) {
+		return gredis.NewCmdResult(int64(0), nil)
+	}
+	f.keys[keys[0]] = f.now.Add(ttl)
+	return gredis.NewCmdResult(int64(1), nil)
+}
+
+func (f *fakeRedis) Del(keys ...string) *gredis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		delete(f.keys, key)
+	}
+	return gredis.NewIntResult(int64(len(keys)), nil)
+}
+
+func TestDeduplicator(t *testing.T) {
+	start := time.Now()
+
+	cases := []struct {
+		name   string
+		window Window
+	}{
+		{"memory-fixed", FixedWindow},
+		{"memory-sliding", SlidingWindow},
+		{"redis-fixed", FixedWindow},
+		{"redis-sliding", SlidingWindow},
+	}
+
+	for _, c := range cases {
+		var dedup Deduplicator
+		var advance func(time.Duration)
+		switch c.name {
+		case "memory-fixed", "memory-sliding":
+			m := NewMemoryDedup(time.Minute, 10, c.window)
+			now := start
+			m.now = func() time.Time { return now }
+			advance = func(d time.Duration) { now = now.Add(d) }
+			dedup = m
+		case "redis-fixed", "redis-sliding":
+			f := &fakeRedis{now: start, keys: map[string]time.Time{}}
+			advance = func(d time.Duration) { f.now = f.now.Add(d) }
+			dedup = &RedisDedup{Client: f, Namespace: "svc", TTL: time.Minute, Window: c.window}
+		}
+
+		if dedup.Seen("") || dedup.Seen("") {
+			t.Errorf("%s: empty ID reported as duplicate", c.name)
+		}
+		if dedup.Seen("a") {
+			t.Errorf("%s: first copy reported as duplicate", c.name)
+		}
+		if !dedup.Seen("a") || !dedup.Seen("a") {
+			t.Errorf("%s: later copies not reported as duplicates", c.name)
+		}
+		advance(40 * time.Second)
+		if !dedup.Seen("a") {
+			t.Errorf("%s: copy within the window not reported", c.name)
+		}
+		advance(40 * time.Second)
+		// 80s after the first copy and 40s after the last one.
+		if got := dedup.Seen("a"); got != (c.window == SlidingWindow) {
+			t.Errorf("%s: unexpected result after the TTL, got %v", c.name, got)
+		}
+		dedup.Seen("b")
+		dedup.(Forgetter).Forget("b")
+		if dedup.Seen("b") {
+			t.Errorf("%s: forgotten ID reported as duplicate", c.name)
+		}
+	}
+}
+
+func TestMemoryDedupEviction(t *testing.T) {
+	m := NewMemoryDedup(time.Minute, 2, FixedWindow)
+	m.Seen("a")
+	m.Seen("b")
+	m.Seen("a")
+	m.Seen("c")
+	if !m.Seen("a") {
+		t.Error("Recently seen ID was evicted")
+	}
+	if m.Seen("b") {
+		t.Error("Least recently seen ID was kept")
+	}
+}
+
+func TestRedisDedup(t *testing.T) {
+	f := &fakeRedis{keys: map[string]time.Time{}}
+	first := &RedisDedup{Client: f, Namespace: "first", TTL: time.Minute}
+	second := &RedisDedup{Client: f, Namespace: "second", TTL: time.Minute}
+	first.Seen("a")
+	if second.Seen("a") {
+		t.Error("Namespaces are not separated")
+	}
+	if _, ok := f.keys["first:a"]; !ok {
+		t.Error("Unexpected key layout", f.keys)
+	}
+
+	f.err = errors.New("connection refused")
+	if first.Seen("a") {
+		t.Error("Message dropped while Redis is unavailable")
+	}
+}
+
+func TestNewDeduplicator(t *testing.T) {
+	cases := []struct {
+		name   string
+		config map[string]interface{}
+		err    error
+	}{
+		{"redis", map[string]interface{}{"endpoint": ":6379", "password": "", "ttl": "1m", "window": "sliding"}, nil},
+		{"memory", map[string]interface{}{"backend": "memory", "size": 5.0}, nil},
+		{"no-endpoint", map[string]interface{}{"password": ""}, errors.New("Key Missing : endpoint")},
+		{"bad-window", map[string]interface{}{"backend": "memory", "window": "tumbling"}, errors.New("Invalid dedup window : tumbling")},
+		{"bad-backend", map[string]interface{}{"backend": "etcd"}, errors.New("Invalid dedup backend : etcd")},
+	}
+	for _, c := range cases {
+		dedup, err := NewDeduplicator(c.config, "svc")
+		switch c.name {
+		case "redis":
+			r, ok := dedup.(*RedisDedup)
+			if err != nil || !ok || r.Namespace != "svc" || r.TTL != time.Minute || r.Window != SlidingWindow {
+				t.Error("Unexpected redis deduplicator", dedup, err)
+			}
+		case "memory":
+			m, ok := dedup.(*MemoryDedup)
+			if err != nil || !ok || m.Size != 5 || m.TTL != DefaultDedupTTL {
+				t.Error("Unexpected memory deduplicator", dedup, err)
+			}
+		default:
+			if err == nil || err.Error() != c.err.Error() {
+				t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+			}
+		}
+	}
+}
+
+func TestDeduplicatorConcurrent(t *testing.T) {
+	cases := []string{"memory", "redis-fixed", "redis-sliding"}
+	for _, c := range cases {
+		var dedup Deduplicator
+		switch c {
+		case "memory":
+			dedup = NewMemoryDedup(time.Minute, 100, FixedWindow)
+		case "redis-fixed":
+			dedup = &RedisDedup{Client: &fakeRedis{now: time.Now(), keys: map[string]time.Time{}}, TTL: time.Minute}
+		case "redis-sliding":
+			dedup = &RedisDedup{Client: &fakeRedis{now: time.Now(), keys: map[string]time.Time{}}, TTL: time.Minute, Window: SlidingWindow}
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		delivered := 0
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !dedup.Seen("a") {
+					mu.Lock()
+					delivered++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if delivered != 1 {
+			t.Errorf("%s: expected exactly one delivery, got %d", c, delivered)
+		}
+	}
+}
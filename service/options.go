@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/amagimedia/judo/v3/client"
+	"github.com/amagimedia/judo/v3/logger"
+	"github.com/amagimedia/judo/v3/metrics"
+)
+
+// Option sets up the parts of a subscriber or replier that do not depend on
+// its protocol. Options are applied with Apply, after Configure.
+type Option func(interface{}) error
+
+// WithDeduplicator drops the messages d has seen, in place of a dedup config.
+func WithDeduplicator(d Deduplicator) Option {
+	return func(c interface{}) error {
+		s, ok := c.(interface{ SetDeduplicator(Deduplicator) })
+		if !ok {
+			return errors.New("Option not supported : deduplicator")
+		}
+		s.SetDeduplicator(d)
+		return nil
+	}
+}
+
+// WithRetryPolicy retries the messages the callback nacks according to p.
+func WithRetryPolicy(p *RetryPolicy) Option {
+	return func(c interface{}) error {
+		s, ok := c.(interface{ SetRetryPolicy(*RetryPolicy) })
+		if !ok {
+			return errors.New("Option not supported : retry policy")
+		}
+		s.SetRetryPolicy(p)
+		return nil
+	}
+}
+
+// WithMetrics records the metrics of the client in m.
+func WithMetrics(m metrics.Recorder) Option {
+	return func(c interface{}) error {
+		s, ok := c.(interface{ SetMetrics(metrics.Recorder) })
+		if !ok {
+			return errors.New("Option not supported : metrics")
+		}
+		s.SetMetrics(m)
+		return nil
+	}
+}
+
+// WithLogger routes the internal events and errors of the client to l.
+func WithLogger(l logger.Logger) Option {
+	return func(c interface{}) error {
+		s, ok := c.(interface{ SetLogger(logger.Logger) })
+		if !ok {
+			return errors.New("Option not supported : logger")
+		}
+		s.SetLogger(l)
+		return nil
+	}
+}
+
+// Apply applies opts to c, looking through client middleware, and returns
+// the first error.
+func Apply(c client.JudoClient, opts ...Option) error {
+	for {
+		wrapped, ok := c.(interface{ Unwrap() client.JudoClient })
+		if !ok {
+			break
+		}
+		c = wrapped.Unwrap()
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amagimedia/judo/v3/client"
+	"github.com/amagimedia/judo/v3/logger"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+)
+
+type optionClient struct {
+	dedup   Deduplicator
+	retry   *RetryPolicy
+	metrics metrics.Recorder
+}
+
+func (c *optionClient) Configure([]interface{}) error                  { return nil }
+func (c *optionClient) OnMessage(func(jmsg.Message)) client.JudoClient { return c }
+func (c *optionClient) Start() (<-chan error, error)                   { return nil, nil }
+func (c *optionClient) Close() error                                   { return nil }
+func (c *optionClient) SetDeduplicator(d Deduplicator)                 { c.dedup = d }
+func (c *optionClient) SetRetryPolicy(p *RetryPolicy)                  { c.retry = p }
+func (c *optionClient) SetMetrics(m metrics.Recorder)                  { c.metrics = m }
+
+func TestApply(t *testing.T) {
+	c := &optionClient{}
+	dedup := NewMemoryDedup(time.Minute, 10, FixedWindow)
+	retry := &RetryPolicy{MaxAttempts: 3}
+	reg := metrics.NewRegistry()
+
+	err := Apply(client.Use(c), WithDeduplicator(dedup), WithRetryPolicy(retry), WithMetrics(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.dedup != dedup || c.retry != retry || c.metrics != reg {
+		t.Errorf("Options not applied through middleware: %+v", c)
+	}
+
+	err = Apply(c, WithLogger(logger.Nop))
+	if err == nil || err.Error() != "Option not supported : logger" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/publisher"
+	gredis "github.com/go-redis/redis"
+)
+
+// RetryPolicy retries messages that the callback nacks. The attempt number
+// is kept in the jmsg.AttemptsHeader header, so a message that is
+// republished or dead-lettered carries its count with it. Subscribers apply
+// it through Runtime.Deliver.
+type RetryPolicy struct {
+	// MaxAttempts counts the first delivery. Below one it is one.
+	MaxAttempts int
+	// Backoff spaces out the retries.
+	Backoff Backoff
+	// DeadLetter receives messages that failed every attempt. Without one
+	// they are logged, counted as dropped and acknowledged.
+	DeadLetter DeadLetter
+}
+
+// DeadLetter takes a message that exhausted its retries. It settles the
+// message with the transport, usually by acknowledging it once it is safely
+// stored elsewhere.
+type DeadLetter interface {
+	DeadLetter(jmsg.Message) error
+}
+
+// Attempts returns the number of attempts already made on msg.
+func Attempts(msg jmsg.Message) int {
+	val, _ := msg.GetHeader(jmsg.AttemptsHeader)
+	n, _ := strconv.Atoi(val)
+	return n
+}
+
+// RetryConfig holds the keys that give a subscriber a retry policy. Without
+// maxAttempts it has none. Zero intervals fall back to 1s initial and 30s
+// max, and a zero multiplier to 2. DeadLetter may be "reject", for
+// RejectDeadLetter; by default failed messages are dropped.
+type RetryConfig struct {
+	MaxAttempts      int
+	RetryInterval    time.Duration
+	RetryMaxInterval time.Duration
+	RetryMultiplier  float64
+	RetryJitter      float64
+	DeadLetter       string
+}
+
+// RetryKeys lists the config keys of RetryConfig, to be appended to the keys
+// of the embedding config.
+var RetryKeys = []string{
+	"maxAttempts",
+	"retryInterval",
+	"retryMaxInterval",
+	"retryMultiplier",
+	"retryJitter",
+	"deadLetter",
+}
+
+var retrymap = map[string]string{
+	"maxAttempts":      "MaxAttempts",
+	"retryInterval":    "RetryInterval",
+	"retryMaxInterval": "RetryMaxInterval",
+	"retryMultiplier":  "RetryMultiplier",
+	"retryJitter":      "RetryJitter",
+	"deadLetter":       "DeadLetter",
+}
+
+// RetryField maps a retry key to its field name.
+func RetryField(key string) string {
+	return retrymap[key]
+}
+
+// RetryPolicy returns the policy set up by the retry keys, or nil without
+// maxAttempts.
+func (c RetryConfig) RetryPolicy() (*RetryPolicy, error) {
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		return nil, errors.New("Invalid Value for config retryJitter, expected between 0 and 1")
+	}
+	var deadLetter DeadLetter
+	switch c.DeadLetter {
+	case "":
+	case "reject":
+		deadLetter = RejectDeadLetter{}
+	default:
+		return nil, errors.New("Invalid Value for config deadLetter, expected reject")
+	}
+	if c.MaxAttempts < 1 {
+		return nil, nil
+	}
+	return &RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		Backoff:     backoff(c.RetryInterval, c.RetryMaxInterval, c.RetryMultiplier, c.RetryJitter),
+		DeadLetter:  deadLetter,
+	}, nil
+}
+
+// retry settles a failed attempt of msg and reports whether msg is to be
+// dispatched again. It then holds no worker while it waits, and is nacked
+// with the transport, which decides on redelivery, when Shutdown is called
+// first. Deliver calls it once the callback of the attempt has returned.
+func (r *Runtime) retry(p *RetryPolicy, key string, msg jmsg.Message, s *jmsg.Settlement, run func()) bool {
+	attempt := Attempts(msg)
+	if attempt >= p.MaxAttempts {
+		s.Quietly(func() { r.deadLetter(p, msg) })
+		return false
+	}
+	if rp, ok := msg.(jmsg.Republisher); ok {
+		s.Quietly(func() {
+			if err := rp.Republish(); err != nil {
+				id, _ := msg.GetProperty("message_id")
+				r.Log().Error("Unable to republish message for retry", "message_id", id, "err", err)
+				msg.SendNack()
+				return
+			}
+			msg.SendAck()
+		})
+		return false
+	}
+	r.after(p.Backoff.Duration(attempt), func() {
+		if !r.Dispatch(key, run) {
+			s.Quietly(func() { msg.SendNack() })
+			s.Done()
+		}
+	})
+	return true
+}
+
+// after calls fn once d has passed, or straight away once Shutdown has been
+// called. Shutdown waits for fn.
+func (r *Runtime) after(d time.Duration, fn func()) {
+	done := r.Done()
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+		}
+		fn()
+	}()
+}
+
+// deadLetter hands msg over to the dead letter of p. Messages that nothing
+// takes are logged, counted and acknowledged.
+func (r *Runtime) deadLetter(p *RetryPolicy, msg jmsg.Message) {
+	id, _ := msg.GetProperty("message_id")
+	_, rejecter := msg.(Rejecter)
+	if _, reject := p.DeadLetter.(RejectDeadLetter); p.DeadLetter == nil || reject && !rejecter {
+		r.Log().Warn("Dropped message after its last attempt", "message_id", id, "attempts", Attempts(msg))
+		r.count(metrics.Dropped)
+		msg.SendAck()
+		return
+	}
+	if err := p.DeadLetter.DeadLetter(msg); err != nil {
+		r.Log().Error("Unable to dead-letter message", "message_id", id, "err", err)
+		msg.SendNack()
+	}
+}
+
+// PublisherDeadLetter republishes failed messages, headers included, through
+// a judo publisher.
+type PublisherDeadLetter struct {
+	Publisher publisher.JudoPub
+	Subject   string
+}
+
+func (d PublisherDeadLetter) DeadLetter(msg jmsg.Message) error {
+	err := publisher.PublishWithHeaders(d.Publisher, d.Subject, msg.GetMessage(), msg.GetHeaders())
+	if err != nil {
+		return err
+	}
+	msg.SendAck()
+	return nil
+}
+
+// RedisPusher is the subset of the Redis client used by RedisListDeadLetter.
+type RedisPusher interface {
+	LPush(key string, values ...interface{}) *gredis.IntCmd
+}
+
+// RedisListDeadLetter pushes failed messages onto a Redis list as envelopes,
+// keeping their ID and headers.
+type RedisListDeadLetter struct {
+	Client RedisPusher
+	Key    string
+}
+
+func (d RedisListDeadLetter) DeadLetter(msg jmsg.Message) error {
+	env := jmsg.NewEnvelope(msg.GetMessage())
+	if id, ok := msg.GetProperty("message_id"); ok {
+		env.ID = id
+	}
+	env.Headers = msg.GetHeaders()
+	err := d.Client.LPush(d.Key, jmsg.Encode(env)).Err()
+	if err != nil {
+		return err
+	}
+	msg.SendAck()
+	return nil
+}
+
+// Rejecter is implemented by messages the broker can dead-letter itself.
+type Rejecter interface {
+	SendReject()
+}
+
+// RejectDeadLetter rejects failed messages without requeueing them, leaving
+// it to the broker to route them, as an AMQP queue with a
+// x-dead-letter-exchange does. Other messages are acknowledged and dropped,
+// and a retry policy logs and counts them as it does without a dead letter.
+type RejectDeadLetter struct{}
+
+func (RejectDeadLetter) DeadLetter(msg jmsg.Message) error {
+	if r, ok := msg.(Rejecter); ok {
+		r.SendReject()
+		return nil
+	}
+	msg.SendAck()
+	return nil
+}
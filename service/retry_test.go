@@ -0,0 +1,225 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amagimedia/judo/v3/logger"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/message/mocks"
+	"github.com/amagimedia/judo/v3/metrics"
+	gredis "github.com/go-redis/redis"
+)
+
+type fakePub struct {
+	subject string
+	msg     []byte
+	headers map[string]string
+	err     error
+}
+
+func (p *fakePub) Connect([]interface{}) error { return nil }
+func (p *fakePub) Close() error                { return nil }
+func (p *fakePub) Publish(subject string, msg []byte) error {
+	return p.PublishWithHeaders(subject, msg, nil)
+}
+func (p *fakePub) PublishWithHeaders(subject string, msg []byte, headers map[string]string) error {
+	p.subject, p.msg, p.headers = subject, msg, headers
+	return p.err
+}
+
+type fakeList struct {
+	key    string
+	values []interface{}
+}
+
+func (l *fakeList) LPush(key string, values ...interface{}) *gredis.IntCmd {
+	l.key = key
+	l.values = append(l.values, values...)
+	return gredis.NewIntResult(int64(len(l.values)), nil)
+}
+
+func newRedisMessage() *jmsg.RedisMessage {
+	return &jmsg.RedisMessage{
+		RawMessage: jmsg.RedisRawMessage{Message: &gredis.Message{Payload: "poison"}},
+		Properties: map[string]string{"message_id": "42"},
+		Header:     map[string]string{"tenant": "a"},
+		Settlement: &jmsg.Settlement{},
+	}
+}
+
+// republished is a message that can be put back on its queue.
+type republished struct {
+	*jmsg.RedisMessage
+	attempts []string
+}
+
+func (m *republished) Republish() error {
+	val, _ := m.GetHeader(jmsg.AttemptsHeader)
+	m.attempts = append(m.attempts, val)
+	return nil
+}
+
+// deliver delivers msg to callback through r and waits until r is done with
+// it.
+func deliver(t *testing.T, r *Runtime, msg jmsg.Message, s *jmsg.Settlement, callback func(jmsg.Message)) {
+	done := make(chan struct{})
+	s.OnDone(func() { close(done) })
+	r.Deliver("", msg, s, callback)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Message never done")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	backoff := Backoff{Initial: time.Millisecond, Multiplier: 1}
+	cases := []string{"nil-policy", "recover", "drop", "publisher", "publisher-err", "redis-list", "reject", "closed", "carried", "republish", "overlap", "config"}
+
+	for _, c := range cases {
+		msg := newRedisMessage()
+		reg := metrics.NewRegistry()
+		var logs bytes.Buffer
+		r := &Runtime{Metrics: reg, Logger: logger.New(&logs, logger.LevelWarn)}
+		r.Open()
+		calls := 0
+		failing := func(m jmsg.Message) {
+			calls++
+			m.SendNack()
+		}
+		switch c {
+		case "nil-policy":
+			deliver(t, r, msg, msg.Settlement, failing)
+			if calls != 1 {
+				t.Errorf("%s: expected one call, got %d", c, calls)
+			}
+			if val, _ := msg.GetProperty("ack"); val != "NOK" {
+				t.Errorf("%s: nack not passed on", c)
+			}
+		case "recover":
+			r.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: backoff}
+			deliver(t, r, msg, msg.Settlement, func(m jmsg.Message) {
+				calls++
+				if calls < 2 {
+					m.SendNack()
+					return
+				}
+				m.SendAck()
+			})
+			if val, _ := msg.GetProperty("ack"); calls != 2 || val != "OK" {
+				t.Errorf("%s: expected ack on the second call, got %d calls and %q", c, calls, val)
+			}
+			if val, _ := msg.GetHeader(jmsg.AttemptsHeader); val != "2" {
+				t.Errorf("%s: unexpected attempts header %q", c, val)
+			}
+		case "drop":
+			r.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: backoff}
+			deliver(t, r, msg, msg.Settlement, failing)
+			if val, _ := msg.GetProperty("ack"); calls != 3 || val != "OK" {
+				t.Errorf("%s: expected ack after 3 calls, got %d calls and %q", c, calls, val)
+			}
+			if reg.Counter(metrics.Dropped, metrics.Labels{}) != 1 {
+				t.Errorf("%s: dropped message not counted", c)
+			}
+			if !strings.Contains(logs.String(), "Dropped message after its last attempt") {
+				t.Errorf("%s: dropped message not logged: %q", c, logs.String())
+			}
+		case "publisher", "publisher-err":
+			pub := &fakePub{}
+			if c == "publisher-err" {
+				pub.err = errors.New("Unable to publish")
+			}
+			r.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: backoff, DeadLetter: PublisherDeadLetter{Publisher: pub, Subject: "dead"}}
+			deliver(t, r, msg, msg.Settlement, failing)
+			if pub.subject != "dead" || string(pub.msg) != "poison" || pub.headers[jmsg.AttemptsHeader] != "2" || pub.headers["tenant"] != "a" {
+				t.Errorf("%s: unexpected dead letter %+v", c, pub)
+			}
+			want := "OK"
+			if c == "publisher-err" {
+				want = "NOK"
+			}
+			if val, _ := msg.GetProperty("ack"); val != want {
+				t.Errorf("%s: expected %q, got %q", c, want, val)
+			}
+		case "redis-list":
+			list := &fakeList{}
+			r.Retry = &RetryPolicy{MaxAttempts: 1, DeadLetter: RedisListDeadLetter{Client: list, Key: "dead"}}
+			deliver(t, r, msg, msg.Settlement, failing)
+			if len(list.values) != 1 || list.key != "dead" {
+				t.Fatalf("%s: unexpected push %+v", c, list)
+			}
+			env := jmsg.Decode(list.values[0].([]byte))
+			if env.ID != "42" || string(env.Body) != "poison" || env.Headers[jmsg.AttemptsHeader] != "1" {
+				t.Errorf("%s: unexpected envelope %+v", c, env)
+			}
+		case "reject":
+			raw := &mocks.RawMessage{}
+			raw.On("Nack", false, false).Return(nil).Once()
+			amqpMsg := jmsg.AmqpMessage{RawMessage: raw, Properties: map[string]string{}, Header: map[string]string{}, Settlement: &jmsg.Settlement{}}
+			r.Retry = &RetryPolicy{MaxAttempts: 1, DeadLetter: RejectDeadLetter{}}
+			deliver(t, r, amqpMsg, amqpMsg.Settlement, failing)
+			raw.AssertExpectations(t)
+		case "closed":
+			r.Retry = &RetryPolicy{MaxAttempts: 5, Backoff: Backoff{Initial: time.Hour}}
+			r.Deliver("", msg, msg.Settlement, failing)
+			r.Shutdown()
+			if val, _ := msg.GetProperty("ack"); calls != 1 || val != "NOK" {
+				t.Errorf("%s: expected the nack to be passed on, got %d calls and %q", c, calls, val)
+			}
+		case "carried":
+			msg.SetHeader(jmsg.AttemptsHeader, "4")
+			r.Retry = &RetryPolicy{MaxAttempts: 5, Backoff: backoff}
+			deliver(t, r, msg, msg.Settlement, failing)
+			if calls != 1 {
+				t.Errorf("%s: earlier attempts not counted, got %d calls", c, calls)
+			}
+		case "republish":
+			rmsg := &republished{RedisMessage: msg}
+			r.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: Backoff{Initial: time.Hour}}
+			deliver(t, r, rmsg, msg.Settlement, failing)
+			if val, _ := msg.GetProperty("ack"); calls != 1 || val != "OK" || len(rmsg.attempts) != 1 || rmsg.attempts[0] != "1" {
+				t.Errorf("%s: expected one republished copy, got %d calls, %q and %v", c, calls, val, rmsg.attempts)
+			}
+		case "overlap":
+			// The callback goes on well past the backoff after its nack.
+			r.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: backoff}
+			var running, overlapped, dones int32
+			msg.Settlement.OnDone(func() { atomic.AddInt32(&dones, 1) })
+			deliver(t, r, msg, msg.Settlement, func(m jmsg.Message) {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.StoreInt32(&overlapped, 1)
+				}
+				defer atomic.AddInt32(&running, -1)
+				m.SendNack()
+				time.Sleep(20 * time.Millisecond)
+			})
+			time.Sleep(30 * time.Millisecond)
+			if atomic.LoadInt32(&overlapped) == 1 {
+				t.Errorf("%s: attempts overlapped", c)
+			}
+			if n := atomic.LoadInt32(&dones); n != 1 {
+				t.Errorf("%s: expected one done, got %d", c, n)
+			}
+		case "config":
+			for _, cfg := range []RetryConfig{{}, {MaxAttempts: 3, DeadLetter: "reject"}, {MaxAttempts: 3, RetryJitter: 2}, {MaxAttempts: 3, DeadLetter: "queue"}} {
+				p, err := cfg.RetryPolicy()
+				switch {
+				case cfg.MaxAttempts == 0 && (p != nil || err != nil):
+					t.Errorf("%s: expected no policy, got %+v and %v", c, p, err)
+				case cfg.RetryJitter > 1 || cfg.DeadLetter == "queue":
+					if err == nil {
+						t.Errorf("%s: invalid config %+v accepted", c, cfg)
+					}
+				case cfg.MaxAttempts > 0 && (err != nil || p.MaxAttempts != 3 || p.DeadLetter != RejectDeadLetter{} || p.Backoff.Initial != time.Second):
+					t.Errorf("%s: unexpected policy %+v and %v", c, p, err)
+				}
+			}
+		}
+		r.Shutdown()
+	}
+}
@@ -0,0 +1,425 @@
+package service
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amagimedia/judo/v3/client"
+	"github.com/amagimedia/judo/v3/logger"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/metrics"
+	"github.com/amagimedia/judo/v3/redact"
+)
+
+const (
+	statusBuffer = 16
+	workerBuffer = 16
+)
+
+// Runtime tracks the callbacks a subscriber has in flight so that Close can
+// wait for them, and lets receive goroutines report errors without blocking
+// forever once nobody is listening any more.
+type Runtime struct {
+	// Workers is the number of callbacks Dispatch runs at once. With one or
+	// fewer they run on the dispatching goroutine. It is read by Open.
+	Workers int
+
+	// Metrics, when set, receives the counts and handler latencies of the
+	// subscriber, labelled with Labels.
+	Metrics metrics.Recorder
+	Labels  metrics.Labels
+
+	// Logger, when set, receives the internal events and errors of the
+	// subscriber through Log.
+	Logger logger.Logger
+
+	// Middleware wraps the callbacks passed to OnMessage, see client.Use.
+	Middleware []client.Middleware
+
+	// Retry, when set, is the policy Deliver applies to the messages the
+	// callback nacks.
+	Retry *RetryPolicy
+
+	// Secrets, such as the password of the subscriber, are masked in the
+	// errors and logs the runtime passes on. Once the runtime is shared with
+	// running goroutines, replace them with SetSecrets.
+	Secrets []string
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	done   chan struct{}
+	closed bool
+	// ctx is the parent of the contexts of the callback calls, cancelled by
+	// Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+	status chan client.Status
+	keyed  []chan func()
+	shared chan func()
+	// sending counts the Dispatch calls handing a callback to a worker,
+	// which Shutdown waits for before closing the queues.
+	sending sync.WaitGroup
+	lost    bool
+}
+
+// Open prepares the runtime for a new Start and starts the workers.
+func (r *Runtime) Open() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = make(chan struct{})
+	r.closed = false
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.keyed, r.shared = nil, nil
+	if r.Workers <= 1 {
+		return
+	}
+	r.shared = make(chan func())
+	r.keyed = make([]chan func(), r.Workers)
+	r.wg.Add(r.Workers)
+	for i := range r.keyed {
+		r.keyed[i] = make(chan func(), workerBuffer)
+		go r.work(r.keyed[i], r.shared)
+	}
+}
+
+// work runs the callbacks of its queues until both are closed, so that the
+// callbacks still queued at Shutdown run too.
+func (r *Runtime) work(keyed, shared <-chan func()) {
+	defer r.wg.Done()
+	for keyed != nil || shared != nil {
+		select {
+		case fn, ok := <-keyed:
+			if !ok {
+				keyed = nil
+				continue
+			}
+			fn()
+		case fn, ok := <-shared:
+			if !ok {
+				shared = nil
+				continue
+			}
+			fn()
+		}
+	}
+}
+
+// Dispatch runs fn as a tracked callback, on one of the workers when there
+// are several. Callbacks with the same non-empty key always go to the same
+// worker and so run one at a time, in the order they were dispatched. Others
+// go to whichever worker is free. Dispatch blocks while the workers are busy
+// and returns false once Shutdown has been called. Callbacks it has queued
+// run even when Shutdown is called before they start.
+func (r *Runtime) Dispatch(key string, fn func()) bool {
+	r.mu.Lock()
+	keyed, shared, closed := r.keyed, r.shared, r.closed
+	if keyed == nil || closed {
+		r.mu.Unlock()
+		return r.Track(fn)
+	}
+	r.sending.Add(1)
+	r.mu.Unlock()
+	defer r.sending.Done()
+
+	queue := shared
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		queue = keyed[h.Sum32()%uint32(len(keyed))]
+	}
+	queue <- fn
+	return true
+}
+
+// Track runs fn as an in-flight callback. Once Shutdown has been called it
+// returns false without running fn.
+func (r *Runtime) Track(fn func()) bool {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return false
+	}
+	r.wg.Add(1)
+	r.mu.Unlock()
+	defer r.wg.Done()
+	fn()
+	return true
+}
+
+// Recover guards callback against panics. The message of a panicking callback
+// is nacked and the panic is reported on ec as a *client.PanicError, without
+// holding up the callback until someone reads ec.
+func (r *Runtime) Recover(ec chan<- error, callback func(jmsg.Message)) func(jmsg.Message) {
+	return func(msg jmsg.Message) {
+		defer func() {
+			if val := recover(); val != nil {
+				msg.SendNack()
+				r.Log().Error("Recovered from panic in callback", "panic", val)
+				if ec != nil {
+					go r.Report(ec, &client.PanicError{Value: val, Stack: debug.Stack()})
+				}
+			}
+		}()
+		callback(msg)
+	}
+}
+
+// Received counts a message that arrived from the transport.
+func (r *Runtime) Received() {
+	r.count(metrics.Received)
+}
+
+// Admit counts a received message and reports whether it should be delivered,
+// that is whether d, which may be nil, has not seen id before. If d is a
+// Forgetter, id is forgotten when the message is nacked through s, so that
+// the message is not dropped when it is delivered again.
+func (r *Runtime) Admit(d Deduplicator, id string, s *jmsg.Settlement) bool {
+	r.Received()
+	if d == nil {
+		return true
+	}
+	if d.Seen(id) {
+		r.count(metrics.Duplicates)
+		r.Log().Debug("Dropped duplicate message", "message_id", id)
+		return false
+	}
+	if f, ok := d.(Forgetter); ok && id != "" && s != nil {
+		s.OnNack(func() { f.Forget(id) })
+	}
+	return true
+}
+
+// Deliver dispatches callback with msg as Dispatch does with key, and then
+// calls s.Done. The acks and nacks of msg are counted through s, and each
+// call gets a context through s, see jmsg.ContextOf. Under the Retry policy
+// the nacks of msg, observed through s, are taken over until the policy gives
+// up on msg: a jmsg.Republisher is put back on its queue with its attempt
+// count, and any other message is dispatched again after a backoff, s.Done
+// waiting for the last attempt. A nack is acted upon once the callback that
+// sent it has returned.
+func (r *Runtime) Deliver(key string, msg jmsg.Message, s *jmsg.Settlement, callback func(jmsg.Message)) bool {
+	callback = r.instrument(s, r.scope(s, callback))
+	p := r.Retry
+	if p == nil || s == nil {
+		return r.Dispatch(key, func() {
+			callback(msg)
+			s.Done()
+		})
+	}
+	// A nack only marks the attempt as failed. The attempt acts on it once
+	// its callback has returned, so that the next attempt never overlaps it.
+	var mu sync.Mutex
+	var running, nacked bool
+	var run func()
+	run = func() {
+		msg.SetHeader(jmsg.AttemptsHeader, strconv.Itoa(Attempts(msg)+1))
+		mu.Lock()
+		running, nacked = true, false
+		mu.Unlock()
+		callback(msg)
+		mu.Lock()
+		running = false
+		failed := nacked
+		mu.Unlock()
+		if !failed || !r.retry(p, key, msg, s, run) {
+			s.Done()
+		}
+	}
+	s.Intercept(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		// A nack sent after the callback returned goes to the transport.
+		if !running {
+			return false
+		}
+		nacked = true
+		return true
+	})
+	return r.Dispatch(key, run)
+}
+
+// scope gives each call of callback a context, which jmsg.ContextOf returns
+// for the message. It is cancelled when the call returns or when Shutdown is
+// called.
+func (r *Runtime) scope(s *jmsg.Settlement, callback func(jmsg.Message)) func(jmsg.Message) {
+	if s == nil {
+		return callback
+	}
+	return func(msg jmsg.Message) {
+		r.mu.Lock()
+		parent := r.ctx
+		r.mu.Unlock()
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithCancel(parent)
+		defer cancel()
+		s.SetContext(ctx)
+		callback(msg)
+	}
+}
+
+// instrument counts the acks and nacks sent through s and records how long
+// each call of callback takes. Without Metrics it returns callback unchanged.
+func (r *Runtime) instrument(s *jmsg.Settlement, callback func(jmsg.Message)) func(jmsg.Message) {
+	if r.Metrics == nil {
+		return callback
+	}
+	if s != nil {
+		s.OnAck(func() { r.count(metrics.Acked) })
+		s.OnNack(func() { r.count(metrics.Nacked) })
+	}
+	return func(msg jmsg.Message) {
+		start := time.Now()
+		defer func() {
+			r.Metrics.Observe(metrics.HandlerTime, r.Labels, time.Since(start).Seconds())
+		}()
+		callback(msg)
+	}
+}
+
+func (r *Runtime) count(name string) {
+	if r.Metrics != nil {
+		r.Metrics.Inc(name, r.Labels)
+	}
+}
+
+// Log returns Logger with the protocol and topic of Labels attached and
+// credentials redacted, or logger.Nop when no Logger is set.
+func (r *Runtime) Log() logger.Logger {
+	if r.Logger == nil {
+		return logger.Nop
+	}
+	return redact.Logger(logger.With(r.Logger, "protocol", r.Labels.Protocol, "topic", r.Labels.Topic), r.secrets()...)
+}
+
+// Redact masks Secrets, and the credentials of URLs, in the message of err.
+func (r *Runtime) Redact(err error) error {
+	return redact.Error(err, r.secrets()...)
+}
+
+// SetSecrets replaces Secrets. Subscribers call it from Configure, which may
+// run again while the goroutines of an earlier Start still log.
+func (r *Runtime) SetSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Secrets = secrets
+}
+
+func (r *Runtime) secrets() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Secrets
+}
+
+// Done is closed when Shutdown is called.
+func (r *Runtime) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	return r.done
+}
+
+// Report sends err, redacted, on ec, giving up once the runtime is shut
+// down.
+func (r *Runtime) Report(ec chan<- error, err error) {
+	select {
+	case ec <- r.Redact(err):
+	case <-r.Done():
+	}
+}
+
+// Stop stops new callbacks from being dispatched and cancels the contexts of
+// the ones in flight, without waiting for them. Callbacks still queued for a
+// worker run all the same. It is the way for a callback, which Shutdown would
+// wait for, to stop the runtime.
+func (r *Runtime) Stop() {
+	r.stop(false)
+}
+
+// Shutdown stops the runtime as Stop does, and then waits for the callbacks in
+// flight, including those still queued for a worker. A callback calling it
+// waits for itself: it must call Stop instead.
+func (r *Runtime) Shutdown() {
+	r.stop(true)
+	r.wg.Wait()
+}
+
+func (r *Runtime) stop(wait bool) {
+	r.mu.Lock()
+	first := !r.closed
+	if first {
+		r.closed = true
+		if r.done == nil {
+			r.done = make(chan struct{})
+		}
+		close(r.done)
+		if r.cancel != nil {
+			r.cancel()
+		}
+	}
+	keyed, shared := r.keyed, r.shared
+	r.mu.Unlock()
+
+	if !first || keyed == nil {
+		return
+	}
+	// A Dispatch blocked on a full queue waits for the workers, so a
+	// callback on a worker cannot wait for it.
+	drain := func() {
+		r.sending.Wait()
+		for _, queue := range keyed {
+			close(queue)
+		}
+		close(shared)
+	}
+	if wait {
+		drain()
+	} else {
+		go drain()
+	}
+}
+
+// Status returns the channel on which Notify publishes connection state
+// changes.
+func (r *Runtime) Status() <-chan client.Status {
+	return r.statusChannel()
+}
+
+func (r *Runtime) statusChannel() chan client.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == nil {
+		r.status = make(chan client.Status, statusBuffer)
+	}
+	return r.status
+}
+
+// Notify publishes s without blocking, dropping it when the status channel
+// is full. A Connected status following a lost connection counts as a
+// reconnect.
+func (r *Runtime) Notify(s client.Status) {
+	r.mu.Lock()
+	reconnected := s.State == client.Connected && r.lost
+	r.lost = s.State != client.Connected
+	r.mu.Unlock()
+	if reconnected {
+		r.count(metrics.Reconnects)
+	}
+	s.Err = r.Redact(s.Err)
+	if s.Err != nil {
+		r.Log().Warn("Connection state changed", "state", s.State, "attempt", s.Attempt, "err", s.Err)
+	} else {
+		r.Log().Info("Connection state changed", "state", s.State, "attempt", s.Attempt)
+	}
+	select {
+	case r.statusChannel() <- s:
+	default:
+	}
+}
@@ -4,22 +4,33 @@ import (
 	gredis "github.com/go-redis/redis"
 )
 
+// Duplicate is the original Redis set based check.
+//
+// Deprecated: use a Deduplicator. Duplicate is one itself, backed by
+// RedisDedup in the "duplicateEntryCheck" namespace.
 type Duplicate struct {
 	RedisConn *gredis.Client
-	UniqueID  string
+	// Deprecated: sharing a Duplicate between goroutines races on UniqueID.
+	// Pass the ID to Seen instead.
+	UniqueID string
 }
 
-func (d Duplicate) IsDuplicate() bool {
+// Seen reports whether id has been seen before. An empty id never has.
+func (d Duplicate) Seen(id string) bool {
 	if d.RedisConn == nil {
 		return false
 	}
-	topic := getSetName()
-	if d.RedisConn.SIsMember(topic, d.UniqueID).Val() {
-		d.RedisConn.SRem(topic, d.UniqueID)
-		return true
+	dedup := &RedisDedup{
+		Client:    d.RedisConn,
+		Namespace: getSetName(),
+		TTL:       DefaultDedupTTL,
 	}
-	d.RedisConn.SAdd(topic, d.UniqueID)
-	return false
+	return dedup.Seen(id)
+}
+
+// IsDuplicate reports whether UniqueID has been seen before.
+func (d Duplicate) IsDuplicate() bool {
+	return d.Seen(d.UniqueID)
 }
 
 func getSetName() string {
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amagimedia/judo/v3/client"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+)
+
+// Publisher returns a publisher.Middleware that wraps every publish in a
+// producer span and injects its context into the traceparent header. A
+// traceparent already in the headers, such as one copied from a received
+// message, becomes the parent of the span.
+func Publisher(t Tracer) publisher.Middleware {
+	return func(next publisher.PublishFunc) publisher.PublishFunc {
+		return func(subject string, msg []byte, headers map[string]string) error {
+			parent, _ := jmsg.ParseTraceparent(headers[jmsg.TraceparentHeader])
+			span := t.Start(subject+" publish", Producer, parent)
+			defer span.End()
+
+			traced := make(map[string]string, len(headers)+1)
+			for key, val := range headers {
+				traced[key] = val
+			}
+			traced[jmsg.TraceparentHeader] = span.Context().Traceparent()
+			err := next(subject, msg, traced)
+			if err != nil {
+				span.SetError(err)
+			}
+			return err
+		}
+	}
+}
+
+// Subscriber returns a client.Middleware that wraps every handler call in a
+// consumer span, child of the span context the message arrived with. The
+// traceparent header of the message is replaced with the context of the new
+// span, which message.SpanContextOf returns to the handler. Nacks mark the
+// span as failed.
+func Subscriber(t Tracer, name string) client.Middleware {
+	return func(next client.Handler) client.Handler {
+		return func(msg jmsg.Message) {
+			span := t.Start(name+" process", Consumer, jmsg.SpanContextOf(msg))
+			defer span.End()
+			msg.SetHeader(jmsg.TraceparentHeader, span.Context().Traceparent())
+			next(&tracedMessage{msg, span})
+		}
+	}
+}
+
+type tracedMessage struct {
+	jmsg.Message
+	span Span
+}
+
+func (m *tracedMessage) SendNack(reply ...[]byte) {
+	err := errors.New("Message nacked")
+	if len(reply) > 0 && len(reply[0]) > 0 {
+		err = errors.New(string(reply[0]))
+	}
+	m.span.SetError(err)
+	m.Message.SendNack(reply...)
+}
+
+// Context returns the context of the traced message, see jmsg.ContextOf.
+func (m *tracedMessage) Context() context.Context {
+	return jmsg.ContextOf(m.Message)
+}
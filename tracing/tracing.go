@@ -0,0 +1,118 @@
+// Package tracing follows messages across judo publishers and subscribers
+// with W3C trace context headers.
+package tracing
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	jmsg "github.com/amagimedia/judo/v3/message"
+)
+
+// Kind tells whether a span sends or processes a message.
+type Kind int
+
+const (
+	Producer Kind = iota
+	Consumer
+)
+
+// Span is an operation in progress. End must be called exactly once.
+type Span interface {
+	Context() jmsg.SpanContext
+	SetError(error)
+	End()
+}
+
+// Tracer starts spans. Spans with an invalid parent start a new trace.
+type Tracer interface {
+	Start(name string, kind Kind, parent jmsg.SpanContext) Span
+}
+
+// SpanData describes an ended span.
+type SpanData struct {
+	Name    string
+	Kind    Kind
+	Context jmsg.SpanContext
+	Parent  jmsg.SpanContext
+	Start   time.Time
+	End     time.Time
+	Err     error
+}
+
+// Exporter receives every span once it ends.
+type Exporter interface {
+	Export(SpanData)
+}
+
+// New returns a Tracer passing its spans to e. New traces are sampled;
+// others keep the flags of their parent.
+func New(e Exporter) Tracer {
+	return &tracer{e}
+}
+
+type tracer struct {
+	exporter Exporter
+}
+
+func (t *tracer) Start(name string, kind Kind, parent jmsg.SpanContext) Span {
+	sc := jmsg.SpanContext{Flags: 1}
+	if parent.IsValid() {
+		sc.TraceID, sc.Flags = parent.TraceID, parent.Flags
+	} else {
+		parent = jmsg.SpanContext{}
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+	return &span{exporter: t.exporter, data: SpanData{
+		Name:    name,
+		Kind:    kind,
+		Context: sc,
+		Parent:  parent,
+		Start:   time.Now(),
+	}}
+}
+
+type span struct {
+	exporter Exporter
+	mu       sync.Mutex
+	data     SpanData
+}
+
+func (s *span) Context() jmsg.SpanContext {
+	return s.data.Context
+}
+
+func (s *span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.exporter.Export(data)
+}
+
+// InMemoryExporter keeps ended spans in memory, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *InMemoryExporter) Export(s SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amagimedia/judo/v3/client"
+	jmsg "github.com/amagimedia/judo/v3/message"
+	"github.com/amagimedia/judo/v3/publisher"
+	gredis "github.com/go-redis/redis"
+)
+
+// wire stands in for a transport: it carries headers in the envelope, as
+// the amagi publisher does, and decodes them on the other side.
+type wire struct {
+	sent [][]byte
+	err  error
+}
+
+func (w *wire) publish(subject string, msg []byte, headers map[string]string) error {
+	env := jmsg.NewEnvelope(msg)
+	env.Headers = headers
+	w.sent = append(w.sent, jmsg.Encode(env))
+	return w.err
+}
+
+func (w *wire) receive(i int) jmsg.Message {
+	env := jmsg.Decode(w.sent[i])
+	return &jmsg.RedisMessage{
+		RawMessage: jmsg.RedisRawMessage{Message: &gredis.Message{Payload: string(env.Body)}},
+		Properties: map[string]string{},
+		Header:     env.Headers,
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := New(exporter)
+	w := &wire{}
+	publish := publisher.Chain(w.publish, Publisher(tracer))
+
+	if err := publish("orders", []byte("order"), nil); err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Chain(func(msg jmsg.Message) {
+		publish("invoices", msg.GetMessage(), msg.GetHeaders())
+		msg.SendAck()
+	}, Subscriber(tracer, "orders"))
+	handler(w.receive(0))
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	order, invoice, process := spans[0], spans[1], spans[2]
+	if order.Name != "orders publish" || order.Kind != Producer || order.Parent.IsValid() {
+		t.Errorf("Unexpected root span %+v", order)
+	}
+	if process.Name != "orders process" || process.Kind != Consumer || process.Parent != order.Context {
+		t.Errorf("Unexpected consumer span %+v", process)
+	}
+	if invoice.Parent != process.Context {
+		t.Errorf("Republish not a child of the consumer span %+v", invoice)
+	}
+	for _, s := range spans {
+		if s.Context.TraceID != order.Context.TraceID || !s.Context.Sampled() || s.Err != nil {
+			t.Errorf("Span %q not in the trace: %+v", s.Name, s)
+		}
+	}
+	if sc := jmsg.SpanContextOf(w.receive(1)); sc != invoice.Context {
+		t.Errorf("Injected %+v, expected %+v", sc, invoice.Context)
+	}
+}
+
+func TestSpanErrors(t *testing.T) {
+	cases := []string{"publish-error", "nack", "untraced"}
+	for _, c := range cases {
+		exporter := &InMemoryExporter{}
+		tracer := New(exporter)
+		w := &wire{}
+		switch c {
+		case "publish-error":
+			w.err = errors.New("Unable to publish")
+			publisher.Chain(w.publish, Publisher(tracer))("orders", nil, nil)
+			if spans := exporter.Spans(); len(spans) != 1 || spans[0].Err != w.err {
+				t.Errorf("%s: error not recorded %+v", c, spans)
+			}
+		case "nack":
+			w.publish("orders", nil, nil)
+			client.Chain(func(msg jmsg.Message) {
+				msg.SendNack([]byte("bad order"))
+			}, Subscriber(tracer, "orders"))(w.receive(0))
+			spans := exporter.Spans()
+			if len(spans) != 1 || spans[0].Err == nil || spans[0].Err.Error() != "bad order" {
+				t.Errorf("%s: nack not recorded %+v", c, spans)
+			}
+		case "untraced":
+			w.publish("orders", nil, nil)
+			var seen jmsg.SpanContext
+			client.Chain(func(msg jmsg.Message) {
+				seen = jmsg.SpanContextOf(msg)
+			}, Subscriber(tracer, "orders"))(w.receive(0))
+			spans := exporter.Spans()
+			if len(spans) != 1 || spans[0].Parent.IsValid() || seen != spans[0].Context {
+				t.Errorf("%s: expected a new trace, got %+v", c, spans)
+			}
+		}
+	}
+}